@@ -0,0 +1,217 @@
+package elliptic_curve
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"runtime"
+	"sync"
+)
+
+/*
+batch verification
+
+a block carries thousands of signatures, verifying them one by one pays for a
+full multiplication each time. For Schnorr signatures BIP 340 allows to check
+all of them with one equation, with random a_0 = 1, a_1, ..., a_(u-1):
+
+	(sum(a_i * s_i)) * G = sum(a_i * R_i) + sum(a_i * e_i * P_i)
+
+which is one big multi-scalar multiplication sharing a single chain of
+doublings. Without the random a_i an attacker could make two invalid
+signatures cancel each other out. If the equation does not hold we fall back
+to checking the signatures one by one to find the invalid one.
+
+ECDSA can not be batched like this since only x of R is in the signature, the
+ECDSA verifier spreads the signatures over a pool of goroutines instead.
+*/
+
+type SchnorrBatchVerifier struct {
+	pubKeys []*Point
+	msgs    [][]byte
+	sigs    []*SchnorrSignature
+}
+
+func NewSchnorrBatchVerifier() *SchnorrBatchVerifier {
+	return &SchnorrBatchVerifier{}
+}
+
+// Add queues one signature, pubKey is the x-only public key from ParseXOnly
+func (b *SchnorrBatchVerifier) Add(pubKey *Point, msg []byte, sig *SchnorrSignature) {
+	b.pubKeys = append(b.pubKeys, pubKey)
+	b.msgs = append(b.msgs, msg)
+	b.sigs = append(b.sigs, sig)
+}
+
+func (b *SchnorrBatchVerifier) Len() int {
+	return len(b.sigs)
+}
+
+/*
+Verify returns true and -1 when every signature in the batch is valid,
+otherwise false and the index of the first invalid signature
+*/
+func (b *SchnorrBatchVerifier) Verify() (bool, int) {
+	if b.verifyBatch() {
+		return true, -1
+	}
+	return b.verifyEach()
+}
+
+func (b *SchnorrBatchVerifier) verifyEach() (bool, int) {
+	for i := range b.sigs {
+		if !b.pubKeys[i].VerifySchnorr(b.msgs[i], b.sigs[i]) {
+			return false, i
+		}
+	}
+	return true, -1
+}
+
+/*
+batchSeed hashes every input of the batch, BIP 340 allows to derive the a_i
+from it instead of asking a random number generator: an attacker has to fix
+the signatures before knowing the a_i
+*/
+func (b *SchnorrBatchVerifier) batchSeed() []byte {
+	hasher := sha256.New()
+	lenBuf := make([]byte, 8)
+	for i := range b.sigs {
+		hasher.Write(b.pubKeys[i].XOnly())
+		binary.LittleEndian.PutUint64(lenBuf, uint64(len(b.msgs[i])))
+		hasher.Write(lenBuf)
+		hasher.Write(b.msgs[i])
+		hasher.Write(b.sigs[i].Serialize())
+	}
+	return hasher.Sum(nil)
+}
+
+func (b *SchnorrBatchVerifier) verifyBatch() bool {
+	count := len(b.sigs)
+	if count == 0 {
+		return true
+	}
+
+	/*
+		points[2i] is R_i, points[2i+1] is P_i, the odd multiples tables of all
+		of them go back to affine with one single inversion
+	*/
+	points := make([]affinePoint, 2*count)
+	for i := range b.sigs {
+		pubKey := b.pubKeys[i]
+		if pubKey.x == nil || !pubKey.hasEvenY() {
+			return false
+		}
+		R, ok := liftX(b.sigs[i].r)
+		if !ok {
+			return false
+		}
+		points[2*i] = R
+		points[2*i+1].x.setBig(pubKey.x.num)
+		points[2*i+1].y.setBig(pubKey.y.num)
+	}
+
+	tableSize := 1 << (wnafWindow - 2)
+	jacobianTables := make([]jacobianPoint, 0, len(points)*tableSize)
+	for i := range points {
+		jacobianTables = append(jacobianTables, oddMultiplesJacobian(&points[i], tableSize)...)
+	}
+	tables := batchToAffine(jacobianTables)
+
+	seed := b.batchSeed()
+	indexBuf := make([]byte, 4)
+	terms := make([]straussTerm, 0, 4*count+2)
+	var sSum scalarVal
+	for i := range b.sigs {
+		var a, e, s, ae scalarVal
+		if i == 0 {
+			a = scalarVal{1, 0, 0, 0}
+		} else {
+			binary.BigEndian.PutUint32(indexBuf, uint32(i))
+			a.setBytes(TaggedHash("SchnorrBatchVerifier", seed, indexBuf))
+		}
+		e.setBytes(TaggedHash("BIP0340/challenge", intTo32Bytes(b.sigs[i].r), b.pubKeys[i].XOnly(), b.msgs[i]))
+		s.setBig(b.sigs[i].s)
+		//sum(a_i * s_i)
+		s.mul(&a, &s)
+		sSum.add(&sSum, &s)
+		ae.mul(&a, &e)
+
+		rTable := tables[2*i*tableSize : (2*i+1)*tableSize]
+		pTable := tables[(2*i+1)*tableSize : (2*i+2)*tableSize]
+		terms = appendGLVTerms(terms, rTable, endomorphismTable(rTable), a.toBig(), wnafWindow)
+		terms = appendGLVTerms(terms, pTable, endomorphismTable(pTable), ae.toBig(), wnafWindow)
+	}
+
+	//move the G term to the right side, the whole sum must be identity
+	generatorOddTableOnce.Do(initGeneratorOddTable)
+	var minusSSum scalarVal
+	minusSSum.negate(&sSum)
+	terms = appendGLVTerms(terms, generatorOddTable, generatorLambdaOddTable, minusSSum.toBig(), generatorWindow)
+
+	total := straussMulVartime(terms)
+	return total.isIdentity()
+}
+
+// appendGLVTerms splits k into k1 + k2 * lambda and appends k1 * P, k2 * (lambda * P)
+func appendGLVTerms(terms []straussTerm, table []affinePoint, lambdaTable []affinePoint, k *big.Int, window uint) []straussTerm {
+	k1, k2 := splitScalar(k)
+	return append(terms, newStraussTerm(table, k1, window), newStraussTerm(lambdaTable, k2, window))
+}
+
+type ECDSABatchVerifier struct {
+	pubKeys []*Point
+	zs      []*FieldElement
+	sigs    []*Signature
+	workers int
+}
+
+// NewECDSABatchVerifier workers <= 0 uses one goroutine for every CPU
+func NewECDSABatchVerifier(workers int) *ECDSABatchVerifier {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &ECDSABatchVerifier{
+		workers: workers,
+	}
+}
+
+func (b *ECDSABatchVerifier) Add(pubKey *Point, z *FieldElement, sig *Signature) {
+	b.pubKeys = append(b.pubKeys, pubKey)
+	b.zs = append(b.zs, z)
+	b.sigs = append(b.sigs, sig)
+}
+
+func (b *ECDSABatchVerifier) Len() int {
+	return len(b.sigs)
+}
+
+/*
+Verify returns true and -1 when every signature in the batch is valid,
+otherwise false and the index of the first invalid signature
+*/
+func (b *ECDSABatchVerifier) Verify() (bool, int) {
+	results := make([]bool, len(b.sigs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < b.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = b.pubKeys[i].Verify(b.zs[i], b.sigs[i])
+			}
+		}()
+	}
+	for i := range b.sigs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, ok := range results {
+		if !ok {
+			return false, i
+		}
+	}
+	return true, -1
+}
@@ -0,0 +1,351 @@
+package elliptic_curve
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Bech32 (BIP 173) and Bech32m (BIP 350)
+
+segwit addresses are not base58, they are human readable part (bc for main-net,
+tb for testnet and signet, bcrt for regtest) + separator '1' + data encoded 5
+bits per char + 6 chars of checksum. The checksum is a BCH code, it does not
+only detect errors like the 4 bytes hash of Base58Checksum does, it can also
+locate them.
+
+the data is the witness version (one 5 bits value) followed by the witness
+program converted from 8 bits bytes to 5 bits groups.
+
+Bech32 has a weakness, inserting or deleting q right before a final p keeps
+the checksum valid. Witness v0 programs have fixed lengths so it does not
+matter for them, but from witness v1 (taproot) on addresses use Bech32m which
+only differs in the constant xored into the checksum.
+*/
+
+type Bech32Variant uint32
+
+const (
+	Bech32  Bech32Variant = 1
+	Bech32m Bech32Variant = 0x2bc830a3
+)
+
+const (
+	MainnetHrp = "bc"
+	TestnetHrp = "tb"
+	SignetHrp  = "tb"
+	RegtestHrp = "bcrt"
+)
+
+const (
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32MaxLen   = 90
+	bech32Checksum = 6
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+var (
+	ErrBech32Length          = errors.New("bech32 string should be at most 90 chars")
+	ErrBech32Char            = errors.New("invalid char in bech32 string")
+	ErrBech32MixedCase       = errors.New("bech32 string mixes upper and lower case")
+	ErrBech32Separator       = errors.New("bech32 separator '1' is missing or misplaced")
+	ErrBech32Checksum        = errors.New("bech32 checksum mismatch")
+	ErrWitnessVersion        = errors.New("witness version should be 0 to 16")
+	ErrWitnessProgramLength  = errors.New("invalid witness program length")
+	ErrWitnessProgramPadding = errors.New("invalid padding of witness program")
+	ErrSegwitVariant         = errors.New("witness v0 address should use bech32, v1+ should use bech32m")
+)
+
+/*
+Bech32Error
+a decoding error with the indexes of the wrong chars in the string, checksum
+errors are located when there are at most 2 of them
+*/
+type Bech32Error struct {
+	Err       error
+	Positions []int
+}
+
+func (e *Bech32Error) Error() string {
+	if len(e.Positions) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v at %v", e.Err, e.Positions)
+}
+
+func (e *Bech32Error) Unwrap() error {
+	return e.Err
+}
+
+func bech32PolymodStep(chk uint32, v byte) uint32 {
+	top := chk >> 25
+	chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+	for i := 0; i < 5; i++ {
+		if (top>>uint(i))&1 == 1 {
+			chk ^= bech32Generator[i]
+		}
+	}
+	return chk
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		chk = bech32PolymodStep(chk, v)
+	}
+	return chk
+}
+
+// bech32HrpExpand high bits of every char, 0, low bits of every char
+func bech32HrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+func bech32CreateChecksum(hrp string, data []byte, variant Bech32Variant) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ uint32(variant)
+	checksum := make([]byte, bech32Checksum)
+	for i := 0; i < bech32Checksum; i++ {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// Bech32Encode data are 5 bits values, hrp is turned into lower case
+func Bech32Encode(hrp string, data []byte, variant Bech32Variant) (string, error) {
+	if len(hrp) == 0 || len(hrp)+1+len(data)+bech32Checksum > bech32MaxLen {
+		return "", ErrBech32Length
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", &Bech32Error{Err: ErrBech32Char, Positions: []int{i}}
+		}
+	}
+	for _, v := range data {
+		if v >= 32 {
+			return "", fmt.Errorf("value %d does not fit in 5 bits", v)
+		}
+	}
+
+	hrp = strings.ToLower(hrp)
+	combined := append(append([]byte{}, data...), bech32CreateChecksum(hrp, data, variant)...)
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte('1')
+	for _, v := range combined {
+		builder.WriteByte(bech32Charset[v])
+	}
+	return builder.String(), nil
+}
+
+/*
+Bech32Decode
+splits a bech32 or bech32m string into its lower case human readable part and
+5 bits data values without checksum, the variant tells which checksum matches
+*/
+func Bech32Decode(s string) (string, []byte, Bech32Variant, error) {
+	if len(s) > bech32MaxLen {
+		return "", nil, 0, ErrBech32Length
+	}
+	var badChars []int
+	hasLower, hasUpper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 33 || c > 126:
+			badChars = append(badChars, i)
+		case c >= 'a' && c <= 'z':
+			hasLower = true
+		case c >= 'A' && c <= 'Z':
+			hasUpper = true
+		}
+	}
+	if len(badChars) > 0 {
+		return "", nil, 0, &Bech32Error{Err: ErrBech32Char, Positions: badChars}
+	}
+	if hasLower && hasUpper {
+		return "", nil, 0, ErrBech32MixedCase
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+1+bech32Checksum > len(s) {
+		positions := []int{}
+		if sep >= 0 {
+			positions = append(positions, sep)
+		}
+		return "", nil, 0, &Bech32Error{Err: ErrBech32Separator, Positions: positions}
+	}
+	hrp := s[:sep]
+	values := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		idx := strings.IndexByte(bech32Charset, s[i])
+		if idx == -1 {
+			badChars = append(badChars, i)
+			continue
+		}
+		values = append(values, byte(idx))
+	}
+	if len(badChars) > 0 {
+		return "", nil, 0, &Bech32Error{Err: ErrBech32Char, Positions: badChars}
+	}
+
+	polymod := bech32Polymod(append(bech32HrpExpand(hrp), values...))
+	switch Bech32Variant(polymod) {
+	case Bech32, Bech32m:
+		return hrp, values[:len(values)-bech32Checksum], Bech32Variant(polymod), nil
+	}
+
+	/*
+		try to locate the errors assuming either checksum, Bitcoin Core does the
+		same and prefers the variant needing fewer changes
+	*/
+	var positions []int
+	for _, variant := range []Bech32Variant{Bech32m, Bech32} {
+		located := bech32LocateErrors(len(values), polymod^uint32(variant))
+		if len(located) > 0 && (len(positions) == 0 || len(located) < len(positions)) {
+			positions = located
+		}
+	}
+	for i := range positions {
+		positions[i] += sep + 1
+	}
+	return "", nil, 0, &Bech32Error{Err: ErrBech32Checksum, Positions: positions}
+}
+
+/*
+bech32LocateErrors
+the checksum is linear: changing data value i by xor with e changes the
+polymod by the polymod of e followed by as many zeros as values after i,
+started from 0 instead of 1. residue is the polymod xor the expected constant,
+if one or two changed values explain it we know where the errors are, a BCH
+code with distance 5 can not explain it in two ways. Returns the indexes of
+the wrong values in the data part, nil if more than 2 errors.
+*/
+func bech32LocateErrors(dataLen int, residue uint32) []int {
+	//the polymod change of every single value change, with its position
+	changes := make(map[uint32]int, dataLen*31)
+	for e := byte(1); e < 32; e++ {
+		chk := uint32(e)
+		for position := dataLen - 1; position >= 0; position-- {
+			changes[chk] = position
+			chk = bech32PolymodStep(chk, 0)
+		}
+	}
+
+	if position, ok := changes[residue]; ok {
+		return []int{position}
+	}
+	for effect, first := range changes {
+		second, ok := changes[residue^effect]
+		if ok && second != first {
+			positions := []int{first, second}
+			sort.Ints(positions)
+			return positions
+		}
+	}
+	return nil
+}
+
+/*
+convertBits regroups a bytes stream of fromBits bits values into toBits bits
+values, pad fills the last group with zero bits
+*/
+func convertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxV := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("value %d does not fit in %d bits", v, fromBits)
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxV))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxV))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxV != 0 {
+		return nil, ErrWitnessProgramPadding
+	}
+	return result, nil
+}
+
+func checkWitnessProgram(version byte, program []byte) error {
+	if version > 16 {
+		return ErrWitnessVersion
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return ErrWitnessProgramLength
+	}
+	//v0 is P2WPKH with 20 bytes or P2WSH with 32 bytes
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return ErrWitnessProgramLength
+	}
+	return nil
+}
+
+/*
+EncodeSegwitAddress
+address for the given witness version and program, bech32 for v0 and bech32m
+for v1 to v16
+*/
+func EncodeSegwitAddress(hrp string, version byte, program []byte) (string, error) {
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", err
+	}
+	data, err := convertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	variant := Bech32m
+	if version == 0 {
+		variant = Bech32
+	}
+	return Bech32Encode(hrp, append([]byte{version}, data...), variant)
+}
+
+/*
+DecodeSegwitAddress
+the human readable part, witness version and program of a segwit address, the
+caller checks the human readable part belongs to the expected network
+*/
+func DecodeSegwitAddress(address string) (string, byte, []byte, error) {
+	hrp, data, variant, err := Bech32Decode(address)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(data) == 0 {
+		return "", 0, nil, ErrWitnessProgramLength
+	}
+	version := data[0]
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", 0, nil, err
+	}
+	if (version == 0) != (variant == Bech32) {
+		return "", 0, nil, ErrSegwitVariant
+	}
+	return hrp, version, program, nil
+}
@@ -0,0 +1,317 @@
+package elliptic_curve
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+Passphrase protected private key (BIP 38)
+
+a private key encrypted with a passphrase, base58 check encoded so it starts
+with 6P and can be printed on a paper wallet. Every encrypted key carries
+
+	addresshash = first 4 bytes of hash256(P2PKH address of the key)
+
+which is the salt of the key derivation and lets the decrypter tell a wrong
+passphrase. There are two modes:
+
+non EC multiplied, the owner has the private key:
+
+	derived = scrypt(passphrase, addresshash, N=16384, r=8, p=8, 64 bytes)
+	encrypted = aes256(secret xor derived[0:32], key: derived[32:64])
+	0x01 0x42 || flag || addresshash || encrypted
+
+EC multiplied, a printer generates keys without learning them. The owner
+gives an intermediate code holding passpoint = passfactor * G where passfactor
+comes from scrypt of the passphrase, the printer picks a random seedb and the
+key is secret = passfactor * hash256(seedb), whose public key the printer can
+compute as hash256(seedb) * passpoint. Only seedb is encrypted, with a key
+derived from the passpoint:
+
+	derived = scrypt(passpoint, addresshash || ownerentropy, N=1024, r=1, p=1, 64 bytes)
+	0x01 0x43 || flag || addresshash || ownerentropy || encrypted seedb
+
+the flag byte has 0x20 for a compressed public key and 0x04 when the owner
+entropy holds a lot and sequence number. Passphrases are unicode NFC.
+*/
+
+const (
+	bip38FlagNonEC      = 0xc0
+	bip38FlagCompressed = 0x20
+	bip38FlagLot        = 0x04
+	bip38EncryptedLen   = 39
+	bip38MaxLot         = 1048575
+	bip38MaxSequence    = 4095
+)
+
+var (
+	bip38NonECPrefix     = []byte{0x01, 0x42}
+	bip38ECPrefix        = []byte{0x01, 0x43}
+	bip38MagicLot        = []byte{0x2c, 0xe9, 0xb3, 0xe1, 0xff, 0x39, 0xe2, 0x51}
+	bip38MagicNoLot      = []byte{0x2c, 0xe9, 0xb3, 0xe1, 0xff, 0x39, 0xe2, 0x53}
+	bip38IntermediateLen = len(bip38MagicLot) + 8 + 33
+)
+
+var (
+	ErrBIP38Length       = errors.New("bip38 encrypted key should be 39 bytes")
+	ErrBIP38Prefix       = errors.New("unknown bip38 prefix")
+	ErrBIP38Flag         = errors.New("invalid bip38 flag byte")
+	ErrBIP38Passphrase   = errors.New("bip38 passphrase is wrong")
+	ErrBIP38Intermediate = errors.New("invalid bip38 intermediate code")
+	ErrBIP38LotSequence  = errors.New("bip38 lot should be at most 1048575 and sequence at most 4095")
+)
+
+func bip38AddressHash(pubKey *Point, compressed bool, params *ChainParams) []byte {
+	return Hash256(pubKey.Address(compressed, params))[:4]
+}
+
+func bip38Passphrase(passphrase string) []byte {
+	return []byte(norm.NFC.String(passphrase))
+}
+
+func scryptKey(password []byte, salt []byte, n int, r int, p int, keyLen int) []byte {
+	key, err := scrypt.Key(password, salt, n, r, p, keyLen)
+	if err != nil {
+		panic(fmt.Sprintf("scrypt err: %v\n", err))
+	}
+	return key
+}
+
+func xorBytes(a []byte, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
+
+// aes256Block encrypts or decrypts one 16 bytes block, no chaining is needed for 32 bytes
+func aes256Block(key []byte, block []byte, decrypt bool) []byte {
+	cipher, err := aes.NewCipher(key)
+	if err != nil {
+		panic(fmt.Sprintf("aes err: %v\n", err))
+	}
+	result := make([]byte, 16)
+	if decrypt {
+		cipher.Decrypt(result, block)
+	} else {
+		cipher.Encrypt(result, block)
+	}
+	return result
+}
+
+/*
+EncryptBIP38
+the non EC multiplied encrypted key, compressed and params select the address
+whose hash is committed, the same arguments as for Wif
+*/
+func (p *PrivateKey) EncryptBIP38(passphrase string, compressed bool, params *ChainParams) string {
+	addressHash := bip38AddressHash(p.point, compressed, params)
+	derived := scryptKey(bip38Passphrase(passphrase), addressHash, 16384, 8, 8, 64)
+	secret := p.Bytes()
+	encrypted := xorBytes(secret, derived[:32])
+
+	flag := byte(bip38FlagNonEC)
+	if compressed {
+		flag |= bip38FlagCompressed
+	}
+	result := append([]byte{}, bip38NonECPrefix...)
+	result = append(result, flag)
+	result = append(result, addressHash...)
+	result = append(result, aes256Block(derived[32:], encrypted[:16], false)...)
+	result = append(result, aes256Block(derived[32:], encrypted[16:], false)...)
+	return Base58Checksum(result)
+}
+
+/*
+DecryptBIP38
+decrypts both modes, returns the private key and whether its address uses the
+compressed public key. A wrong passphrase gives ErrBIP38Passphrase
+*/
+func DecryptBIP38(encrypted string, passphrase string, params *ChainParams) (*PrivateKey, bool, error) {
+	payload, err := DecodeBase58Check(encrypted)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(payload) != bip38EncryptedLen {
+		return nil, false, ErrBIP38Length
+	}
+	flag := payload[2]
+	compressed := flag&bip38FlagCompressed != 0
+	addressHash := payload[3:7]
+
+	var privateKey *PrivateKey
+	switch {
+	case bytes.Equal(payload[:2], bip38NonECPrefix):
+		if flag&^bip38FlagCompressed != bip38FlagNonEC {
+			return nil, false, ErrBIP38Flag
+		}
+		privateKey, err = decryptBIP38NonEC(payload, passphrase)
+	case bytes.Equal(payload[:2], bip38ECPrefix):
+		if flag&^(bip38FlagCompressed|bip38FlagLot) != 0 {
+			return nil, false, ErrBIP38Flag
+		}
+		privateKey, err = decryptBIP38EC(payload, passphrase)
+	default:
+		return nil, false, ErrBIP38Prefix
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !bytes.Equal(bip38AddressHash(privateKey.point, compressed, params), addressHash) {
+		return nil, false, ErrBIP38Passphrase
+	}
+	return privateKey, compressed, nil
+}
+
+func decryptBIP38NonEC(payload []byte, passphrase string) (*PrivateKey, error) {
+	derived := scryptKey(bip38Passphrase(passphrase), payload[3:7], 16384, 8, 8, 64)
+	secret := append(aes256Block(derived[32:], payload[7:23], true), aes256Block(derived[32:], payload[23:39], true)...)
+	privateKey, err := ParsePrivateKey(xorBytes(secret, derived[:32]))
+	if err != nil {
+		//out of range secret, only possible with a wrong passphrase
+		return nil, ErrBIP38Passphrase
+	}
+	return privateKey, nil
+}
+
+// bip38PassFactor passfactor of the owner, ownerEntropy holds the lot and sequence if lot is true
+func bip38PassFactor(passphrase string, ownerEntropy []byte, lot bool) []byte {
+	ownerSalt := ownerEntropy
+	if lot {
+		ownerSalt = ownerEntropy[:4]
+	}
+	passFactor := scryptKey(bip38Passphrase(passphrase), ownerSalt, 16384, 8, 8, 32)
+	if lot {
+		passFactor = Hash256(string(append(passFactor, ownerEntropy...)))
+	}
+	return passFactor
+}
+
+func decryptBIP38EC(payload []byte, passphrase string) (*PrivateKey, error) {
+	addressHash := payload[3:7]
+	ownerEntropy := payload[7:15]
+	passFactor, err := ParsePrivateKey(bip38PassFactor(passphrase, ownerEntropy, payload[2]&bip38FlagLot != 0))
+	if err != nil {
+		return nil, ErrBIP38Passphrase
+	}
+	_, passPoint := passFactor.point.Sec(true)
+	derived := scryptKey(passPoint, append(append([]byte{}, addressHash...), ownerEntropy...), 1024, 1, 1, 64)
+
+	//encryptedpart2 decrypts to encryptedpart1[8:16] || seedb[16:24]
+	part2 := xorBytes(aes256Block(derived[32:], payload[23:39], true), derived[16:32])
+	encryptedPart1 := append(append([]byte{}, payload[15:23]...), part2[:8]...)
+	part1 := xorBytes(aes256Block(derived[32:], encryptedPart1, true), derived[:16])
+	seedB := append(part1, part2[8:]...)
+
+	var secret, factorB scalarVal
+	secret.setBig(passFactor.secret)
+	factorB.setBytes(Hash256(string(seedB)))
+	secret.mul(&secret, &factorB)
+	if secret.isZero() == 1 {
+		return nil, ErrBIP38Passphrase
+	}
+	return NewPrivateKey(secret.toBig()), nil
+}
+
+/*
+NewBIP38Intermediate
+the intermediate code (passphrase...) the owner hands to the printer, lot and
+sequence are written into the owner entropy when withLot is true
+*/
+func NewBIP38Intermediate(passphrase string, withLot bool, lot uint32, sequence uint32) (string, error) {
+	ownerEntropy := make([]byte, 8)
+	randomLen := 8
+	if withLot {
+		if lot > bip38MaxLot || sequence > bip38MaxSequence {
+			return "", ErrBIP38LotSequence
+		}
+		randomLen = 4
+		binary.BigEndian.PutUint32(ownerEntropy[4:], lot*4096+sequence)
+	}
+	if _, err := rand.Read(ownerEntropy[:randomLen]); err != nil {
+		return "", err
+	}
+
+	passFactor, err := ParsePrivateKey(bip38PassFactor(passphrase, ownerEntropy, withLot))
+	if err != nil {
+		//probability below 2^-127, the caller may try again
+		return "", err
+	}
+	_, passPoint := passFactor.point.Sec(true)
+	magic := bip38MagicNoLot
+	if withLot {
+		magic = bip38MagicLot
+	}
+	result := append(append([]byte{}, magic...), ownerEntropy...)
+	return Base58Checksum(append(result, passPoint...)), nil
+}
+
+/*
+EncryptBIP38Intermediate
+the printer side of the EC multiplied mode: a new encrypted key for the owner
+of intermediate, returned with its address. The printer never knows the key.
+*/
+func EncryptBIP38Intermediate(intermediate string, compressed bool, params *ChainParams) (string, string, error) {
+	payload, err := DecodeBase58Check(intermediate)
+	if err != nil {
+		return "", "", err
+	}
+	if len(payload) != bip38IntermediateLen {
+		return "", "", ErrBIP38Intermediate
+	}
+	flag := byte(0)
+	switch {
+	case bytes.Equal(payload[:8], bip38MagicLot):
+		flag |= bip38FlagLot
+	case bytes.Equal(payload[:8], bip38MagicNoLot):
+	default:
+		return "", "", ErrBIP38Intermediate
+	}
+	ownerEntropy := payload[8:16]
+	passPoint, err := ParseSEC(payload[16:])
+	if err != nil {
+		return "", "", ErrBIP38Intermediate
+	}
+
+	var factorB *big.Int
+	seedB := make([]byte, 24)
+	for {
+		if _, err := rand.Read(seedB); err != nil {
+			return "", "", err
+		}
+		factorB = new(big.Int).SetBytes(Hash256(string(seedB)))
+		if factorB.Sign() > 0 && factorB.Cmp(GetBitcoinValueN()) < 0 {
+			break
+		}
+	}
+	pubKey := passPoint.ScalarMul(factorB)
+	address := pubKey.Address(compressed, params)
+	addressHash := Hash256(address)[:4]
+	derived := scryptKey(payload[16:], append(append([]byte{}, addressHash...), ownerEntropy...), 1024, 1, 1, 64)
+
+	encryptedPart1 := aes256Block(derived[32:], xorBytes(seedB[:16], derived[:16]), false)
+	encryptedPart2 := aes256Block(derived[32:],
+		xorBytes(append(append([]byte{}, encryptedPart1[8:]...), seedB[16:]...), derived[16:32]), false)
+
+	if compressed {
+		flag |= bip38FlagCompressed
+	}
+	result := append([]byte{}, bip38ECPrefix...)
+	result = append(result, flag)
+	result = append(result, addressHash...)
+	result = append(result, ownerEntropy...)
+	result = append(result, encryptedPart1[:8]...)
+	result = append(result, encryptedPart2...)
+	return Base58Checksum(result), address, nil
+}
@@ -0,0 +1,367 @@
+package elliptic_curve
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+/*
+ChainParams
+everything that differs between the networks sharing the bitcoin protocol:
+
+	magic        first 4 bytes of every p2p message
+	prefixes     base58 version bytes of P2PKH, P2SH, WIF and the BIP 32
+	             xprv/xpub versions, bech32 hrp of segwit addresses
+	genesis      the 80 bytes header of block 0
+	difficulty   pow limit and retargeting, testnet3 and testnet4 allow a
+	             block at minimum difficulty after 20 minutes without one,
+	             testnet4 adds the BIP 94 rules, regtest never retargets
+	deployments  heights after which BIP 34, 65, 66, CSV (BIP 68, 112, 113)
+	             and segwit are enforced, the buried deployments of Bitcoin Core
+
+The values are those of Bitcoin Core, signet is the default signet. Use the
+package variables, a nil *ChainParams is main-net.
+*/
+type ChainParams struct {
+	name        string
+	magic       [4]byte
+	defaultPort uint16
+
+	pubKeyHashAddrID byte
+	scriptHashAddrID byte
+	privateKeyID     byte
+	hdPrivateKeyID   [4]byte
+	hdPublicKeyID    [4]byte
+	bech32Hrp        string
+
+	genesisBlock []byte
+
+	powLimit           *big.Int
+	powLimitBits       uint32
+	targetTimespan     int64
+	targetSpacing      int64
+	allowMinDifficulty bool
+	noRetargeting      bool
+	enforceBIP94       bool
+
+	bip34Height   int64
+	bip65Height   int64
+	bip66Height   int64
+	csvHeight     int64
+	segwitHeight  int64
+	taprootHeight int64
+}
+
+const (
+	//two weeks, 2016 blocks of 10 minutes
+	targetTimespan = 14 * 24 * 60 * 60
+	targetSpacing  = 10 * 60
+)
+
+var (
+	mainnetHDPrivate = [4]byte{0x04, 0x88, 0xad, 0xe4} //xprv
+	mainnetHDPublic  = [4]byte{0x04, 0x88, 0xb2, 0x1e} //xpub
+	testnetHDPrivate = [4]byte{0x04, 0x35, 0x83, 0x94} //tprv
+	testnetHDPublic  = [4]byte{0x04, 0x35, 0x87, 0xcf} //tpub
+)
+
+var MainNetParams = &ChainParams{
+	name:             "mainnet",
+	magic:            [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+	defaultPort:      8333,
+	pubKeyHashAddrID: 0x00,
+	scriptHashAddrID: 0x05,
+	privateKeyID:     0x80,
+	hdPrivateKeyID:   mainnetHDPrivate,
+	hdPublicKeyID:    mainnetHDPublic,
+	bech32Hrp:        MainnetHrp,
+	genesisBlock:     mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c"),
+	powLimit:         mustParseTarget("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	powLimitBits:     0x1d00ffff,
+	targetTimespan:   targetTimespan,
+	targetSpacing:    targetSpacing,
+	bip34Height:      227931,
+	bip65Height:      388381,
+	bip66Height:      363725,
+	csvHeight:        419328,
+	segwitHeight:     481824,
+	taprootHeight:    709632,
+}
+
+var TestNet3Params = &ChainParams{
+	name:               "testnet3",
+	magic:              [4]byte{0x0b, 0x11, 0x09, 0x07},
+	defaultPort:        18333,
+	pubKeyHashAddrID:   0x6f,
+	scriptHashAddrID:   0xc4,
+	privateKeyID:       0xef,
+	hdPrivateKeyID:     testnetHDPrivate,
+	hdPublicKeyID:      testnetHDPublic,
+	bech32Hrp:          TestnetHrp,
+	genesisBlock:       mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff001d1aa4ae18"),
+	powLimit:           mustParseTarget("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	powLimitBits:       0x1d00ffff,
+	targetTimespan:     targetTimespan,
+	targetSpacing:      targetSpacing,
+	allowMinDifficulty: true,
+	bip34Height:        21111,
+	bip65Height:        581885,
+	bip66Height:        330776,
+	csvHeight:          770112,
+	segwitHeight:       834624,
+	taprootHeight:      2011968,
+}
+
+var TestNet4Params = &ChainParams{
+	name:               "testnet4",
+	magic:              [4]byte{0x1c, 0x16, 0x3f, 0x28},
+	defaultPort:        48333,
+	pubKeyHashAddrID:   0x6f,
+	scriptHashAddrID:   0xc4,
+	privateKeyID:       0xef,
+	hdPrivateKeyID:     testnetHDPrivate,
+	hdPublicKeyID:      testnetHDPublic,
+	bech32Hrp:          TestnetHrp,
+	genesisBlock:       mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000004e7b2b9128fe0291db0693af2ae418b767e657cd407e80cb1434221eaea7a07a046f3566ffff001dbb0c7817"),
+	powLimit:           mustParseTarget("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	powLimitBits:       0x1d00ffff,
+	targetTimespan:     targetTimespan,
+	targetSpacing:      targetSpacing,
+	allowMinDifficulty: true,
+	enforceBIP94:       true,
+	bip34Height:        1,
+	bip65Height:        1,
+	bip66Height:        1,
+	csvHeight:          1,
+	segwitHeight:       1,
+	taprootHeight:      0,
+}
+
+var SigNetParams = &ChainParams{
+	name:             "signet",
+	magic:            [4]byte{0x0a, 0x03, 0xcf, 0x40},
+	defaultPort:      38333,
+	pubKeyHashAddrID: 0x6f,
+	scriptHashAddrID: 0xc4,
+	privateKeyID:     0xef,
+	hdPrivateKeyID:   testnetHDPrivate,
+	hdPublicKeyID:    testnetHDPublic,
+	bech32Hrp:        SignetHrp,
+	genesisBlock:     mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a008f4d5fae77031e8ad22203"),
+	powLimit:         mustParseTarget("00000377ae000000000000000000000000000000000000000000000000000000"),
+	powLimitBits:     0x1e0377ae,
+	targetTimespan:   targetTimespan,
+	targetSpacing:    targetSpacing,
+	bip34Height:      1,
+	bip65Height:      1,
+	bip66Height:      1,
+	csvHeight:        1,
+	segwitHeight:     1,
+	taprootHeight:    0,
+}
+
+var RegTestParams = &ChainParams{
+	name:               "regtest",
+	magic:              [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+	defaultPort:        18444,
+	pubKeyHashAddrID:   0x6f,
+	scriptHashAddrID:   0xc4,
+	privateKeyID:       0xef,
+	hdPrivateKeyID:     testnetHDPrivate,
+	hdPublicKeyID:      testnetHDPublic,
+	bech32Hrp:          RegtestHrp,
+	genesisBlock:       mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff7f2002000000"),
+	powLimit:           mustParseTarget("7fffff0000000000000000000000000000000000000000000000000000000000"),
+	powLimitBits:       0x207fffff,
+	targetTimespan:     targetTimespan,
+	targetSpacing:      targetSpacing,
+	allowMinDifficulty: true,
+	noRetargeting:      true,
+	bip34Height:        1,
+	bip65Height:        1,
+	bip66Height:        1,
+	csvHeight:          1,
+	segwitHeight:       0,
+	taprootHeight:      0,
+}
+
+func mustDecodeHex(s string) []byte {
+	bin, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return bin
+}
+
+func mustParseTarget(s string) *big.Int {
+	target, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid target " + s)
+	}
+	return target
+}
+
+// AllChainParams every known network, main-net first
+func AllChainParams() []*ChainParams {
+	return []*ChainParams{MainNetParams, TestNet3Params, TestNet4Params, SigNetParams, RegTestParams}
+}
+
+// ChainParamsByName the network called mainnet, testnet3, testnet4, signet or regtest, nil if unknown
+func ChainParamsByName(name string) *ChainParams {
+	for _, params := range AllChainParams() {
+		if params.name == name {
+			return params
+		}
+	}
+	return nil
+}
+
+// orMainNet lets nil stand for main-net
+func (c *ChainParams) orMainNet() *ChainParams {
+	if c == nil {
+		return MainNetParams
+	}
+	return c
+}
+
+func (c *ChainParams) Name() string {
+	return c.orMainNet().name
+}
+
+func (c *ChainParams) String() string {
+	return c.Name()
+}
+
+// IsTestnet true for every network but main-net, their coins have no value
+func (c *ChainParams) IsTestnet() bool {
+	return c.orMainNet() != MainNetParams
+}
+
+func (c *ChainParams) Magic() []byte {
+	magic := c.orMainNet().magic
+	return magic[:]
+}
+
+func (c *ChainParams) DefaultPort() uint16 {
+	return c.orMainNet().defaultPort
+}
+
+// PubKeyHashAddrID version byte of P2PKH addresses
+func (c *ChainParams) PubKeyHashAddrID() byte {
+	return c.orMainNet().pubKeyHashAddrID
+}
+
+// ScriptHashAddrID version byte of P2SH addresses
+func (c *ChainParams) ScriptHashAddrID() byte {
+	return c.orMainNet().scriptHashAddrID
+}
+
+// PrivateKeyID version byte of WIF private keys
+func (c *ChainParams) PrivateKeyID() byte {
+	return c.orMainNet().privateKeyID
+}
+
+// HDPrivateKeyID version of BIP 32 extended private keys, xprv or tprv
+func (c *ChainParams) HDPrivateKeyID() []byte {
+	version := c.orMainNet().hdPrivateKeyID
+	return version[:]
+}
+
+// HDPublicKeyID version of BIP 32 extended public keys, xpub or tpub
+func (c *ChainParams) HDPublicKeyID() []byte {
+	version := c.orMainNet().hdPublicKeyID
+	return version[:]
+}
+
+// Bech32Hrp human readable part of segwit addresses
+func (c *ChainParams) Bech32Hrp() string {
+	return c.orMainNet().bech32Hrp
+}
+
+// GenesisBlock the serialized 80 bytes header of block 0
+func (c *ChainParams) GenesisBlock() []byte {
+	return append([]byte{}, c.orMainNet().genesisBlock...)
+}
+
+// GenesisHash hash of block 0 in the usual display order, most significant byte first
+func (c *ChainParams) GenesisHash() []byte {
+	hash := Hash256(string(c.orMainNet().genesisBlock))
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hash
+}
+
+// PowLimit the largest target, the easiest difficulty a block may have
+func (c *ChainParams) PowLimit() *big.Int {
+	return new(big.Int).Set(c.orMainNet().powLimit)
+}
+
+// PowLimitBits PowLimit in the compact bits form of block headers
+func (c *ChainParams) PowLimitBits() uint32 {
+	return c.orMainNet().powLimitBits
+}
+
+// TargetTimespan seconds the 2016 blocks between two retargets should take
+func (c *ChainParams) TargetTimespan() int64 {
+	return c.orMainNet().targetTimespan
+}
+
+// TargetSpacing seconds between two blocks the difficulty aims at
+func (c *ChainParams) TargetSpacing() int64 {
+	return c.orMainNet().targetSpacing
+}
+
+/*
+AllowMinDifficultyBlocks
+a block more than twice TargetSpacing after the previous one may have the
+minimum difficulty, the testnet rule
+*/
+func (c *ChainParams) AllowMinDifficultyBlocks() bool {
+	return c.orMainNet().allowMinDifficulty
+}
+
+// NoRetargeting the difficulty never changes
+func (c *ChainParams) NoRetargeting() bool {
+	return c.orMainNet().noRetargeting
+}
+
+/*
+EnforceBIP94
+the retarget uses the bits of the first block of the period instead of the
+last one, which may be a minimum difficulty block, and the first block of a
+period can not be more than 600 seconds older than the block before it
+*/
+func (c *ChainParams) EnforceBIP94() bool {
+	return c.orMainNet().enforceBIP94
+}
+
+// BIP34Height height from which the coinbase starts with the block height
+func (c *ChainParams) BIP34Height() int64 {
+	return c.orMainNet().bip34Height
+}
+
+// BIP65Height height from which OP_CHECKLOCKTIMEVERIFY is enforced
+func (c *ChainParams) BIP65Height() int64 {
+	return c.orMainNet().bip65Height
+}
+
+// BIP66Height height from which signatures must be strict DER
+func (c *ChainParams) BIP66Height() int64 {
+	return c.orMainNet().bip66Height
+}
+
+// CSVHeight height from which relative lock times (BIP 68, 112, 113) are enforced
+func (c *ChainParams) CSVHeight() int64 {
+	return c.orMainNet().csvHeight
+}
+
+// SegwitHeight height from which witness programs are enforced (BIP 141, 143, 147)
+func (c *ChainParams) SegwitHeight() int64 {
+	return c.orMainNet().segwitHeight
+}
+
+// TaprootHeight height from which witness v1 programs are taproot outputs (BIP 340, 341, 342)
+func (c *ChainParams) TaprootHeight() int64 {
+	return c.orMainNet().taprootHeight
+}
@@ -0,0 +1,1143 @@
+package elliptic_curve
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+)
+
+func messageHash(msg string) *big.Int {
+	h := sha256.Sum256([]byte(msg))
+	return new(big.Int).SetBytes(h[:])
+}
+
+func TestSignRFC6979(t *testing.T) {
+	nMinusOne := new(big.Int).Sub(GetBitcoinValueN(), big.NewInt(1))
+	vectors := []struct {
+		secret *big.Int
+		msg    string
+		k      string
+		der    string
+	}{
+		{
+			secret: big.NewInt(1),
+			msg:    "Satoshi Nakamoto",
+			k:      "8f8a276c19f4149656b280621e358cce24f5f52542772691ee69063b74f15d15",
+			der:    "3045022100934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d802202442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5",
+		},
+		{
+			secret: big.NewInt(1),
+			msg:    "All those moments will be lost in time, like tears in rain. Time to die...",
+			k:      "38aa22d72376b4dbc472e06c3ba403ee0a394da63fc58d88686c611aba98d6b3",
+			der:    "30450221008600dbd41e348fe5c9465ab92d23e3db8b98b873beecd930736488696438cb6b0220547fe64427496db33bf66019dacbf0039c04199abb0122918601db38a72cfc21",
+		},
+		{
+			secret: nMinusOne,
+			msg:    "Satoshi Nakamoto",
+			k:      "33a19b60e25fb6f4435af53a3d42d493644827367e6453928554f43e49aa6f90",
+			der:    "3045022100fd567d121db66e382991534ada77a6bd3106f0a1098c231e47993447cd6af2d002206b39cd0eb1bc8603e159ef5c20a5c8ad685a45b06ce9bebed3f153d10d93bed5",
+		},
+		{
+			secret: hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181"),
+			msg:    "Alan Turing",
+			k:      "525a82b70e67874398067543fd84c83d30c175fdc45fdeee082fe13b1d7cfdf1",
+			der:    "304402207063ae83e7f62bbb171798131b4a0564b956930092b33b07b395615d9ec7e15c022058dfcc1e00a35e1572f366ffe34ba0fc47db1e7189759b9fb233c5b05ab388ea",
+		},
+	}
+
+	for _, v := range vectors {
+		z := messageHash(v.msg)
+		k := deterministicK(v.secret, z, nil)
+		if k.Cmp(hexBig(v.k)) != 0 {
+			t.Fatalf("nonce for %q: got %064x, want %s", v.msg, k, v.k)
+		}
+
+		privateKey := NewPrivateKey(v.secret)
+		der := privateKey.Sign(z).Der()
+		if hex.EncodeToString(der) != v.der {
+			t.Fatalf("signature for %q: got %x, want %s", v.msg, der, v.der)
+		}
+		//signing twice must give byte-identical result
+		if !bytes.Equal(der, privateKey.Sign(z).Der()) {
+			t.Fatalf("signature for %q is not deterministic", v.msg)
+		}
+
+		n := GetBitcoinValueN()
+		if !privateKey.GetPublicKey().Verify(NewFieldElement(n, z), privateKey.Sign(z)) {
+			t.Fatalf("signature for %q does not verify", v.msg)
+		}
+	}
+}
+
+func TestSignWithExtraEntropy(t *testing.T) {
+	privateKey := NewPrivateKey(big.NewInt(12345))
+	z := messageHash("extra entropy")
+	aux := bytes.Repeat([]byte{0x01}, 32)
+
+	plain := privateKey.Sign(z)
+	withAux := privateKey.SignWithExtraEntropy(z, aux)
+	if bytes.Equal(plain.Der(), withAux.Der()) {
+		t.Fatalf("extra entropy does not change the nonce")
+	}
+	if !bytes.Equal(withAux.Der(), privateKey.SignWithExtraEntropy(z, aux).Der()) {
+		t.Fatalf("signature with the same extra entropy is not deterministic")
+	}
+
+	n := GetBitcoinValueN()
+	if !privateKey.GetPublicKey().Verify(NewFieldElement(n, z), withAux) {
+		t.Fatalf("signature with extra entropy does not verify")
+	}
+}
+
+func TestScalarMulConstTime(t *testing.T) {
+	G := GetGenerator()
+	n := GetBitcoinValueN()
+	scalars := []*big.Int{
+		big.NewInt(1),
+		big.NewInt(2),
+		big.NewInt(15),
+		big.NewInt(16),
+		new(big.Int).Sub(n, big.NewInt(1)),
+		new(big.Int).Lsh(big.NewInt(1), 255),
+		hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181"),
+		hexBig("00000000000000000000000000000000ffffffffffffffffffffffffffffffff"),
+	}
+
+	P := G.scalarMulAffine(big.NewInt(31337))
+	for _, k := range scalars {
+		for _, base := range []*Point{G, P} {
+			want := base.scalarMulAffine(k)
+			got := base.ScalarMul(k)
+			if !got.Equal(want) {
+				t.Fatalf("scalar mul by %x: got %s, want %s", k, got, want)
+			}
+		}
+	}
+
+	//n * G and 0 * G are identity
+	for _, k := range []*big.Int{big.NewInt(0), n} {
+		if G.ScalarMul(k).x != nil {
+			t.Fatalf("%x * G should be identity", k)
+		}
+	}
+}
+
+func TestFieldValArithmetic(t *testing.T) {
+	p := S256Prime()
+	values := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(977),
+		new(big.Int).Sub(p, big.NewInt(1)),
+		hexBig("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		hexBig("ffffffffffffffffffffffffffffffffffffffffffffffffffffffff00000000"),
+	}
+
+	for _, a := range values {
+		for _, b := range values {
+			var fa, fb, r fieldVal
+			fa.setBig(a)
+			fb.setBig(b)
+
+			var op big.Int
+			if r.mul(&fa, &fb).toBig().Cmp(op.Mod(op.Mul(a, b), p)) != 0 {
+				t.Fatalf("%x * %x mod p is wrong", a, b)
+			}
+			if r.add(&fa, &fb).toBig().Cmp(op.Mod(op.Add(a, b), p)) != 0 {
+				t.Fatalf("%x + %x mod p is wrong", a, b)
+			}
+			if r.sub(&fa, &fb).toBig().Cmp(op.Mod(op.Sub(a, b), p)) != 0 {
+				t.Fatalf("%x - %x mod p is wrong", a, b)
+			}
+
+			var sa, sb, sr scalarVal
+			sa.setBig(a)
+			sb.setBig(b)
+			n := GetBitcoinValueN()
+			want := new(big.Int).Mul(new(big.Int).Mod(a, n), new(big.Int).Mod(b, n))
+			if sr.mul(&sa, &sb).toBig().Cmp(want.Mod(want, n)) != 0 {
+				t.Fatalf("%x * %x mod n is wrong", a, b)
+			}
+		}
+
+		if a.Sign() != 0 {
+			var fa, inv, one fieldVal
+			fa.setBig(a)
+			inv.inverse(&fa)
+			if one.mul(&fa, &inv).toBig().Cmp(big.NewInt(1)) != 0 {
+				t.Fatalf("inverse of %x mod p is wrong", a)
+			}
+
+			var square, root, back fieldVal
+			square.square(&fa)
+			root.sqrt(&square)
+			if back.square(&root).equal(&square) != 1 {
+				t.Fatalf("sqrt of %x^2 mod p is wrong", a)
+			}
+		}
+	}
+}
+
+// verifyAffine is the original Verify on affine coordinates, kept as reference for tests and benchmarks
+func verifyAffine(p *Point, z *FieldElement, sig *Signature) bool {
+	sInverse := sig.s.Inverse()
+	u := z.Mul(sInverse)
+	v := sig.r.Mul(sInverse)
+	G := GetGenerator()
+	total := (G.scalarMulAffine(u.num)).Add(p.scalarMulAffine(v.num))
+	return total.x.num.Cmp(sig.r.num) == 0
+}
+
+func TestScalarMulVartime(t *testing.T) {
+	G := GetGenerator()
+	P := G.scalarMulAffine(big.NewInt(987654321))
+	var pAffine affinePoint
+	pAffine.x.setBig(P.x.num)
+	pAffine.y.setBig(P.y.num)
+
+	n := GetBitcoinValueN()
+	scalars := []*big.Int{
+		big.NewInt(1),
+		big.NewInt(31),
+		new(big.Int).Sub(n, big.NewInt(1)),
+		new(big.Int).Sub(n, big.NewInt(16)),
+		hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181"),
+	}
+	for _, k := range scalars {
+		want := P.scalarMulAffine(k)
+		result := scalarMulVartime(&pAffine, k)
+		got, _ := result.toAffine()
+		if got.x.toBig().Cmp(want.x.num) != 0 || got.y.toBig().Cmp(want.y.num) != 0 {
+			t.Fatalf("wNAF scalar mul by %x is wrong", k)
+		}
+
+		wantG := G.scalarMulAffine(k)
+		resultG := scalarBaseMulVartime(k)
+		gotG, _ := resultG.toAffine()
+		if gotG.x.toBig().Cmp(wantG.x.num) != 0 || gotG.y.toBig().Cmp(wantG.y.num) != 0 {
+			t.Fatalf("comb scalar mul by %x is wrong", k)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	n := GetBitcoinValueN()
+	privateKey := NewPrivateKey(hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181"))
+	publicKey := privateKey.GetPublicKey()
+	z := messageHash("Jacobian")
+	sig := privateKey.Sign(z)
+
+	if !publicKey.Verify(NewFieldElement(n, z), sig) || !verifyAffine(publicKey, NewFieldElement(n, z), sig) {
+		t.Fatalf("valid signature rejected")
+	}
+
+	otherZ := messageHash("affine")
+	if publicKey.Verify(NewFieldElement(n, otherZ), sig) {
+		t.Fatalf("signature accepted for another message")
+	}
+	otherKey := NewPrivateKey(big.NewInt(7)).GetPublicKey()
+	if otherKey.Verify(NewFieldElement(n, z), sig) {
+		t.Fatalf("signature accepted for another public key")
+	}
+}
+
+// verifySumJacobian u * G + v * P without the endomorphism, reference for the benchmarks
+func verifySumJacobian(u *big.Int, v *big.Int, p *Point) *big.Int {
+	var pAffine affinePoint
+	pAffine.x.setBig(p.x.num)
+	pAffine.y.setBig(p.y.num)
+	uG := scalarBaseMulVartime(u)
+	vP := scalarMulVartime(&pAffine, v)
+	var total jacobianPoint
+	total.add(&uG, &vP)
+	result, _ := total.toAffine()
+	return result.x.toBig()
+}
+
+func TestSplitScalar(t *testing.T) {
+	n := GetBitcoinValueN()
+	limit := new(big.Int).Lsh(big.NewInt(1), 129)
+	scalars := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		new(big.Int).Sub(n, big.NewInt(1)),
+		endoLambda,
+		messageHash("split"),
+		messageHash("scalar"),
+	}
+	for _, k := range scalars {
+		k1, k2 := splitScalar(k)
+		sum := new(big.Int).Mul(k2, endoLambda)
+		sum.Add(sum, k1)
+		if sum.Mod(sum, n).Cmp(new(big.Int).Mod(k, n)) != 0 {
+			t.Fatalf("k1 + k2 * lambda != k for %x", k)
+		}
+		if new(big.Int).Abs(k1).Cmp(limit) >= 0 || new(big.Int).Abs(k2).Cmp(limit) >= 0 {
+			t.Fatalf("split of %x is too large: %x, %x", k, k1, k2)
+		}
+	}
+
+	//lambda * G = (beta * Gx, Gy)
+	g := generatorAffine()
+	lambdaG := GetGenerator().scalarMulAffine(endoLambda)
+	endo := g.endomorphism()
+	if endo.x.toBig().Cmp(lambdaG.x.num) != 0 || endo.y.toBig().Cmp(lambdaG.y.num) != 0 {
+		t.Fatalf("endomorphism does not match lambda * G")
+	}
+}
+
+func TestVerifySum(t *testing.T) {
+	P := GetGenerator().scalarMulAffine(big.NewInt(424242))
+	pairs := [][2]*big.Int{
+		{big.NewInt(1), big.NewInt(1)},
+		{messageHash("u"), messageHash("v")},
+		{new(big.Int).Sub(GetBitcoinValueN(), big.NewInt(1)), big.NewInt(3)},
+	}
+	for _, pair := range pairs {
+		want := verifySumJacobian(pair[0], pair[1], P)
+		sum := s256VerifySum(pair[0], pair[1], P)
+		got, ok := sum.toAffine()
+		if !ok || got.x.toBig().Cmp(want) != 0 {
+			t.Fatalf("u * G + v * P is wrong for u = %x, v = %x", pair[0], pair[1])
+		}
+	}
+}
+
+func benchmarkSetup() (*PrivateKey, *FieldElement, *Signature) {
+	privateKey := NewPrivateKey(hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181"))
+	z := messageHash("benchmark")
+	return privateKey, NewFieldElement(GetBitcoinValueN(), z), privateKey.Sign(z)
+}
+
+func BenchmarkScalarBaseMulAffine(b *testing.B) {
+	G := GetGenerator()
+	k := messageHash("scalar")
+	for i := 0; i < b.N; i++ {
+		G.scalarMulAffine(k)
+	}
+}
+
+func BenchmarkScalarBaseMul(b *testing.B) {
+	G := GetGenerator()
+	k := messageHash("scalar")
+	for i := 0; i < b.N; i++ {
+		G.ScalarMul(k)
+	}
+}
+
+func BenchmarkScalarMulAffine(b *testing.B) {
+	privateKey, _, _ := benchmarkSetup()
+	P := privateKey.GetPublicKey()
+	k := messageHash("scalar")
+	for i := 0; i < b.N; i++ {
+		P.scalarMulAffine(k)
+	}
+}
+
+func BenchmarkScalarMul(b *testing.B) {
+	privateKey, _, _ := benchmarkSetup()
+	P := privateKey.GetPublicKey()
+	k := messageHash("scalar")
+	for i := 0; i < b.N; i++ {
+		P.ScalarMul(k)
+	}
+}
+
+func BenchmarkVerifyAffine(b *testing.B) {
+	privateKey, z, sig := benchmarkSetup()
+	P := privateKey.GetPublicKey()
+	for i := 0; i < b.N; i++ {
+		verifyAffine(P, z, sig)
+	}
+}
+
+func BenchmarkVerifyNoEndomorphism(b *testing.B) {
+	privateKey, z, sig := benchmarkSetup()
+	P := privateKey.GetPublicKey()
+	sInverse := sig.s.Inverse()
+	u := z.Mul(sInverse)
+	v := sig.r.Mul(sInverse)
+	for i := 0; i < b.N; i++ {
+		verifySumJacobian(u.num, v.num, P)
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	privateKey, z, sig := benchmarkSetup()
+	P := privateKey.GetPublicKey()
+	for i := 0; i < b.N; i++ {
+		P.Verify(z, sig)
+	}
+}
+
+/*
+TestSchnorrVectors runs the official BIP 340 test vectors, testdata/bip340-vectors.csv
+is test-vectors.csv from the bips repository. Rows with a secret key are signed
+and must give exactly the listed signature, every row is verified and must give
+the listed result.
+*/
+func TestSchnorrVectors(t *testing.T) {
+	file, err := os.Open("testdata/bip340-vectors.csv")
+	if err != nil {
+		t.Fatalf("open vectors: %v", err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("read vectors: %v", err)
+	}
+
+	for _, row := range rows[1:] {
+		index, secret, pubKeyHex, auxHex, msgHex, sigHex := row[0], row[1], row[2], row[3], row[4], row[5]
+		expected := row[6] == "TRUE"
+		msg, _ := hex.DecodeString(msgHex)
+		sigBin, _ := hex.DecodeString(sigHex)
+		pubKeyBin, _ := hex.DecodeString(pubKeyHex)
+
+		if secret != "" {
+			privateKey := NewPrivateKey(hexBig(secret))
+			if !bytes.Equal(privateKey.GetPublicKey().XOnly(), pubKeyBin) {
+				t.Fatalf("vector %s: wrong x-only public key %x", index, privateKey.GetPublicKey().XOnly())
+			}
+			aux, _ := hex.DecodeString(auxHex)
+			sig := privateKey.SignSchnorr(msg, aux)
+			if !bytes.Equal(sig.Serialize(), sigBin) {
+				t.Fatalf("vector %s: wrong signature %x", index, sig.Serialize())
+			}
+		}
+
+		result := false
+		pubKey, err := ParseXOnly(pubKeyBin)
+		if err == nil {
+			sig, err := ParseSchnorrSignature(sigBin)
+			result = err == nil && pubKey.VerifySchnorr(msg, sig)
+		}
+		if result != expected {
+			t.Fatalf("vector %s (%s): verify gives %v, want %v", index, row[7], result, expected)
+		}
+	}
+}
+
+func TestSchnorrSignVerify(t *testing.T) {
+	aux := make([]byte, 32)
+	msg := []byte("schnorr")
+	//6 * G has odd y, the secret is negated when signing
+	for _, secret := range []*big.Int{big.NewInt(3), big.NewInt(6), hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181")} {
+		privateKey := NewPrivateKey(secret)
+		pubKey, err := ParseXOnly(privateKey.GetPublicKey().XOnly())
+		if err != nil {
+			t.Fatalf("parse x-only key: %v", err)
+		}
+		sig := privateKey.SignSchnorr(msg, aux)
+		if !pubKey.VerifySchnorr(msg, sig) {
+			t.Fatalf("valid schnorr signature rejected for secret %x", secret)
+		}
+		if pubKey.VerifySchnorr([]byte("another message"), sig) {
+			t.Fatalf("schnorr signature accepted for another message")
+		}
+	}
+
+	if !bytes.Equal(TaggedHash("BIP0340/challenge"), TaggedHash("BIP0340/challenge", nil)) {
+		t.Fatalf("tagged hash of empty message differs")
+	}
+	if _, err := ParseSchnorrSignature(make([]byte, 63)); err != ErrSchnorrSigLength {
+		t.Fatalf("short schnorr signature gives err %v", err)
+	}
+}
+
+func BenchmarkVerifySchnorr(b *testing.B) {
+	privateKey := NewPrivateKey(hexBig("f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181"))
+	msg := []byte("benchmark")
+	sig := privateKey.SignSchnorr(msg, make([]byte, 32))
+	pubKey, _ := ParseXOnly(privateKey.GetPublicKey().XOnly())
+	for i := 0; i < b.N; i++ {
+		pubKey.VerifySchnorr(msg, sig)
+	}
+}
+
+func schnorrBatch(count int) (*SchnorrBatchVerifier, []*Point, [][]byte, []*SchnorrSignature) {
+	verifier := NewSchnorrBatchVerifier()
+	var pubKeys []*Point
+	var msgs [][]byte
+	var sigs []*SchnorrSignature
+	for i := 0; i < count; i++ {
+		privateKey := NewPrivateKey(big.NewInt(int64(1000 + i)))
+		pubKey, _ := ParseXOnly(privateKey.GetPublicKey().XOnly())
+		msg := []byte{byte(i), 'b', 'a', 't', 'c', 'h'}
+		sig := privateKey.SignSchnorr(msg, make([]byte, 32))
+		verifier.Add(pubKey, msg, sig)
+		pubKeys = append(pubKeys, pubKey)
+		msgs = append(msgs, msg)
+		sigs = append(sigs, sig)
+	}
+	return verifier, pubKeys, msgs, sigs
+}
+
+func TestSchnorrBatchVerify(t *testing.T) {
+	verifier, pubKeys, msgs, sigs := schnorrBatch(16)
+	if !verifier.verifyBatch() {
+		t.Fatalf("batch equation rejects valid signatures")
+	}
+	if ok, idx := verifier.Verify(); !ok || idx != -1 {
+		t.Fatalf("valid batch rejected at %d", idx)
+	}
+
+	//the signature of item 3 for the message of item 4
+	bad := NewSchnorrBatchVerifier()
+	for i := range sigs {
+		msg := msgs[i]
+		if i == 3 {
+			msg = msgs[4]
+		}
+		bad.Add(pubKeys[i], msg, sigs[i])
+	}
+	if bad.verifyBatch() {
+		t.Fatalf("batch equation accepts an invalid signature")
+	}
+	if ok, idx := bad.Verify(); ok || idx != 3 {
+		t.Fatalf("invalid batch gives %v at %d, want false at 3", ok, idx)
+	}
+
+	if ok, _ := NewSchnorrBatchVerifier().Verify(); !ok {
+		t.Fatalf("empty batch rejected")
+	}
+}
+
+func TestECDSABatchVerify(t *testing.T) {
+	n := GetBitcoinValueN()
+	verifier := NewECDSABatchVerifier(4)
+	bad := NewECDSABatchVerifier(0)
+	for i := 0; i < 10; i++ {
+		privateKey := NewPrivateKey(big.NewInt(int64(2000 + i)))
+		z := NewFieldElement(n, messageHash(string(rune('a'+i))))
+		sig := privateKey.Sign(z.num)
+		verifier.Add(privateKey.GetPublicKey(), z, sig)
+		if i == 7 {
+			z = NewFieldElement(n, messageHash("tampered"))
+		}
+		bad.Add(privateKey.GetPublicKey(), z, sig)
+	}
+
+	if ok, idx := verifier.Verify(); !ok || idx != -1 {
+		t.Fatalf("valid batch rejected at %d", idx)
+	}
+	if ok, idx := bad.Verify(); ok || idx != 7 {
+		t.Fatalf("invalid batch gives %v at %d, want false at 7", ok, idx)
+	}
+}
+
+func BenchmarkVerifySchnorrBatch64(b *testing.B) {
+	verifier, _, _, _ := schnorrBatch(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		verifier.Verify()
+	}
+}
+
+func BenchmarkVerifySchnorrEach64(b *testing.B) {
+	verifier, _, _, _ := schnorrBatch(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		verifier.verifyEach()
+	}
+}
+
+func TestRecoverPublicKey(t *testing.T) {
+	for i := int64(1); i <= 8; i++ {
+		privateKey := NewPrivateKey(new(big.Int).Mul(big.NewInt(i), hexBig("f8b8af8ce3c7cca5e300d33939540c10")))
+		z := messageHash(string(rune('0' + i)))
+		compact := privateKey.SignCompact(z, i%2 == 0)
+
+		parsed, err := ParseCompactSignature(compact.Serialize())
+		if err != nil {
+			t.Fatalf("parse compact signature: %v", err)
+		}
+		if parsed.RecoveryID() != compact.RecoveryID() || parsed.Compressed() != (i%2 == 0) {
+			t.Fatalf("compact signature header does not round trip")
+		}
+		if !bytes.Equal(parsed.Signature().Der(), privateKey.Sign(z).Der()) {
+			t.Fatalf("compact signature differs from Sign")
+		}
+
+		recovered, err := RecoverPublicKey(z, parsed)
+		if err != nil {
+			t.Fatalf("recover public key: %v", err)
+		}
+		if !recovered.Equal(privateKey.GetPublicKey()) {
+			t.Fatalf("recovered %s, want %s", recovered, privateKey.GetPublicKey())
+		}
+
+		//the other parity of R gives another key
+		other := NewCompactSignature(parsed.Signature(), parsed.RecoveryID()^1, false)
+		if key, err := RecoverPublicKey(z, other); err == nil && key.Equal(privateKey.GetPublicKey()) {
+			t.Fatalf("wrong recovery id gives the same key")
+		}
+	}
+
+	if _, err := ParseCompactSignature(make([]byte, 65)); err != ErrCompactSigHeader {
+		t.Fatalf("header 0 gives err %v", err)
+	}
+
+	//r or s out of [1, n - 1]
+	nBytes := intTo32Bytes(GetBitcoinValueN())
+	one := intTo32Bytes(big.NewInt(1))
+	for _, rs := range [][2][]byte{
+		{nBytes, one},
+		{one, nBytes},
+		{bytes.Repeat([]byte{0xff}, 32), one},
+		{one, bytes.Repeat([]byte{0xff}, 32)},
+		{make([]byte, 32), one},
+	} {
+		sigBin := append([]byte{31}, rs[0]...)
+		sigBin = append(sigBin, rs[1]...)
+		if _, err := ParseCompactSignature(sigBin); err != ErrCompactSigRange {
+			t.Fatalf("r %x s %x gives err %v", rs[0], rs[1], err)
+		}
+	}
+}
+
+func TestSignedMessage(t *testing.T) {
+	//from rpc_signmessage.py of Bitcoin Core
+	privateKey, _, _, err := ParseWif("cUeKHd5orzT3mz8P9pxyREHfsWtVfgsfDjiZZBcjUBAaGk1BTj7N")
+	if err != nil {
+		t.Fatalf("parse wif: %v", err)
+	}
+	address := "mpLQjfK79b7CCV4VMJWEWAj5Mpx8Up5zxB"
+	message := "This is just a test message"
+	expected := "INbVnW4e6PeRmsv2Qgu8NuopvrVjkcxob+sX8OcZG0SALhWybUjzMLPdAsXI46YZGb0KQTRii+wWIQzRpG/U+S0="
+
+	if privateKey.GetPublicKey().Address(true, TestNet3Params) != address {
+		t.Fatalf("wrong address %s", privateKey.GetPublicKey().Address(true, TestNet3Params))
+	}
+	signature := privateKey.SignMessage(message, MessageP2PKH)
+	if signature != expected {
+		t.Fatalf("signature %s, want %s", signature, expected)
+	}
+	if ok, err := VerifyMessage(address, signature, message); !ok || err != nil {
+		t.Fatalf("valid signed message rejected: %v", err)
+	}
+	if ok, _ := VerifyMessage(address, signature, message+"!"); ok {
+		t.Fatalf("signed message accepted for another message")
+	}
+
+	pubKey := privateKey.GetPublicKey()
+	for _, test := range []struct {
+		addressType MessageAddressType
+		address     string
+	}{
+		{MessageP2PKHUncompressed, pubKey.Address(false, MainNetParams)},
+		{MessageP2SHP2WPKH, pubKey.P2shP2wpkhAddress(MainNetParams)},
+		{MessageP2WPKH, pubKey.P2wpkhAddress(TestNet3Params)},
+		{MessageP2WPKH, strings.ToUpper(pubKey.P2wpkhAddress(MainNetParams))},
+		//compressed P2PKH header signs for segwit addresses too
+		{MessageP2PKH, pubKey.P2wpkhAddress(MainNetParams)},
+	} {
+		signature := privateKey.SignMessage(message, test.addressType)
+		if ok, err := VerifyMessage(test.address, signature, message); !ok || err != nil {
+			t.Fatalf("signed message for %s rejected: %v", test.address, err)
+		}
+	}
+
+	//P2WPKH header must not verify against the P2PKH address
+	signature = privateKey.SignMessage(message, MessageP2WPKH)
+	if ok, _ := VerifyMessage(address, signature, message); ok {
+		t.Fatalf("P2WPKH signature accepted for P2PKH address")
+	}
+	if _, err := VerifyMessage(address, "not base64!", message); err != ErrMessageSigEncoding {
+		t.Fatalf("bad base64 gives err %v", err)
+	}
+
+	//r and s above n used to panic
+	for _, header := range []byte{31, 39} {
+		sigBin := append([]byte{header}, bytes.Repeat([]byte{0xff}, 64)...)
+		signature := base64.StdEncoding.EncodeToString(sigBin)
+		if ok, err := VerifyMessage(address, signature, message); ok || err != ErrCompactSigRange {
+			t.Fatalf("header %d with r, s above n gives %v, %v", header, ok, err)
+		}
+	}
+}
+
+func TestSegwitAddress(t *testing.T) {
+	//BIP 173 example, compressed public key of secret 1
+	pubKey := NewPrivateKey(big.NewInt(1)).GetPublicKey()
+	if address := pubKey.P2wpkhAddress(MainNetParams); address != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Fatalf("wrong p2wpkh address %s", address)
+	}
+	if address := pubKey.P2wpkhAddress(TestNet3Params); address != "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx" {
+		t.Fatalf("wrong testnet p2wpkh address %s", address)
+	}
+}
+
+func TestBech32(t *testing.T) {
+	//BIP 173 and BIP 350 valid strings
+	tests := []struct {
+		s       string
+		variant Bech32Variant
+	}{
+		{"A12UEL5L", Bech32},
+		{"a12uel5l", Bech32},
+		{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", Bech32},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", Bech32},
+		{"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j", Bech32},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", Bech32},
+		{"?1ezyfcl", Bech32},
+		{"A1LQFN3A", Bech32m},
+		{"a1lqfn3a", Bech32m},
+		{"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6", Bech32m},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", Bech32m},
+		{"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8", Bech32m},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", Bech32m},
+		{"?1v759aa", Bech32m},
+	}
+	for _, test := range tests {
+		hrp, data, variant, err := Bech32Decode(test.s)
+		if err != nil || variant != test.variant {
+			t.Fatalf("decode %s gives variant %d, err %v", test.s, variant, err)
+		}
+		encoded, err := Bech32Encode(hrp, data, variant)
+		if err != nil || encoded != strings.ToLower(test.s) {
+			t.Fatalf("encode %s gives %s, err %v", test.s, encoded, err)
+		}
+	}
+
+	invalid := []struct {
+		s   string
+		err error
+	}{
+		{" 1nwldj5", ErrBech32Char},
+		{"\x7f1axkwrx", ErrBech32Char},
+		{"\x801eym55h", ErrBech32Char},
+		{"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", ErrBech32Length},
+		{"pzry9x0s0muk", ErrBech32Separator},
+		{"1pzry9x0s0muk", ErrBech32Separator},
+		{"x1b4n0q5v", ErrBech32Char},
+		{"li1dgmt3", ErrBech32Separator},
+		{"de1lg7wt\xff", ErrBech32Char},
+		{"A1G7SGD8", ErrBech32Checksum},
+		{"10a06t8", ErrBech32Separator},
+		{"1qzzfhee", ErrBech32Separator},
+		{"M1VUXWEZ", ErrBech32Checksum},
+		{"a12UEL5L", ErrBech32MixedCase},
+	}
+	for _, test := range invalid {
+		if _, _, _, err := Bech32Decode(test.s); !errors.Is(err, test.err) {
+			t.Fatalf("decode %q gives err %v, want %v", test.s, err, test.err)
+		}
+	}
+}
+
+func TestBech32ErrorPositions(t *testing.T) {
+	valid := "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"
+	change := func(s string, positions ...int) string {
+		b := []byte(s)
+		for _, pos := range positions {
+			//the next char in the charset is always a different value
+			b[pos] = bech32Charset[(strings.IndexByte(bech32Charset, b[pos])+1)%32]
+		}
+		return string(b)
+	}
+	for _, positions := range [][]int{{10}, {4}, {61}, {5, 40}, {20, 21}, {4, 61}} {
+		_, _, _, err := Bech32Decode(change(valid, positions...))
+		var bech32Err *Bech32Error
+		if !errors.As(err, &bech32Err) || bech32Err.Err != ErrBech32Checksum {
+			t.Fatalf("changes at %v give err %v", positions, err)
+		}
+		if fmt.Sprint(bech32Err.Positions) != fmt.Sprint(positions) {
+			t.Fatalf("changes at %v located at %v", positions, bech32Err.Positions)
+		}
+	}
+
+	//invalid chars are reported at their index
+	_, _, _, err := Bech32Decode("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jjo")
+	var bech32Err *Bech32Error
+	if !errors.As(err, &bech32Err) || bech32Err.Err != ErrBech32Char || fmt.Sprint(bech32Err.Positions) != "[61]" {
+		t.Fatalf("invalid char gives err %v", err)
+	}
+}
+
+func TestSegwitAddressVectors(t *testing.T) {
+	//BIP 350 valid addresses with their scriptPubKey
+	tests := []struct {
+		address      string
+		scriptPubKey string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "6002751e"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+		{"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, test := range tests {
+		hrp, version, program, err := DecodeSegwitAddress(test.address)
+		if err != nil {
+			t.Fatalf("decode %s: %v", test.address, err)
+		}
+		//OP_0 or OP_1 to OP_16, then the push of the program
+		opVersion := version
+		if version > 0 {
+			opVersion += 0x50
+		}
+		script := append([]byte{opVersion, byte(len(program))}, program...)
+		if hex.EncodeToString(script) != test.scriptPubKey {
+			t.Fatalf("%s gives script %x, want %s", test.address, script, test.scriptPubKey)
+		}
+		encoded, err := EncodeSegwitAddress(hrp, version, program)
+		if err != nil || encoded != strings.ToLower(test.address) {
+			t.Fatalf("encode %s gives %s, err %v", test.address, encoded, err)
+		}
+	}
+
+	//BIP 173 and BIP 350 invalid addresses, the first two have unknown human readable parts
+	for _, address := range []string{
+		"tc1qw508d6qejxtdg4y5r3zarvary0c5xw7kg3g4ty",
+		"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+		"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf",
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",
+		"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47",
+		"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4",
+		"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R",
+		"bc1pw5dgrnzv",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav",
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf",
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j",
+		"bc1gmk9yu",
+		"BC1SW50QA3JX3S",
+		"bc1zw508d6qejxtdg4y5r3zarvaryvg6kdaj",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+		"BC13W508D6QEJXTDG4Y5R3ZARVARY0C5XW7KN40WF2",
+		"bc1rw5uspcuh",
+		"bc10w508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kw5rljs90",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7",
+		"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3pjxtptv",
+	} {
+		hrp, _, _, err := DecodeSegwitAddress(address)
+		if err == nil && (hrp == MainnetHrp || hrp == TestnetHrp) {
+			t.Fatalf("invalid address %s accepted", address)
+		}
+	}
+
+	//BIP 86 first receiving address of the abandon ... about mnemonic
+	internalKey, _ := hex.DecodeString("cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115")
+	pubKey, err := ParseXOnly(internalKey)
+	if err != nil {
+		t.Fatalf("parse internal key: %v", err)
+	}
+	if address := pubKey.P2trAddress(MainNetParams); address != "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr" {
+		t.Fatalf("wrong p2tr address %s", address)
+	}
+}
+
+func TestParseWif(t *testing.T) {
+	tests := []struct {
+		wif        string
+		compressed bool
+		params     *ChainParams
+	}{
+		{"5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf", false, MainNetParams},
+		{"KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn", true, MainNetParams},
+		{"cMahea7zqjxrtgAbB7LSGbcQUr1uX1ojuat9jZodMN87JcbXMTcA", true, TestNet3Params},
+	}
+	for _, test := range tests {
+		privateKey, compressed, params, err := ParseWif(test.wif)
+		if err != nil {
+			t.Fatalf("parse %s: %v", test.wif, err)
+		}
+		if privateKey.secret.Cmp(big.NewInt(1)) != 0 || compressed != test.compressed || params != test.params {
+			t.Fatalf("%s gives %s compressed %v network %s", test.wif, privateKey, compressed, params)
+		}
+		if wif := privateKey.Wif(compressed, params); wif != test.wif {
+			t.Fatalf("wif of %s is %s", test.wif, wif)
+		}
+	}
+
+	secret := func(v *big.Int) []byte {
+		return intTo32Bytes(v)
+	}
+	one := secret(big.NewInt(1))
+	invalid := []struct {
+		wif string
+		err error
+	}{
+		{"", ErrBase58Checksum},
+		{"KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWo", ErrBase58Checksum},
+		{"KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHno0n", ErrBase58Char},
+		{Base58Checksum(append([]byte{0x80}, one[1:]...)), ErrWifLength},
+		{Base58Checksum(append([]byte{0x81}, one...)), ErrWifVersion},
+		{Base58Checksum(append(append([]byte{0x80}, one...), 0x02)), ErrWifCompressFlag},
+		{Base58Checksum(append([]byte{0x80}, make([]byte, 32)...)), ErrPrivateKeyRange},
+		{Base58Checksum(append([]byte{0x80}, secret(GetBitcoinValueN())...)), ErrPrivateKeyRange},
+	}
+	for _, test := range invalid {
+		if _, _, _, err := ParseWif(test.wif); err != test.err {
+			t.Fatalf("parse %q gives err %v, want %v", test.wif, err, test.err)
+		}
+	}
+
+	if _, err := DecodeBase58("0OIl"); err != ErrBase58Char {
+		t.Fatalf("decode invalid base58 gives err %v", err)
+	}
+}
+
+func TestBIP38(t *testing.T) {
+	//BIP 38 test vectors
+	tests := []struct {
+		passphrase string
+		encrypted  string
+		wif        string
+		compressed bool
+		ecMultiply bool
+	}{
+		{"TestingOneTwoThree", "6PRVWUbkzzsbcVac2qwfssoUJAN1Xhrg6bNk8J7Nzm5H7kxEbn2Nh2ZoGg", "5KN7MzqK5wt2TP1fQCYyHBtDrXdJuXbUzm4A9rKAteGu3Qi5CVR", false, false},
+		{"Satoshi", "6PRNFFkZc2NZ6dJqFfhRoFNMR9Lnyj7dYGrzdgXXVMXcxoKTePPX1dWByq", "5HtasZ6ofTHP6HCwTqTkLDuLQisYPah7aUnSKfC7h4hMUVw2gi5", false, false},
+		//the passphrase is normalized to NFC before scrypt
+		{"\u03D2\u0301\u0000\U00010400\U0001F4A9", "6PRW5o9FLp4gJDDVqJQKJFTpMvdsSGJxMYHtHaQBF3ooa8mwD69bapcDQn", "5Jajm8eQ22H3pGWLEVCXyvND8dQZhiQhoLJNKjYXk9roUFTMSZ4", false, false},
+		{"TestingOneTwoThree", "6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo", "L44B5gGEpqEDRS9vVPz7QT35jcBG2r3CZwSwQ4fCewXAhAhqGVpP", true, false},
+		{"Satoshi", "6PYLtMnXvfG3oJde97zRyLYFZCYizPU5T3LwgdYJz1fRhh16bU7u6PPmY7", "KwYgW8gcxj1JWJXhPSu4Fqwzfhp5Yfi42mdYmMa4XqK7NJxXUSK7", true, false},
+		{"TestingOneTwoThree", "6PfQu77ygVyJLZjfvMLyhLMQbYnu5uguoJJ4kMCLqWwPEdfpwANVS76gTX", "5K4caxezwjGCGfnoPTZ8tMcJBLB7Jvyjv4xxeacadhq8nLisLR2", false, true},
+		{"Satoshi", "6PfLGnQs6VZnrNpmVKfjotbnQuaJK4KZoPFrAjx1JMJUa1Ft8gnf5WxfKd", "5KJ51SgxWaAYR13zd9ReMhJpwrcX47xTJh2D3fGPG9CM8vkv5sH", false, true},
+		{"MOLON LABE", "6PgNBNNzDkKdhkT6uJntUXwwzQV8Rr2tZcbkDcuC9DZRsS6AtHts4Ypo1j", "5JLdxTtcTHcfYcmJsNVy1v2PMDx432JPoYcBTVVRHpPaxUrdtf8", false, true},
+	}
+	for _, test := range tests {
+		privateKey, compressed, err := DecryptBIP38(test.encrypted, test.passphrase, MainNetParams)
+		if err != nil {
+			t.Fatalf("decrypt %s: %v", test.encrypted, err)
+		}
+		if compressed != test.compressed || privateKey.Wif(compressed, MainNetParams) != test.wif {
+			t.Fatalf("%s decrypts to %s", test.encrypted, privateKey.Wif(compressed, MainNetParams))
+		}
+		if !test.ecMultiply {
+			if encrypted := privateKey.EncryptBIP38(test.passphrase, compressed, MainNetParams); encrypted != test.encrypted {
+				t.Fatalf("%s encrypts to %s, want %s", test.wif, encrypted, test.encrypted)
+			}
+		}
+	}
+
+	if _, _, err := DecryptBIP38(tests[0].encrypted, "Satoshi", MainNetParams); err != ErrBIP38Passphrase {
+		t.Fatalf("wrong passphrase gives err %v", err)
+	}
+	if _, _, err := DecryptBIP38(tests[5].encrypted, "Satoshi", MainNetParams); err != ErrBIP38Passphrase {
+		t.Fatalf("wrong passphrase for ec multiplied key gives err %v", err)
+	}
+	if _, _, err := DecryptBIP38(tests[0].wif, "Satoshi", MainNetParams); err != ErrBIP38Length {
+		t.Fatalf("wif gives err %v", err)
+	}
+}
+
+func TestBIP38Intermediate(t *testing.T) {
+	for _, withLot := range []bool{false, true} {
+		intermediate, err := NewBIP38Intermediate("TestingOneTwoThree", withLot, 263183, 1)
+		if err != nil {
+			t.Fatalf("new intermediate: %v", err)
+		}
+		if !strings.HasPrefix(intermediate, "passphrase") {
+			t.Fatalf("intermediate code %s", intermediate)
+		}
+		encrypted, address, err := EncryptBIP38Intermediate(intermediate, withLot, MainNetParams)
+		if err != nil {
+			t.Fatalf("encrypt from intermediate: %v", err)
+		}
+		privateKey, compressed, err := DecryptBIP38(encrypted, "TestingOneTwoThree", MainNetParams)
+		if err != nil {
+			t.Fatalf("decrypt %s: %v", encrypted, err)
+		}
+		if compressed != withLot || privateKey.GetPublicKey().Address(compressed, MainNetParams) != address {
+			t.Fatalf("%s decrypts to a key of another address", encrypted)
+		}
+	}
+	if _, err := NewBIP38Intermediate("TestingOneTwoThree", true, bip38MaxLot+1, 0); err != ErrBIP38LotSequence {
+		t.Fatalf("lot out of range gives err %v", err)
+	}
+}
+
+func TestParseDERSignature(t *testing.T) {
+	privateKey := NewPrivateKey(big.NewInt(12345))
+	z := messageHash("strict der")
+	sig := privateKey.Sign(z)
+	der := sig.Der()
+	parsed, err := ParseDERSignature(der)
+	if err != nil || !bytes.Equal(parsed.Der(), der) {
+		t.Fatalf("parse own signature: %v", err)
+	}
+	if !sig.IsLowS() {
+		t.Fatalf("Sign returned a high s")
+	}
+
+	rLen := int(der[3])
+	rBin, sBin := der[4:4+rLen], der[6+rLen:]
+	build := func(r []byte, s []byte) []byte {
+		body := append([]byte{0x02, byte(len(r))}, r...)
+		body = append(body, 0x02, byte(len(s)))
+		body = append(body, s...)
+		return append([]byte{0x30, byte(len(body))}, body...)
+	}
+	longForm := append([]byte{0x30, 0x81, der[1]}, der[2:]...)
+	tests := []struct {
+		der    []byte
+		strict error
+		lax    error
+		//the lax parse has the values of der
+		same bool
+	}{
+		{der[:7], ErrDERLength, ErrDERMalformed, false},
+		{append(append([]byte{}, der...), 0x01), ErrDERSize, nil, true},
+		{append([]byte{0x31}, der[1:]...), ErrDERMarker, ErrDERMalformed, false},
+		{build([]byte{0x00, 0x01}, sBin), ErrDERPadding, nil, false},
+		{build([]byte{0x80}, sBin), ErrDERNegative, nil, false},
+		{build(rBin, []byte{}), ErrDERNegative, nil, false},
+		{longForm, ErrDERMarker, nil, true},
+		{build(rBin, append([]byte{0x00}, GetBitcoinValueN().Bytes()...)), ErrSigRange, ErrSigRange, false},
+	}
+	for i, test := range tests {
+		if _, err := ParseDERSignature(test.der); err != test.strict {
+			t.Fatalf("test %d: strict parse gives err %v, want %v", i, err, test.strict)
+		}
+		lax, err := ParseDERSignatureLax(test.der)
+		if err != test.lax {
+			t.Fatalf("test %d: lax parse gives err %v, want %v", i, err, test.lax)
+		}
+		if test.same && !bytes.Equal(lax.Der(), der) {
+			t.Fatalf("test %d: lax parse gives %s", i, lax)
+		}
+	}
+
+	n := GetBitcoinValueN()
+	highS := NewSignature(sig.r, NewFieldElement(n, new(big.Int).Sub(n, sig.s.num)))
+	if highS.IsLowS() {
+		t.Fatalf("n - s is reported as low s")
+	}
+	if !privateKey.GetPublicKey().Verify(NewFieldElement(n, z), highS) {
+		t.Fatalf("high s signature does not verify")
+	}
+}
+
+func TestParseSEC(t *testing.T) {
+	point := NewPrivateKey(big.NewInt(5001)).GetPublicKey()
+	for _, compressed := range []bool{true, false} {
+		_, sec := point.Sec(compressed)
+		parsed, err := ParseSEC(sec)
+		if err != nil {
+			t.Fatalf("parse sec %x: %v", sec, err)
+		}
+		if _, again := parsed.Sec(compressed); !bytes.Equal(again, sec) {
+			t.Fatalf("sec %x parses to %x", sec, again)
+		}
+	}
+
+	_, uncompressed := point.Sec(false)
+	offCurve := append([]byte{}, uncompressed...)
+	offCurve[64] ^= 0x01
+	//x = 5 has no point on secp256k1
+	noPoint := append([]byte{0x02}, make([]byte, 31)...)
+	noPoint = append(noPoint, 0x05)
+	tests := []struct {
+		sec []byte
+		err error
+	}{
+		{nil, ErrSECFormat},
+		{uncompressed[:33], ErrSECFormat},
+		{append([]byte{0x05}, uncompressed[1:33]...), ErrSECFormat},
+		{offCurve, ErrSECNotOnCurve},
+		{noPoint, ErrSECNotOnCurve},
+	}
+	for i, test := range tests {
+		if _, err := ParseSEC(test.sec); err != test.err {
+			t.Fatalf("test %d: err %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func FuzzParseSEC(f *testing.F) {
+	point := NewPrivateKey(big.NewInt(5001)).GetPublicKey()
+	_, compressed := point.Sec(true)
+	_, uncompressed := point.Sec(false)
+	f.Add(compressed)
+	f.Add(uncompressed)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parsed, err := ParseSEC(data)
+		if err != nil {
+			return
+		}
+		_, sec := parsed.Sec(data[0] != 0x04)
+		if !bytes.Equal(sec, data) {
+			t.Fatalf("sec %x parses to %x", data, sec)
+		}
+	})
+}
+
+func FuzzParseDERSignature(f *testing.F) {
+	privateKey := NewPrivateKey(big.NewInt(12345))
+	f.Add(privateKey.Sign(big.NewInt(1)).Der())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		strict, err := ParseDERSignature(data)
+		if err == nil && !bytes.Equal(strict.Der(), data) {
+			t.Fatalf("strict DER %x serializes to %x", data, strict.Der())
+		}
+		//whatever the strict parser accepts, the lax one accepts too
+		if _, laxErr := ParseDERSignatureLax(data); err == nil && laxErr != nil {
+			t.Fatalf("lax parse of %x: %v", data, laxErr)
+		}
+	})
+}
+
+func TestChainParams(t *testing.T) {
+	genesisHashes := map[*ChainParams]string{
+		MainNetParams:  "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		TestNet3Params: "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+		TestNet4Params: "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+		SigNetParams:   "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+		RegTestParams:  "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	}
+	for _, params := range AllChainParams() {
+		if hash := hex.EncodeToString(params.GenesisHash()); hash != genesisHashes[params] {
+			t.Fatalf("%s genesis hash %s", params, hash)
+		}
+		if ChainParamsByName(params.Name()) != params {
+			t.Fatalf("%s is not found by name", params)
+		}
+		//the genesis block meets the pow limit of its network
+		hash := new(big.Int).SetBytes(params.GenesisHash())
+		if hash.Cmp(params.PowLimit()) > 0 {
+			t.Fatalf("%s genesis hash above pow limit", params)
+		}
+	}
+	if ChainParamsByName("testnet") != nil {
+		t.Fatalf("unknown network name is found")
+	}
+
+	var nilParams *ChainParams
+	if nilParams.Name() != "mainnet" || nilParams.IsTestnet() || !TestNet4Params.IsTestnet() {
+		t.Fatalf("nil params should be main-net")
+	}
+
+	pubKey := NewPrivateKey(big.NewInt(1)).GetPublicKey()
+	addresses := []struct {
+		address string
+		want    string
+	}{
+		{pubKey.Address(true, nilParams), "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"},
+		{pubKey.Address(true, SigNetParams), "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r"},
+		{pubKey.P2wpkhAddress(RegTestParams), "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"},
+		{pubKey.P2wpkhAddress(TestNet4Params), "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+	}
+	for _, test := range addresses {
+		if test.address != test.want {
+			t.Fatalf("address %s, want %s", test.address, test.want)
+		}
+	}
+}
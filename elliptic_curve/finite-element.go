@@ -16,15 +16,7 @@ type FieldElement struct {
 
 func S256Field(num *big.Int) *FieldElement {
 	//2^256 - 2^32 - 977
-	var op big.Int
-	twoExp256 := op.Exp(big.NewInt(int64(2)), big.NewInt(int64(256)), nil)
-	var op1 big.Int
-	twoExp32 := op1.Exp(big.NewInt(int64(2)), big.NewInt(int64(32)), nil)
-	var op2 big.Int
-	p := op2.Sub(twoExp256, twoExp32)
-	var op3 big.Int
-	pp := op3.Sub(p, big.NewInt(int64(977)))
-	return NewFieldElement(pp, num)
+	return NewFieldElement(S256Prime(), num)
 }
 
 func NewFieldElement(order *big.Int, num *big.Int) *FieldElement {
@@ -95,13 +95,19 @@ func (p *Point) Verify(z *FieldElement, sig *Signature) bool {
 		        needs to do based on module of n, and remember the operator
 				"/" is not the normal arithmetic divide, it is the inverse of multiplication.
 	*/
-	sInverse := sig.s.Inverse()
+	if p.x == nil || sig.r.num.Sign() == 0 || sig.s.num.Sign() == 0 {
+		return false
+	}
+	//s is public, the fast variable time inverse of big.Int is fine here
+	n := GetBitcoinValueN()
+	sInverse := NewFieldElement(n, new(big.Int).ModInverse(sig.s.num, n))
 	u := z.Mul(sInverse)
 	v := sig.r.Mul(sInverse)
-	G := GetGenerator()
-	total := (G.ScalarMul(u.num)).Add(p.ScalarMul(v.num))
-
-	return total.x.num.Cmp(sig.r.num) == 0
+	/*
+		u, v and P are all public, u * G + v * P is computed in Jacobian coordinates
+		with the GLV endomorphism and Strauss-Shamir trick, see s256-glv.go
+	*/
+	return s256VerifyR(u.num, v.num, sig.r.num, p)
 }
 
 func NewEllipticPoint(x *FieldElement, y *FieldElement, a *FieldElement, b *FieldElement) *Point {
@@ -137,6 +143,30 @@ func (p *Point) ScalarMul(scalar *big.Int) *Point {
 	if scalar == nil {
 		panic("scalar mul error ofr nil scalar")
 	}
+
+	if p.isS256() {
+		/*
+			points on secp256k1 go to the constant time backend, the scalar may be
+			a private key or a nonce, see s256-point.go
+		*/
+		if p.x == nil {
+			return S256Point(nil, nil)
+		}
+		if p.isGenerator() {
+			return s256ScalarBaseMul(scalar)
+		}
+		return s256ScalarMul(p, scalar)
+	}
+
+	return p.scalarMulAffine(scalar)
+}
+
+/*
+scalarMulAffine
+double and add on affine coordinates, it works for any curve, but both its
+running time and its branches depend on the scalar
+*/
+func (p *Point) scalarMulAffine(scalar *big.Int) *Point {
 	/*
 		turn scalar into a binary string form - for example, 13 will turn into "1101"
 	*/
@@ -154,6 +184,17 @@ func (p *Point) ScalarMul(scalar *big.Int) *Point {
 	return result
 }
 
+// isS256 checks the point is on the bitcoin curve y^2 = x^3 + 7 over S256Field
+func (p *Point) isS256() bool {
+	return p.a.order.Cmp(S256Prime()) == 0 && p.a.num.Sign() == 0 &&
+		p.b.num.Cmp(big.NewInt(int64(7))) == 0
+}
+
+func (p *Point) isGenerator() bool {
+	G := GetGenerator()
+	return p.x != nil && p.x.EqualTo(G.x) && p.y.EqualTo(G.y)
+}
+
 func (p *Point) Add(other *Point) *Point {
 	//check points are on the same curve
 	if p.a.EqualTo(other.a) != true || p.b.EqualTo(other.b) != true {
@@ -224,6 +265,11 @@ func (p *Point) Add(other *Point) *Point {
 	}
 }
 
+// IsIdentity the point at infinity
+func (p *Point) IsIdentity() bool {
+	return p.x == nil
+}
+
 func (p *Point) String() string {
 	xString := "nil"
 	yString := "nil"
@@ -266,8 +312,8 @@ func (p *Point) Sec(compressed bool) (string, []byte) {
 			padding x,y with leading 0
 		*/
 		secBytes = append(secBytes, 0x04)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
-		secBytes = append(secBytes, p.y.num.Bytes()...)
+		secBytes = append(secBytes, intTo32Bytes(p.x.num)...)
+		secBytes = append(secBytes, intTo32Bytes(p.y.num)...)
 		return fmt.Sprintf("04%064x%064x", p.x.num, p.y.num), secBytes
 	}
 
@@ -275,11 +321,11 @@ func (p *Point) Sec(compressed bool) (string, []byte) {
 	if opMod.Mod(p.y.num, big.NewInt(int64(2))).Cmp(big.NewInt(int64(0))) == 0 {
 		//y is even, set first byte t0 0x02
 		secBytes = append(secBytes, 0x02)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
+		secBytes = append(secBytes, intTo32Bytes(p.x.num)...)
 		return fmt.Sprintf("02%064x", p.x.num), secBytes
 	} else {
 		secBytes = append(secBytes, 0x03)
-		secBytes = append(secBytes, p.x.num.Bytes()...)
+		secBytes = append(secBytes, intTo32Bytes(p.x.num)...)
 		return fmt.Sprintf("03%064x", p.x.num), secBytes
 	}
 }
@@ -289,14 +335,48 @@ func (p *Point) hash160(compressed bool) []byte {
 	return Hash160(secBytes)
 }
 
-func (p *Point) Address(compressed bool, testnet bool) string {
+func (p *Point) Address(compressed bool, params *ChainParams) string {
 	hash160 := p.hash160(compressed)
-	prefix := []byte{}
-	if testnet {
-		prefix = append(prefix, 0x6f)
-	} else {
-		prefix = append(prefix, 0x00)
+	prefix := []byte{params.PubKeyHashAddrID()}
+	return Base58Checksum(append(prefix, hash160...))
+}
+
+/*
+P2wpkhAddress
+native segwit address, witness version 0 with hash160 of the compressed SEC
+as witness program, encoded in bech32
+*/
+func (p *Point) P2wpkhAddress(params *ChainParams) string {
+	hrp := params.Bech32Hrp()
+	address, err := EncodeSegwitAddress(hrp, 0, p.hash160(true))
+	if err != nil {
+		panic(fmt.Sprintf("encode p2wpkh address err: %v\n", err))
 	}
+	return address
+}
 
-	return Base58Checksum(append(prefix, hash160...))
+/*
+P2trAddress
+taproot address of this key without script tree, witness version 1 with the
+x-only tweaked output key as witness program, encoded in bech32m
+*/
+func (p *Point) P2trAddress(params *ChainParams) string {
+	hrp := params.Bech32Hrp()
+	address, err := EncodeSegwitAddress(hrp, 1, TaprootOutputKey(p, nil).XOnly())
+	if err != nil {
+		panic(fmt.Sprintf("encode p2tr address err: %v\n", err))
+	}
+	return address
+}
+
+/*
+P2shP2wpkhAddress
+the P2WPKH script 0x00 0x14 <hash160> wrapped in P2SH for wallets that can only
+pay to base58 addresses, the address is the hash160 of that redeem script with
+the P2SH prefix of params
+*/
+func (p *Point) P2shP2wpkhAddress(params *ChainParams) string {
+	redeemScript := append([]byte{0x00, 0x14}, p.hash160(true)...)
+	prefix := []byte{params.ScriptHashAddrID()}
+	return Base58Checksum(append(prefix, Hash160(redeemScript)...))
 }
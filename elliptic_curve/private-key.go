@@ -1,12 +1,22 @@
 package elliptic_curve
 
 import (
-	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 )
 
+const wifCompressedFlag = 0x01
+
+var (
+	ErrWifLength        = errors.New("wif payload should be 33 or 34 bytes")
+	ErrWifVersion       = errors.New("unknown wif version byte")
+	ErrWifCompressFlag  = errors.New("wif compression flag should be 0x01")
+	ErrPrivateKeyRange  = errors.New("private key should be in [1, n-1]")
+	ErrPrivateKeyLength = errors.New("private key should be 32 bytes")
+)
+
 type PrivateKey struct {
 	secret *big.Int
 	point  *Point
@@ -29,51 +39,86 @@ func (p *PrivateKey) GetPublicKey() *Point {
 	return p.point
 }
 
+// Bytes the secret in 32 bytes big endian
+func (p *PrivateKey) Bytes() []byte {
+	return intTo32Bytes(p.secret)
+}
+
 func (p *PrivateKey) Sign(z *big.Int) *Signature {
+	return p.SignWithExtraEntropy(z, nil)
+}
+
+/*
+SignWithExtraEntropy
+the same as Sign but mixing the given extra entropy (for example 32 bytes aux
+random data) into the RFC 6979 nonce derivation, nil extra entropy gives the
+same signature as Sign
+*/
+func (p *PrivateKey) SignWithExtraEntropy(z *big.Int, extraEntropy []byte) *Signature {
+	sig, _ := p.sign(z, extraEntropy)
+	return sig
+}
+
+/*
+sign returns the signature together with its recovery id, bit 0 of the
+recovery id is the parity of y of R = k * G, bit 1 tells x of R is not less
+than n, see recovery.go
+*/
+func (p *PrivateKey) sign(z *big.Int, extraEntropy []byte) (*Signature, byte) {
 	//(s, r)
 	//s = (z + r * e) / k
-	// k is a strong random number
+	// k is derived from secret and z by RFC 6979, see rfc6979.go
 	n := GetBitcoinValueN()
-	k, err := rand.Int(rand.Reader, n)
-	if err != nil {
-		panic(fmt.Sprintf("Sign err with rand int: %s", err))
-	}
-	kField := NewFieldElement(n, k)
+	k := deterministicK(p.secret, z, extraEntropy)
 	G := GetGenerator()
-	// s = (z + r * e) / k
-	// r = G * k
-	r := G.ScalarMul(k).x.num
-	rField := NewFieldElement(n, r)
-	eField := NewFieldElement(n, p.secret)
-	zField := NewFieldElement(n, z)
+	// r = G * k, the x coordinate is taken modulo n
+	R := G.ScalarMul(k)
+	var rScalar scalarVal
+	rScalar.setBig(R.x.num)
+	recoveryID := byte(R.y.num.Bit(0))
+	if R.x.num.Cmp(n) >= 0 {
+		recoveryID |= 2
+	}
+	/*
+		k and secret must not leak through timing, the arithmetic modulo n
+		runs on the constant time scalarVal instead of FieldElement
+	*/
+	var kScalar, eScalar, zScalar, sScalar scalarVal
+	kScalar.setBig(k)
+	eScalar.setBig(p.secret)
+	zScalar.setBig(z)
 	// r*e
-	rMulSecret := rField.Mul(eField)
+	sScalar.mul(&rScalar, &eScalar)
 	// z+r*e
-	zAddRMulSecret := zField.Add(rMulSecret)
+	sScalar.add(&zScalar, &sScalar)
 	// /k
-	kInverse := kField.Inverse()
-	sField := zAddRMulSecret.Mul(kInverse)
+	var kInverse scalarVal
+	kInverse.inverse(&kScalar)
+	sScalar.mul(&sScalar, &kInverse)
+	r := rScalar.toBig()
+	sField := NewFieldElement(n, sScalar.toBig())
 	/*
 	   if s > n / 2 we need to change it to n - s, when doing signature
 	   verify, s and n - s are equivalence doing this change is for malleability reasons, detail:
 	   https://bitcoin.stackexchange.com/questions/85946/low-s-value-in-bitcoin-signature
+	   n - s is the signature for -k, that is R with y negated
 	*/
 	var opDiv big.Int
 	if sField.num.Cmp(opDiv.Div(n, big.NewInt(int64(2)))) > 0 {
 		var opSub big.Int
 		sField = NewFieldElement(n, opSub.Sub(n, sField.num))
+		recoveryID ^= 1
 	}
 
 	return &Signature{
 		r: NewFieldElement(n, r),
 		s: sField,
-	}
-
+	}, recoveryID
 }
 
 /*
 Wif
-1, set first byte to 0x80 main-net, 0xef testnet
+1, set first byte to the WIF version of params, 0x80 main-net, 0xef the others
 2, append the bytes array of private key behind first byte,
 if the length of bytes array < 32 bytes, append leading 0 to 32 bytes
 3, if public SEC compressed, add suffix byte 0x01
@@ -82,13 +127,8 @@ if the length of bytes array < 32 bytes, append leading 0 to 32 bytes
 
 4,5 base58checksum
 */
-func (p *PrivateKey) Wif(compressed bool, testnet bool) string {
-	bytes := []byte{}
-	if testnet {
-		bytes = append(bytes, 0xef)
-	} else {
-		bytes = append(bytes, 0x80)
-	}
+func (p *PrivateKey) Wif(compressed bool, params *ChainParams) string {
+	bytes := []byte{params.PrivateKeyID()}
 
 	secretBytes := p.secret.Bytes()
 	if len(secretBytes) < 32 {
@@ -103,9 +143,59 @@ func (p *PrivateKey) Wif(compressed bool, testnet bool) string {
 
 	bytes = append(bytes, secretBytes...)
 	if compressed {
-		bytes = append(bytes, 0x01)
+		bytes = append(bytes, wifCompressedFlag)
 	}
 
 	return Base58Checksum(bytes)
 
 }
+
+/*
+ParseWif
+the reverse of Wif, besides the private key it tells whether the public key
+is used in compressed SEC and the network of the key. All test networks share
+one version byte, their keys come back with TestNet3Params. Malformed input
+gives an error (see the Err variables above and ErrBase58Char,
+ErrBase58Checksum), never a panic
+*/
+func ParseWif(wif string) (*PrivateKey, bool, *ChainParams, error) {
+	payload, err := DecodeBase58Check(wif)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if len(payload) != 33 && len(payload) != 34 {
+		return nil, false, nil, ErrWifLength
+	}
+
+	var params *ChainParams
+	switch payload[0] {
+	case MainNetParams.PrivateKeyID():
+		params = MainNetParams
+	case TestNet3Params.PrivateKeyID():
+		params = TestNet3Params
+	default:
+		return nil, false, nil, ErrWifVersion
+	}
+	compressed := len(payload) == 34
+	if compressed && payload[33] != wifCompressedFlag {
+		return nil, false, nil, ErrWifCompressFlag
+	}
+
+	privateKey, err := ParsePrivateKey(payload[1:33])
+	if err != nil {
+		return nil, false, nil, err
+	}
+	return privateKey, compressed, params, nil
+}
+
+// ParsePrivateKey the private key of a 32 bytes big endian secret, checked to be in [1, n-1]
+func ParsePrivateKey(secretBin []byte) (*PrivateKey, error) {
+	if len(secretBin) != 32 {
+		return nil, ErrPrivateKeyLength
+	}
+	secret := new(big.Int).SetBytes(secretBin)
+	if secret.Sign() == 0 || secret.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, ErrPrivateKeyRange
+	}
+	return NewPrivateKey(secret), nil
+}
@@ -0,0 +1,155 @@
+package elliptic_curve
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+public key recovery
+
+an ECDSA signature (r, s) on z only keeps x of R = k * G modulo n. If we also
+know which point R was, the public key comes back from the signature:
+
+	s * R = (z + r * e) * G => P = e * G = (s * R - z * G) / r
+
+x of R is r or r + n (only when r + n < p), and there are two points with this
+x, one with even y and one with odd y, the recovery id (0 to 3) picks one of
+these four candidates: bit 0 is the parity of y, bit 1 means x = r + n.
+
+the compact form is 65 bytes: a header byte then r and s in 32 bytes each,
+header = 27 + recovery id, plus 4 if the public key is SEC compressed, which is
+the format of Bitcoin signed messages
+*/
+
+var (
+	ErrCompactSigLength = errors.New("compact signature should be 65 bytes")
+	ErrCompactSigHeader = errors.New("compact signature header should be in [27, 34]")
+	ErrCompactSigRange  = errors.New("compact signature r and s should be in [1, n - 1]")
+	ErrRecoveryID       = errors.New("recovery id should be in [0, 3]")
+	ErrRecoverPublicKey = errors.New("no public key can be recovered from signature")
+)
+
+const compactSigHeaderBase = 27
+
+type CompactSignature struct {
+	sig        *Signature
+	recoveryID byte
+	compressed bool
+}
+
+func NewCompactSignature(sig *Signature, recoveryID byte, compressed bool) *CompactSignature {
+	return &CompactSignature{
+		sig:        sig,
+		recoveryID: recoveryID,
+		compressed: compressed,
+	}
+}
+
+func (c *CompactSignature) String() string {
+	return fmt.Sprintf("CompactSignature(recovery id: %d, compressed: %v, %s)", c.recoveryID, c.compressed, c.sig)
+}
+
+func (c *CompactSignature) Signature() *Signature {
+	return c.sig
+}
+
+func (c *CompactSignature) RecoveryID() byte {
+	return c.recoveryID
+}
+
+// Compressed tells the public key should be SEC encoded in compressed form
+func (c *CompactSignature) Compressed() bool {
+	return c.compressed
+}
+
+// Serialize header || r || s
+func (c *CompactSignature) Serialize() []byte {
+	header := compactSigHeaderBase + c.recoveryID
+	if c.compressed {
+		header += 4
+	}
+	result := []byte{header}
+	result = append(result, intTo32Bytes(c.sig.r.num)...)
+	return append(result, intTo32Bytes(c.sig.s.num)...)
+}
+
+func ParseCompactSignature(sigBin []byte) (*CompactSignature, error) {
+	if len(sigBin) != 65 {
+		return nil, ErrCompactSigLength
+	}
+	header := sigBin[0]
+	if header < compactSigHeaderBase || header > compactSigHeaderBase+7 {
+		return nil, ErrCompactSigHeader
+	}
+	header -= compactSigHeaderBase
+
+	n := GetBitcoinValueN()
+	r := new(big.Int).SetBytes(sigBin[1:33])
+	s := new(big.Int).SetBytes(sigBin[33:65])
+	//field elements of order n panic on values out of [0, n), check them first
+	if r.Sign() <= 0 || r.Cmp(n) >= 0 || s.Sign() <= 0 || s.Cmp(n) >= 0 {
+		return nil, ErrCompactSigRange
+	}
+	return &CompactSignature{
+		sig:        NewSignature(NewFieldElement(n, r), NewFieldElement(n, s)),
+		recoveryID: header & 3,
+		compressed: header&4 != 0,
+	}, nil
+}
+
+/*
+SignCompact
+signs z like Sign and keeps the recovery id, compressed is only recorded in the
+header byte for the verifier to rebuild the right address
+*/
+func (p *PrivateKey) SignCompact(z *big.Int, compressed bool) *CompactSignature {
+	sig, recoveryID := p.sign(z, nil)
+	return NewCompactSignature(sig, recoveryID, compressed)
+}
+
+/*
+RecoverPublicKey
+P = (s / r) * R - (z / r) * G, any valid signature gives back some public key,
+the caller compares it (or its address) with the expected one
+*/
+func RecoverPublicKey(z *big.Int, sig *CompactSignature) (*Point, error) {
+	if sig.recoveryID > 3 {
+		return nil, ErrRecoveryID
+	}
+	n := GetBitcoinValueN()
+	r := sig.sig.r.num
+	s := sig.sig.s.num
+	if r.Sign() <= 0 || r.Cmp(n) >= 0 || s.Sign() <= 0 || s.Cmp(n) >= 0 {
+		return nil, ErrRecoverPublicKey
+	}
+
+	//x of R is r or r + n
+	x := new(big.Int).Set(r)
+	if sig.recoveryID&2 != 0 {
+		x.Add(x, n)
+	}
+	R, ok := liftX(x)
+	if !ok {
+		return nil, ErrRecoverPublicKey
+	}
+	if R.y.isOdd() != uint64(sig.recoveryID&1) {
+		R.y.negate(&R.y)
+	}
+
+	//u = -z / r, v = s / r, everything here is public
+	rInverse := new(big.Int).ModInverse(r, n)
+	u := new(big.Int).Mul(z, rInverse)
+	u.Neg(u)
+	u.Mod(u, n)
+	v := new(big.Int).Mul(s, rInverse)
+	v.Mod(v, n)
+
+	total := s256VerifySum(u, v, S256Point(R.x.toBig(), R.y.toBig()))
+	P, ok := total.toAffine()
+	if !ok {
+		return nil, ErrRecoverPublicKey
+	}
+	return S256Point(P.x.toBig(), P.y.toBig()), nil
+}
@@ -0,0 +1,74 @@
+package elliptic_curve
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+)
+
+/*
+RFC 6979 deterministic nonce
+
+the k used by Sign must never repeat for two different messages and must never
+be guessable, otherwise the private key can be computed from the signatures.
+Instead of trusting a random number generator, RFC 6979 derives k from the
+private key and the message with HMAC-SHA256, the same key and message will
+always produce the same k and therefore the same signature:
+
+1. V = 0x01 0x01 ... 0x01 (32 bytes), K = 0x00 0x00 ... 0x00 (32 bytes)
+2. K = HMAC_K(V || 0x00 || secret || z [|| extra entropy])
+3. V = HMAC_K(V)
+4. K = HMAC_K(V || 0x01 || secret || z [|| extra entropy])
+5. V = HMAC_K(V)
+6. V = HMAC_K(V), take V as candidate of k, if 1 <= k < n, we are done,
+otherwise K = HMAC_K(V || 0x00), V = HMAC_K(V) and repeat step 6
+
+the optional extra entropy (like the aux data of BIP 340) is mixed into step 2
+and 4, it makes signatures non-deterministic again, but k still does not depend
+on the quality of the random number generator only.
+*/
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// intTo32Bytes big endian bytes of the given value, padding with leading 0 to 32 bytes
+func intTo32Bytes(v *big.Int) []byte {
+	buf := make([]byte, 32)
+	return v.FillBytes(buf)
+}
+
+func deterministicK(secret *big.Int, z *big.Int, extraEntropy []byte) *big.Int {
+	n := GetBitcoinValueN()
+	//z may be larger than n, bits2octets requires z mod n
+	var opMod big.Int
+	zBytes := intTo32Bytes(opMod.Mod(z, n))
+	secretBytes := intTo32Bytes(secret)
+
+	v := make([]byte, 32)
+	for i := range v {
+		v[i] = 0x01
+	}
+	k := make([]byte, 32)
+
+	k = hmacSHA256(k, v, []byte{0x00}, secretBytes, zBytes, extraEntropy)
+	v = hmacSHA256(k, v)
+	k = hmacSHA256(k, v, []byte{0x01}, secretBytes, zBytes, extraEntropy)
+	v = hmacSHA256(k, v)
+
+	for {
+		v = hmacSHA256(k, v)
+		candidate := new(big.Int)
+		candidate.SetBytes(v)
+		if candidate.Sign() > 0 && candidate.Cmp(n) < 0 {
+			return candidate
+		}
+
+		k = hmacSHA256(k, v, []byte{0x00})
+		v = hmacSHA256(k, v)
+	}
+}
@@ -0,0 +1,266 @@
+package elliptic_curve
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+/*
+fieldVal is an element of the secp256k1 base field p = 2^256 - 2^32 - 977 held
+in four 64 bits limbs, limb 0 is the least significant one.
+
+FieldElement is built on big.Int, the time it takes depends on the value it is
+working on (leading zeros, branches in the big.Int algorithms), which leaks the
+secret when we multiply a point by a private key or nonce. Every operation on
+fieldVal runs the same instructions whatever the values are, there is no branch
+or memory access depending on the value, results are always fully reduced into
+[0, p).
+
+the trick for reduction: 2^256 = 2^32 + 977 (mod p), then a 512 bits product
+hi * 2^256 + lo can be folded into lo + hi * 0x1000003d1
+*/
+type fieldVal [4]uint64
+
+var fieldPrime = fieldVal{0xfffffffefffffc2f, 0xffffffffffffffff, 0xffffffffffffffff, 0xffffffffffffffff}
+
+const fieldReduceC = 0x1000003d1
+
+func (f *fieldVal) setInt(v uint64) *fieldVal {
+	*f = fieldVal{v, 0, 0, 0}
+	return f
+}
+
+// setBytes sets f from 32 bytes big endian value, the value is reduced by p
+func (f *fieldVal) setBytes(b []byte) *fieldVal {
+	for i := 0; i < 4; i++ {
+		var limb uint64
+		for j := 0; j < 8; j++ {
+			limb = limb<<8 | uint64(b[(3-i)*8+j])
+		}
+		f[i] = limb
+	}
+	f.reduceOnce(0)
+	return f
+}
+
+func (f *fieldVal) setBig(v *big.Int) *fieldVal {
+	var opMod big.Int
+	return f.setBytes(intTo32Bytes(opMod.Mod(v, S256Prime())))
+}
+
+func (f *fieldVal) bytes() []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[(3-i)*8+j] = byte(f[i] >> (56 - 8*j))
+		}
+	}
+	return b
+}
+
+func (f *fieldVal) toBig() *big.Int {
+	return new(big.Int).SetBytes(f.bytes())
+}
+
+// isZero returns 1 if f is zero, otherwise 0
+func (f *fieldVal) isZero() uint64 {
+	acc := f[0] | f[1] | f[2] | f[3]
+	//acc | -acc has the most significant bit set if and only if acc != 0
+	return 1 ^ ((acc | -acc) >> 63)
+}
+
+func (f *fieldVal) equal(other *fieldVal) uint64 {
+	var d fieldVal
+	for i := 0; i < 4; i++ {
+		d[i] = f[i] ^ other[i]
+	}
+	return d.isZero()
+}
+
+func (f *fieldVal) isOdd() uint64 {
+	return f[0] & 1
+}
+
+// cmov sets f to a if flag is 1, keeps f if flag is 0
+func (f *fieldVal) cmov(a *fieldVal, flag uint64) {
+	mask := -flag
+	for i := 0; i < 4; i++ {
+		f[i] = (f[i] &^ mask) | (a[i] & mask)
+	}
+}
+
+/*
+reduceOnce
+the value carry * 2^256 + f is in [0, 2p), subtract p if the value >= p
+*/
+func (f *fieldVal) reduceOnce(carry uint64) {
+	var t fieldVal
+	var borrow uint64
+	t[0], borrow = bits.Sub64(f[0], fieldPrime[0], 0)
+	t[1], borrow = bits.Sub64(f[1], fieldPrime[1], borrow)
+	t[2], borrow = bits.Sub64(f[2], fieldPrime[2], borrow)
+	t[3], borrow = bits.Sub64(f[3], fieldPrime[3], borrow)
+	//subtraction is needed if there is a carry out or there is no borrow
+	_, borrow = bits.Sub64(carry, 0, borrow)
+	f.cmov(&t, borrow^1)
+}
+
+func (f *fieldVal) add(a, b *fieldVal) *fieldVal {
+	var carry uint64
+	f[0], carry = bits.Add64(a[0], b[0], 0)
+	f[1], carry = bits.Add64(a[1], b[1], carry)
+	f[2], carry = bits.Add64(a[2], b[2], carry)
+	f[3], carry = bits.Add64(a[3], b[3], carry)
+	f.reduceOnce(carry)
+	return f
+}
+
+func (f *fieldVal) sub(a, b *fieldVal) *fieldVal {
+	var borrow uint64
+	f[0], borrow = bits.Sub64(a[0], b[0], 0)
+	f[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	f[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	f[3], borrow = bits.Sub64(a[3], b[3], borrow)
+	//add p back if the result is negative
+	mask := -borrow
+	var carry uint64
+	f[0], carry = bits.Add64(f[0], fieldPrime[0]&mask, 0)
+	f[1], carry = bits.Add64(f[1], fieldPrime[1]&mask, carry)
+	f[2], carry = bits.Add64(f[2], fieldPrime[2]&mask, carry)
+	f[3], _ = bits.Add64(f[3], fieldPrime[3]&mask, carry)
+	return f
+}
+
+func (f *fieldVal) negate(a *fieldVal) *fieldVal {
+	var zero fieldVal
+	return f.sub(&zero, a)
+}
+
+func (f *fieldVal) mul(a, b *fieldVal) *fieldVal {
+	//schoolbook multiplication into 8 limbs
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var c uint64
+			lo, c = bits.Add64(lo, t[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[i+j] = lo
+			carry = hi
+		}
+		t[i+4] = carry
+	}
+
+	//fold the high 256 bits: lo + hi * 0x1000003d1, result fits in 5 limbs
+	var r [5]uint64
+	var carry uint64
+	for i := 0; i < 4; i++ {
+		hi, lo := bits.Mul64(t[i+4], fieldReduceC)
+		var c uint64
+		lo, c = bits.Add64(lo, t[i], 0)
+		hi += c
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		r[i] = lo
+		carry = hi
+	}
+	r[4] = carry
+
+	//fold the fifth limb once more, it is less than 2^34
+	hi, lo := bits.Mul64(r[4], fieldReduceC)
+	var c uint64
+	f[0], c = bits.Add64(r[0], lo, 0)
+	f[1], c = bits.Add64(r[1], hi, c)
+	f[2], c = bits.Add64(r[2], 0, c)
+	f[3], c = bits.Add64(r[3], 0, c)
+	/*
+		if there is still a carry, the value in f is tiny, adding another
+		0x1000003d1 for the carry will not overflow
+	*/
+	f[0], c = bits.Add64(f[0], fieldReduceC&(-c), 0)
+	f[1], c = bits.Add64(f[1], 0, c)
+	f[2], c = bits.Add64(f[2], 0, c)
+	f[3], _ = bits.Add64(f[3], 0, c)
+	f.reduceOnce(0)
+	return f
+}
+
+func (f *fieldVal) square(a *fieldVal) *fieldVal {
+	return f.mul(a, a)
+}
+
+// sqrN squares a n times
+func (f *fieldVal) sqrN(a *fieldVal, n int) *fieldVal {
+	*f = *a
+	for i := 0; i < n; i++ {
+		f.square(f)
+	}
+	return f
+}
+
+/*
+powChain computes the powers a^(2^k - 1) for the blocks of 1s in p - 2 and
+(p + 1) / 4, both exponents are made of blocks with 1s of length 1, 2, 22 and 223:
+x2 = a^(2^2 - 1), x22 = a^(2^22 - 1), x223 = a^(2^223 - 1)
+
+the exponents are public constants, walking them with an addition chain takes
+255 squares and 15 multiplications instead of about 500 operations of plain
+square and multiply
+*/
+func powChain(a *fieldVal) (x2, x22, x223 fieldVal) {
+	var x3, x6, x9, x11, x44, x88, x176, x220, t fieldVal
+	x2.square(a)
+	x2.mul(&x2, a)
+	x3.square(&x2)
+	x3.mul(&x3, a)
+	x6.sqrN(&x3, 3)
+	x6.mul(&x6, &x3)
+	x9.sqrN(&x6, 3)
+	x9.mul(&x9, &x3)
+	x11.sqrN(&x9, 2)
+	x11.mul(&x11, &x2)
+	x22.sqrN(&x11, 11)
+	x22.mul(&x22, &x11)
+	x44.sqrN(&x22, 22)
+	x44.mul(&x44, &x22)
+	x88.sqrN(&x44, 44)
+	x88.mul(&x88, &x44)
+	x176.sqrN(&x88, 88)
+	x176.mul(&x176, &x88)
+	x220.sqrN(&x176, 44)
+	x220.mul(&x220, &x44)
+	t.sqrN(&x220, 3)
+	x223.mul(&t, &x3)
+	return x2, x22, x223
+}
+
+// inverse a^(p-2), inverse of 0 is 0
+func (f *fieldVal) inverse(a *fieldVal) *fieldVal {
+	base := *a
+	x2, x22, x223 := powChain(&base)
+	var t fieldVal
+	t.sqrN(&x223, 23)
+	t.mul(&t, &x22)
+	t.sqrN(&t, 5)
+	t.mul(&t, &base)
+	t.sqrN(&t, 3)
+	t.mul(&t, &x2)
+	t.sqrN(&t, 2)
+	f.mul(&t, &base)
+	return f
+}
+
+// sqrt a^((p+1)/4), see FieldElement.Sqrt, the caller checks the result squares back to a
+func (f *fieldVal) sqrt(a *fieldVal) *fieldVal {
+	x2, x22, x223 := powChain(a)
+	var t fieldVal
+	t.sqrN(&x223, 23)
+	t.mul(&t, &x22)
+	t.sqrN(&t, 6)
+	t.mul(&t, &x2)
+	f.sqrN(&t, 2)
+	return f
+}
@@ -0,0 +1,211 @@
+package elliptic_curve
+
+import (
+	"math/big"
+	"sync"
+)
+
+/*
+GLV endomorphism for secp256k1
+
+there is a cube root of unity beta in the field and a cube root of unity lambda
+modulo n such that for any point P = (x, y):
+
+	lambda * P = (beta * x, y)
+
+that is, multiply by lambda costs only one field multiplication. Any scalar k can
+be split into k = k1 + k2 * lambda (mod n) with k1, k2 about 128 bits, then
+
+	k * P = k1 * P + k2 * (lambda * P)
+
+two 128 bits multiplications which can share the same doublings (Strauss-Shamir
+trick), so we only need half the doublings of a 256 bits multiplication.
+For verification u * G + v * P becomes four 128 bits multiplications sharing one
+chain of 128 doublings.
+*/
+
+var (
+	endoBeta   = fieldVal{0xc1396c28719501ee, 0x9cf0497512f58995, 0x6e64479eac3434e9, 0x7ae96a2b657c0710}
+	endoLambda = hexBig("5363ad4cc05c30e0a5261c028812645a122e22ea20816678df02967c1b23bd72")
+
+	//short basis of the lattice {(a, b): a + b * lambda = 0 mod n}
+	endoA1 = hexBig("3086d221a7d46bcde86c90e49284eb15")
+	endoB1 = hexBig("-e4437ed6010e88286f547fa90abfe4c3")
+	endoA2 = hexBig("114ca50f7a8e2f3f657c1108d9d44cfd8")
+	endoB2 = hexBig("3086d221a7d46bcde86c90e49284eb15")
+)
+
+func hexBig(s string) *big.Int {
+	v := new(big.Int)
+	v.SetString(s, 16)
+	return v
+}
+
+// roundDiv round(a / b) for b > 0
+func roundDiv(a *big.Int, b *big.Int) *big.Int {
+	var opMul, opAdd, opDiv big.Int
+	//floor((2a + b) / 2b)
+	numerator := opAdd.Add(opMul.Lsh(a, 1), b)
+	return opDiv.Div(numerator, new(big.Int).Lsh(b, 1))
+}
+
+/*
+splitScalar finds k1, k2 with k = k1 + k2 * lambda (mod n):
+c1 = round(b2 * k / n), c2 = round(-b1 * k / n)
+k1 = k - c1 * a1 - c2 * a2, k2 = -c1 * b1 - c2 * b2
+both k1 and k2 may be negative, their absolute value is no more than 129 bits
+*/
+func splitScalar(k *big.Int) (*big.Int, *big.Int) {
+	n := GetBitcoinValueN()
+	kMod := new(big.Int).Mod(k, n)
+
+	c1 := roundDiv(new(big.Int).Mul(endoB2, kMod), n)
+	c2 := roundDiv(new(big.Int).Mul(new(big.Int).Neg(endoB1), kMod), n)
+
+	k1 := new(big.Int).Sub(kMod, new(big.Int).Mul(c1, endoA1))
+	k1.Sub(k1, new(big.Int).Mul(c2, endoA2))
+	k2 := new(big.Int).Neg(new(big.Int).Mul(c1, endoB1))
+	k2.Sub(k2, new(big.Int).Mul(c2, endoB2))
+	return k1, k2
+}
+
+// endomorphism lambda * P = (beta * x, y)
+func (a *affinePoint) endomorphism() affinePoint {
+	var result affinePoint
+	result.x.mul(&a.x, &endoBeta)
+	result.y = a.y
+	return result
+}
+
+func endomorphismTable(table []affinePoint) []affinePoint {
+	result := make([]affinePoint, len(table))
+	for i := range table {
+		result[i] = table[i].endomorphism()
+	}
+	return result
+}
+
+/*
+straussTerm one k * P of the sum, table holds the odd multiples of P, digits
+the wNAF of |k|, negative tells k < 0 so every digit is used with flipped sign
+*/
+type straussTerm struct {
+	table    []affinePoint
+	digits   []int8
+	negative bool
+}
+
+func newStraussTerm(table []affinePoint, k *big.Int, window uint) straussTerm {
+	var kAbs scalarVal
+	kAbs.setBig(new(big.Int).Abs(k))
+	return straussTerm{
+		table:    table,
+		digits:   wnaf(&kAbs, window),
+		negative: k.Sign() < 0,
+	}
+}
+
+/*
+straussMulVartime computes sum(k_i * P_i) with one shared chain of doublings,
+for each bit position we double once, then add the table entry of every term
+whose digit is not zero
+*/
+func straussMulVartime(terms []straussTerm) jacobianPoint {
+	maxLen := 0
+	for _, term := range terms {
+		if len(term.digits) > maxLen {
+			maxLen = len(term.digits)
+		}
+	}
+
+	var result jacobianPoint
+	result.setIdentity()
+	for i := maxLen - 1; i >= 0; i-- {
+		result.double(&result)
+		for _, term := range terms {
+			if i >= len(term.digits) || term.digits[i] == 0 {
+				continue
+			}
+			d := int(term.digits[i])
+			if term.negative {
+				d = -d
+			}
+			if d > 0 {
+				result.addMixed(&result, &term.table[d/2])
+			} else {
+				neg := term.table[(-d)/2].negate()
+				result.addMixed(&result, &neg)
+			}
+		}
+	}
+
+	return result
+}
+
+/*
+odd multiples of G and lambda * G for the Strauss-Shamir verification, G never
+changes so we afford a wider window than for P
+*/
+const generatorWindow = 8
+
+var (
+	generatorOddTable       []affinePoint
+	generatorLambdaOddTable []affinePoint
+	generatorOddTableOnce   sync.Once
+)
+
+func initGeneratorOddTable() {
+	g := generatorAffine()
+	generatorOddTable = oddMultiples(&g, 1<<(generatorWindow-2))
+	generatorLambdaOddTable = endomorphismTable(generatorOddTable)
+}
+
+/*
+s256VerifySum computes u * G + v * P for signature verification:
+u = u1 + u2 * lambda, v = v1 + v2 * lambda
+u * G + v * P = u1 * G + u2 * (lambda * G) + v1 * P + v2 * (lambda * P)
+*/
+func s256VerifySum(u *big.Int, v *big.Int, p *Point) jacobianPoint {
+	generatorOddTableOnce.Do(initGeneratorOddTable)
+
+	var pAffine affinePoint
+	pAffine.x.setBig(p.x.num)
+	pAffine.y.setBig(p.y.num)
+	pTable := oddMultiples(&pAffine, 1<<(wnafWindow-2))
+	pLambdaTable := endomorphismTable(pTable)
+
+	u1, u2 := splitScalar(u)
+	v1, v2 := splitScalar(v)
+	return straussMulVartime([]straussTerm{
+		newStraussTerm(generatorOddTable, u1, generatorWindow),
+		newStraussTerm(generatorLambdaOddTable, u2, generatorWindow),
+		newStraussTerm(pTable, v1, wnafWindow),
+		newStraussTerm(pLambdaTable, v2, wnafWindow),
+	})
+}
+
+/*
+s256VerifyR checks the x coordinate of u * G + v * P is r modulo n.
+x = X / Z^2, instead of paying for the inversion of Z we check X == r * Z^2,
+since x < p and r = x mod n, x may also be r + n when r + n < p
+*/
+func s256VerifyR(u *big.Int, v *big.Int, r *big.Int, p *Point) bool {
+	total := s256VerifySum(u, v, p)
+	if total.isIdentity() {
+		return false
+	}
+
+	var zz, rField, rz fieldVal
+	zz.square(&total.z)
+	rField.setBig(r)
+	if rz.mul(&rField, &zz).equal(&total.x) == 1 {
+		return true
+	}
+
+	rPlusN := new(big.Int).Add(r, GetBitcoinValueN())
+	if rPlusN.Cmp(S256Prime()) >= 0 {
+		return false
+	}
+	rField.setBig(rPlusN)
+	return rz.mul(&rField, &zz).equal(&total.x) == 1
+}
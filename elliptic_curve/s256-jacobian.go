@@ -0,0 +1,455 @@
+package elliptic_curve
+
+import (
+	"math/big"
+	"sync"
+)
+
+/*
+jacobianPoint is a secp256k1 point in Jacobian coordinates (X : Y : Z), the
+affine point is (X/Z^2, Y/Z^3), identity has Z = 0.
+
+Point.Add computes the slope (y2-y1)/(x2-x1) which needs a field inversion,
+that is about 256 squares, for every single addition. In Jacobian coordinates
+addition and doubling only need a handful of multiplications, we only do one
+inversion at the very end to get back the affine x, y.
+
+The functions here branch on the values (identity, doubling, zero digits), they
+are only used for public data like signature verification, anything touching a
+secret goes to the constant time code in s256-point.go.
+*/
+type jacobianPoint struct {
+	x fieldVal
+	y fieldVal
+	z fieldVal
+}
+
+// affinePoint is a non identity point with Z = 1, tables are kept in this form
+type affinePoint struct {
+	x fieldVal
+	y fieldVal
+}
+
+func (p *jacobianPoint) setIdentity() *jacobianPoint {
+	p.x.setInt(0)
+	p.y.setInt(1)
+	p.z.setInt(0)
+	return p
+}
+
+func (p *jacobianPoint) setAffine(a *affinePoint) *jacobianPoint {
+	p.x = a.x
+	p.y = a.y
+	p.z.setInt(1)
+	return p
+}
+
+func (p *jacobianPoint) isIdentity() bool {
+	return p.z.isZero() == 1
+}
+
+/*
+double for a = 0 (dbl-2009-l):
+A = X1^2, B = Y1^2, C = B^2, D = 2*((X1+B)^2-A-C), E = 3*A, F = E^2
+X3 = F-2*D, Y3 = E*(D-X3)-8*C, Z3 = 2*Y1*Z1
+*/
+func (r *jacobianPoint) double(p *jacobianPoint) *jacobianPoint {
+	if p.isIdentity() {
+		return r.setIdentity()
+	}
+
+	var a, b, c, d, e, f, t fieldVal
+	a.square(&p.x)
+	b.square(&p.y)
+	c.square(&b)
+	d.add(&p.x, &b)
+	d.square(&d)
+	d.sub(&d, &a)
+	d.sub(&d, &c)
+	d.add(&d, &d)
+	e.add(&a, &a)
+	e.add(&e, &a)
+	f.square(&e)
+
+	var x3, y3, z3 fieldVal
+	x3.sub(&f, &d)
+	x3.sub(&x3, &d)
+	y3.sub(&d, &x3)
+	y3.mul(&e, &y3)
+	t.add(&c, &c)
+	t.add(&t, &t)
+	t.add(&t, &t)
+	y3.sub(&y3, &t)
+	z3.mul(&p.y, &p.z)
+	z3.add(&z3, &z3)
+
+	r.x = x3
+	r.y = y3
+	r.z = z3
+	return r
+}
+
+/*
+addMixed adds an affine point q (Z2 = 1) to p (madd-2007-bl):
+Z1Z1 = Z1^2, U2 = X2*Z1Z1, S2 = Y2*Z1*Z1Z1, H = U2-X1, HH = H^2, I = 4*HH, J = H*I
+r = 2*(S2-Y1), V = X1*I
+X3 = r^2-J-2*V, Y3 = r*(V-X3)-2*Y1*J, Z3 = (Z1+H)^2-Z1Z1-HH
+*/
+func (r *jacobianPoint) addMixed(p *jacobianPoint, q *affinePoint) *jacobianPoint {
+	if p.isIdentity() {
+		return r.setAffine(q)
+	}
+
+	var z1z1, u2, s2, h, hh, i, j, rr, v fieldVal
+	z1z1.square(&p.z)
+	u2.mul(&q.x, &z1z1)
+	s2.mul(&q.y, &p.z)
+	s2.mul(&s2, &z1z1)
+	h.sub(&u2, &p.x)
+	rr.sub(&s2, &p.y)
+	rr.add(&rr, &rr)
+	if h.isZero() == 1 {
+		if rr.isZero() == 1 {
+			//the same point
+			return r.double(p)
+		}
+		//opposite points
+		return r.setIdentity()
+	}
+	hh.square(&h)
+	i.add(&hh, &hh)
+	i.add(&i, &i)
+	j.mul(&h, &i)
+	v.mul(&p.x, &i)
+
+	var x3, y3, z3, t fieldVal
+	x3.square(&rr)
+	x3.sub(&x3, &j)
+	x3.sub(&x3, &v)
+	x3.sub(&x3, &v)
+	y3.sub(&v, &x3)
+	y3.mul(&rr, &y3)
+	t.mul(&p.y, &j)
+	t.add(&t, &t)
+	y3.sub(&y3, &t)
+	z3.add(&p.z, &h)
+	z3.square(&z3)
+	z3.sub(&z3, &z1z1)
+	z3.sub(&z3, &hh)
+
+	r.x = x3
+	r.y = y3
+	r.z = z3
+	return r
+}
+
+/*
+add two Jacobian points (add-2007-bl):
+Z1Z1 = Z1^2, Z2Z2 = Z2^2, U1 = X1*Z2Z2, U2 = X2*Z1Z1, S1 = Y1*Z2*Z2Z2, S2 = Y2*Z1*Z1Z1
+H = U2-U1, I = (2*H)^2, J = H*I, r = 2*(S2-S1), V = U1*I
+X3 = r^2-J-2*V, Y3 = r*(V-X3)-2*S1*J, Z3 = ((Z1+Z2)^2-Z1Z1-Z2Z2)*H
+*/
+func (r *jacobianPoint) add(p, q *jacobianPoint) *jacobianPoint {
+	if p.isIdentity() {
+		*r = *q
+		return r
+	}
+	if q.isIdentity() {
+		*r = *p
+		return r
+	}
+
+	var z1z1, z2z2, u1, u2, s1, s2, h, i, j, rr, v fieldVal
+	z1z1.square(&p.z)
+	z2z2.square(&q.z)
+	u1.mul(&p.x, &z2z2)
+	u2.mul(&q.x, &z1z1)
+	s1.mul(&p.y, &q.z)
+	s1.mul(&s1, &z2z2)
+	s2.mul(&q.y, &p.z)
+	s2.mul(&s2, &z1z1)
+	h.sub(&u2, &u1)
+	rr.sub(&s2, &s1)
+	rr.add(&rr, &rr)
+	if h.isZero() == 1 {
+		if rr.isZero() == 1 {
+			return r.double(p)
+		}
+		return r.setIdentity()
+	}
+	i.add(&h, &h)
+	i.square(&i)
+	j.mul(&h, &i)
+	v.mul(&u1, &i)
+
+	var x3, y3, z3, t fieldVal
+	x3.square(&rr)
+	x3.sub(&x3, &j)
+	x3.sub(&x3, &v)
+	x3.sub(&x3, &v)
+	y3.sub(&v, &x3)
+	y3.mul(&rr, &y3)
+	t.mul(&s1, &j)
+	t.add(&t, &t)
+	y3.sub(&y3, &t)
+	z3.add(&p.z, &q.z)
+	z3.square(&z3)
+	z3.sub(&z3, &z1z1)
+	z3.sub(&z3, &z2z2)
+	z3.mul(&z3, &h)
+
+	r.x = x3
+	r.y = y3
+	r.z = z3
+	return r
+}
+
+// toAffine x = X/Z^2, y = Y/Z^3, ok is false for identity
+func (p *jacobianPoint) toAffine() (affinePoint, bool) {
+	var a affinePoint
+	if p.isIdentity() {
+		return a, false
+	}
+	var zInv, zInv2 fieldVal
+	zInv.inverse(&p.z)
+	zInv2.square(&zInv)
+	a.x.mul(&p.x, &zInv2)
+	a.y.mul(&p.y, &zInv2)
+	a.y.mul(&a.y, &zInv)
+	return a, true
+}
+
+/*
+batchToAffine converts many non identity points with one single inversion
+(Montgomery's trick): with prefix products c_i = z_0 * ... * z_i, invert c_n
+once, then walk backward, 1/z_i = c_(i-1) * (1/c_i) and 1/c_(i-1) = z_i * (1/c_i)
+*/
+func batchToAffine(points []jacobianPoint) []affinePoint {
+	result := make([]affinePoint, len(points))
+	if len(points) == 0 {
+		return result
+	}
+
+	prefix := make([]fieldVal, len(points))
+	prefix[0] = points[0].z
+	for i := 1; i < len(points); i++ {
+		prefix[i].mul(&prefix[i-1], &points[i].z)
+	}
+
+	var inv fieldVal
+	inv.inverse(&prefix[len(points)-1])
+	for i := len(points) - 1; i >= 0; i-- {
+		var zInv fieldVal
+		if i > 0 {
+			zInv.mul(&inv, &prefix[i-1])
+			inv.mul(&inv, &points[i].z)
+		} else {
+			zInv = inv
+		}
+		var zInv2 fieldVal
+		zInv2.square(&zInv)
+		result[i].x.mul(&points[i].x, &zInv2)
+		result[i].y.mul(&points[i].y, &zInv2)
+		result[i].y.mul(&result[i].y, &zInv)
+	}
+
+	return result
+}
+
+/*
+wnaf turns k into its width-w non adjacent form: digits d_i with k = sum(d_i * 2^i),
+every non zero digit is odd and in (-2^(w-1), 2^(w-1)), and between two non zero
+digits there are at least w-1 zeros, so scalar multiplication only needs to
+precompute P, 3P, ..., (2^(w-1)-1)P and does about 256/(w+1) additions.
+*/
+func wnaf(k *scalarVal, w uint) []int8 {
+	//work on limbs to avoid big.Int allocations, one extra limb for the carry
+	var limbs [5]uint64
+	copy(limbs[:4], k[:])
+
+	window := uint64(1) << w
+	half := int64(window >> 1)
+	digits := make([]int8, 0, 258)
+	for limbs[0]|limbs[1]|limbs[2]|limbs[3]|limbs[4] != 0 {
+		digit := int64(0)
+		if limbs[0]&1 == 1 {
+			digit = int64(limbs[0] & (window - 1))
+			if digit >= half {
+				digit -= int64(window)
+			}
+			//k = k - digit
+			if digit > 0 {
+				limbsSub(&limbs, uint64(digit))
+			} else {
+				limbsAdd(&limbs, uint64(-digit))
+			}
+		}
+		digits = append(digits, int8(digit))
+		//k = k >> 1
+		for i := 0; i < 4; i++ {
+			limbs[i] = limbs[i]>>1 | limbs[i+1]<<63
+		}
+		limbs[4] >>= 1
+	}
+
+	return digits
+}
+
+func limbsAdd(limbs *[5]uint64, v uint64) {
+	for i := 0; i < 5 && v != 0; i++ {
+		old := limbs[i]
+		limbs[i] += v
+		if limbs[i] < old {
+			v = 1
+		} else {
+			v = 0
+		}
+	}
+}
+
+func limbsSub(limbs *[5]uint64, v uint64) {
+	for i := 0; i < 5 && v != 0; i++ {
+		old := limbs[i]
+		limbs[i] -= v
+		if limbs[i] > old {
+			v = 1
+		} else {
+			v = 0
+		}
+	}
+}
+
+// oddMultiples returns P, 3P, 5P, ..., (2*count-1)P in affine form
+func oddMultiples(p *affinePoint, count int) []affinePoint {
+	return batchToAffine(oddMultiplesJacobian(p, count))
+}
+
+/*
+oddMultiplesJacobian the same multiples before going back to affine, callers
+building many tables can convert all of them with one batchToAffine
+*/
+func oddMultiplesJacobian(p *affinePoint, count int) []jacobianPoint {
+	points := make([]jacobianPoint, count)
+	points[0].setAffine(p)
+	var twoP jacobianPoint
+	twoP.double(&points[0])
+	for i := 1; i < count; i++ {
+		points[i].add(&points[i-1], &twoP)
+	}
+	return points
+}
+
+func (a *affinePoint) negate() affinePoint {
+	var neg affinePoint
+	neg.x = a.x
+	neg.y.negate(&a.y)
+	return neg
+}
+
+const wnafWindow = 5
+
+// scalarMulVartime k * P with wNAF, k and P must be public
+func scalarMulVartime(p *affinePoint, k *big.Int) jacobianPoint {
+	var kScalar scalarVal
+	kScalar.setBig(k)
+	table := oddMultiples(p, 1<<(wnafWindow-2))
+	digits := wnaf(&kScalar, wnafWindow)
+
+	var result jacobianPoint
+	result.setIdentity()
+	for i := len(digits) - 1; i >= 0; i-- {
+		result.double(&result)
+		d := digits[i]
+		if d > 0 {
+			result.addMixed(&result, &table[d/2])
+		} else if d < 0 {
+			neg := table[(-d)/2].negate()
+			result.addMixed(&result, &neg)
+		}
+	}
+
+	return result
+}
+
+/*
+comb table for the generator G:
+baseTable[i][j-1] = j * 16^i * G for i in [0, 63], j in [1, 15]
+
+then k * G = sum(baseTable[i][nibble_i(k)]) is 64 additions without any
+doubling, the table takes 64KB and is built once on first use.
+*/
+var (
+	baseTable     [64][15]affinePoint
+	baseTableOnce sync.Once
+)
+
+func generatorAffine() affinePoint {
+	var g affinePoint
+	G := GetGenerator()
+	g.x.setBig(G.x.num)
+	g.y.setBig(G.y.num)
+	return g
+}
+
+func initBaseTable() {
+	points := make([]jacobianPoint, 0, 64*15)
+	var base jacobianPoint
+	g := generatorAffine()
+	base.setAffine(&g)
+	for i := 0; i < 64; i++ {
+		current := base
+		for j := 1; j <= 15; j++ {
+			points = append(points, current)
+			current.add(&current, &base)
+		}
+		//current is 16 * base now
+		base = current
+	}
+
+	affine := batchToAffine(points)
+	for i := 0; i < 64; i++ {
+		copy(baseTable[i][:], affine[i*15:(i+1)*15])
+	}
+}
+
+/*
+scalarBaseMulConstTime k * G with the comb table, every window scans the 15
+entries of its row with conditional moves and always does one complete
+addition, window with value 0 adds identity
+*/
+func scalarBaseMulConstTime(k *scalarVal) projectivePoint {
+	baseTableOnce.Do(initBaseTable)
+
+	var result projectivePoint
+	result.setIdentity()
+	for i := 0; i < 64; i++ {
+		window := k.nibble(i)
+		var selected, candidate projectivePoint
+		selected.setIdentity()
+		for j := 1; j <= 15; j++ {
+			candidate.setAffine(&baseTable[i][j-1].x, &baseTable[i][j-1].y)
+			selected.cmov(&candidate, constTimeEq(uint64(j), window))
+		}
+		result.add(&result, &selected)
+	}
+
+	return result
+}
+
+// scalarBaseMulVartime k * G with the comb table for public k
+func scalarBaseMulVartime(k *big.Int) jacobianPoint {
+	baseTableOnce.Do(initBaseTable)
+
+	var kScalar scalarVal
+	kScalar.setBig(k)
+	var result jacobianPoint
+	result.setIdentity()
+	for i := 0; i < 64; i++ {
+		window := kScalar.nibble(i)
+		if window != 0 {
+			result.addMixed(&result, &baseTable[i][window-1])
+		}
+	}
+
+	return result
+}
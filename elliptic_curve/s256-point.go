@@ -0,0 +1,200 @@
+package elliptic_curve
+
+import (
+	"math/big"
+)
+
+/*
+projectivePoint is a secp256k1 point in projective coordinates (X : Y : Z),
+the affine point is (X/Z, Y/Z), identity is (0 : 1 : 0).
+
+Point.Add needs a field inversion for the slope and branches for identity,
+doubling and opposite points, both depend on the values. Here we use the
+complete formulas from Renes, Costello and Batina "Complete addition formulas
+for prime order elliptic curves" (algorithm 7 and 9 for a = 0), the same
+sequence of field operations works for every input, including identity and
+doubling, that is what makes constant time scalar multiplication possible.
+*/
+type projectivePoint struct {
+	x fieldVal
+	y fieldVal
+	z fieldVal
+}
+
+// 3 * b, b = 7 for secp256k1
+var curveB3 = fieldVal{21, 0, 0, 0}
+
+func (p *projectivePoint) setIdentity() *projectivePoint {
+	p.x.setInt(0)
+	p.y.setInt(1)
+	p.z.setInt(0)
+	return p
+}
+
+func (p *projectivePoint) setAffine(x, y *fieldVal) *projectivePoint {
+	p.x = *x
+	p.y = *y
+	p.z.setInt(1)
+	return p
+}
+
+func (p *projectivePoint) cmov(a *projectivePoint, flag uint64) {
+	p.x.cmov(&a.x, flag)
+	p.y.cmov(&a.y, flag)
+	p.z.cmov(&a.z, flag)
+}
+
+// add complete addition, algorithm 7, r may be the same as p or q
+func (r *projectivePoint) add(p, q *projectivePoint) *projectivePoint {
+	var t0, t1, t2, t3, t4, x3, y3, z3 fieldVal
+	t0.mul(&p.x, &q.x)
+	t1.mul(&p.y, &q.y)
+	t2.mul(&p.z, &q.z)
+	t3.add(&p.x, &p.y)
+	t4.add(&q.x, &q.y)
+	t3.mul(&t3, &t4)
+	t4.add(&t0, &t1)
+	t3.sub(&t3, &t4)
+	t4.add(&p.y, &p.z)
+	x3.add(&q.y, &q.z)
+	t4.mul(&t4, &x3)
+	x3.add(&t1, &t2)
+	t4.sub(&t4, &x3)
+	x3.add(&p.x, &p.z)
+	y3.add(&q.x, &q.z)
+	x3.mul(&x3, &y3)
+	y3.add(&t0, &t2)
+	y3.sub(&x3, &y3)
+	x3.add(&t0, &t0)
+	t0.add(&x3, &t0)
+	t2.mul(&curveB3, &t2)
+	z3.add(&t1, &t2)
+	t1.sub(&t1, &t2)
+	y3.mul(&curveB3, &y3)
+	x3.mul(&t4, &y3)
+	t2.mul(&t3, &t1)
+	x3.sub(&t2, &x3)
+	y3.mul(&y3, &t0)
+	t1.mul(&t1, &z3)
+	y3.add(&t1, &y3)
+	t0.mul(&t0, &t3)
+	z3.mul(&z3, &t4)
+	z3.add(&z3, &t0)
+
+	r.x = x3
+	r.y = y3
+	r.z = z3
+	return r
+}
+
+// double complete doubling, algorithm 9, r may be the same as p
+func (r *projectivePoint) double(p *projectivePoint) *projectivePoint {
+	var t0, t1, t2, x3, y3, z3 fieldVal
+	t0.square(&p.y)
+	z3.add(&t0, &t0)
+	z3.add(&z3, &z3)
+	z3.add(&z3, &z3)
+	t1.mul(&p.y, &p.z)
+	t2.square(&p.z)
+	t2.mul(&curveB3, &t2)
+	x3.mul(&t2, &z3)
+	y3.add(&t0, &t2)
+	z3.mul(&t1, &z3)
+	t1.add(&t2, &t2)
+	t2.add(&t1, &t2)
+	t0.sub(&t0, &t2)
+	y3.mul(&t0, &y3)
+	y3.add(&x3, &y3)
+	t1.mul(&p.x, &p.y)
+	x3.mul(&t0, &t1)
+	x3.add(&x3, &x3)
+
+	r.x = x3
+	r.y = y3
+	r.z = z3
+	return r
+}
+
+// toAffine returns x, y of the point, infinity is 1 for identity
+func (p *projectivePoint) toAffine() (x, y fieldVal, infinity uint64) {
+	var zInv fieldVal
+	zInv.inverse(&p.z)
+	x.mul(&p.x, &zInv)
+	y.mul(&p.y, &zInv)
+	return x, y, p.z.isZero()
+}
+
+/*
+scalarMulConstTime k * (x, y) with fixed 4 bits windows:
+1. precompute table[i] = i * P for i in [0, 15]
+2. walk the 64 windows of k from the most significant one, for each window
+double the result 4 times and add table[window]
+
+the table entry is picked by scanning the whole table and conditional moving
+the wanted one, so neither the branches nor the memory access pattern depend on
+k, window with value 0 adds identity which costs the same as any other add.
+*/
+func scalarMulConstTime(x, y *fieldVal, k *scalarVal) projectivePoint {
+	var table [16]projectivePoint
+	table[0].setIdentity()
+	table[1].setAffine(x, y)
+	for i := 2; i < 16; i++ {
+		table[i].add(&table[i-1], &table[1])
+	}
+
+	var result projectivePoint
+	result.setIdentity()
+	for w := 63; w >= 0; w-- {
+		for i := 0; i < 4; i++ {
+			result.double(&result)
+		}
+
+		window := k.nibble(w)
+		var selected projectivePoint
+		selected.setIdentity()
+		for i := 0; i < 16; i++ {
+			selected.cmov(&table[i], constTimeEq(uint64(i), window))
+		}
+		result.add(&result, &selected)
+	}
+
+	return result
+}
+
+// constTimeEq returns 1 if a == b, otherwise 0
+func constTimeEq(a, b uint64) uint64 {
+	d := a ^ b
+	return 1 ^ ((d | -d) >> 63)
+}
+
+/*
+s256ScalarMul is the constant time backend behind Point.ScalarMul for points on
+secp256k1, p must not be identity
+*/
+func s256ScalarMul(p *Point, scalar *big.Int) *Point {
+	var x, y fieldVal
+	x.setBig(p.x.num)
+	y.setBig(p.y.num)
+	var k scalarVal
+	k.setBig(scalar)
+
+	result := scalarMulConstTime(&x, &y, &k)
+	rx, ry, infinity := result.toAffine()
+	if infinity == 1 {
+		return S256Point(nil, nil)
+	}
+	return S256Point(rx.toBig(), ry.toBig())
+}
+
+// s256ScalarBaseMul constant time k * G with the precomputed comb table
+func s256ScalarBaseMul(scalar *big.Int) *Point {
+	var k scalarVal
+	k.setBig(scalar)
+
+	result := scalarBaseMulConstTime(&k)
+	rx, ry, infinity := result.toAffine()
+	if infinity == 1 {
+		return S256Point(nil, nil)
+	}
+	return S256Point(rx.toBig(), ry.toBig())
+}
@@ -0,0 +1,174 @@
+package elliptic_curve
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+/*
+scalarVal is an integer modulo the group order n, it holds private keys, nonces
+and every value derived from them when signing. Like fieldVal it is four 64 bits
+limbs with constant time operations.
+
+n = 2^256 - c with c = 0x14551231950b75fc4402da1732fc9bebf (129 bits), a 512 bits
+product hi * 2^256 + lo folds into lo + hi * c, doing it four times brings any
+512 bits value below 2^256.
+*/
+type scalarVal [4]uint64
+
+var groupOrder = scalarVal{0xbfd25e8cd0364141, 0xbaaedce6af48a03b, 0xfffffffffffffffe, 0xffffffffffffffff}
+var groupOrderC = [3]uint64{0x402da1732fc9bebf, 0x4551231950b75fc4, 0x1}
+var groupOrderMinusTwo = [4]uint64{0xbfd25e8cd036413f, 0xbaaedce6af48a03b, 0xfffffffffffffffe, 0xffffffffffffffff}
+
+// setBytes sets s from 32 bytes big endian value, the value is reduced by n
+func (s *scalarVal) setBytes(b []byte) *scalarVal {
+	for i := 0; i < 4; i++ {
+		var limb uint64
+		for j := 0; j < 8; j++ {
+			limb = limb<<8 | uint64(b[(3-i)*8+j])
+		}
+		s[i] = limb
+	}
+	s.reduceOnce(0)
+	return s
+}
+
+func (s *scalarVal) setBig(v *big.Int) *scalarVal {
+	var opMod big.Int
+	return s.setBytes(intTo32Bytes(opMod.Mod(v, GetBitcoinValueN())))
+}
+
+func (s *scalarVal) bytes() []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[(3-i)*8+j] = byte(s[i] >> (56 - 8*j))
+		}
+	}
+	return b
+}
+
+func (s *scalarVal) toBig() *big.Int {
+	return new(big.Int).SetBytes(s.bytes())
+}
+
+func (s *scalarVal) isZero() uint64 {
+	acc := s[0] | s[1] | s[2] | s[3]
+	return 1 ^ ((acc | -acc) >> 63)
+}
+
+// nibble returns the i-th 4 bits window, window 0 is the least significant one
+func (s *scalarVal) nibble(i int) uint64 {
+	return (s[i/16] >> (uint(i%16) * 4)) & 0xf
+}
+
+func (s *scalarVal) cmov(a *scalarVal, flag uint64) {
+	mask := -flag
+	for i := 0; i < 4; i++ {
+		s[i] = (s[i] &^ mask) | (a[i] & mask)
+	}
+}
+
+// reduceOnce the value carry * 2^256 + s is in [0, 2n), subtract n if the value >= n
+func (s *scalarVal) reduceOnce(carry uint64) {
+	var t scalarVal
+	var borrow uint64
+	t[0], borrow = bits.Sub64(s[0], groupOrder[0], 0)
+	t[1], borrow = bits.Sub64(s[1], groupOrder[1], borrow)
+	t[2], borrow = bits.Sub64(s[2], groupOrder[2], borrow)
+	t[3], borrow = bits.Sub64(s[3], groupOrder[3], borrow)
+	_, borrow = bits.Sub64(carry, 0, borrow)
+	s.cmov(&t, borrow^1)
+}
+
+func (s *scalarVal) add(a, b *scalarVal) *scalarVal {
+	var carry uint64
+	s[0], carry = bits.Add64(a[0], b[0], 0)
+	s[1], carry = bits.Add64(a[1], b[1], carry)
+	s[2], carry = bits.Add64(a[2], b[2], carry)
+	s[3], carry = bits.Add64(a[3], b[3], carry)
+	s.reduceOnce(carry)
+	return s
+}
+
+func (s *scalarVal) negate(a *scalarVal) *scalarVal {
+	var borrow uint64
+	var t scalarVal
+	t[0], borrow = bits.Sub64(groupOrder[0], a[0], 0)
+	t[1], borrow = bits.Sub64(groupOrder[1], a[1], borrow)
+	t[2], borrow = bits.Sub64(groupOrder[2], a[2], borrow)
+	t[3], _ = bits.Sub64(groupOrder[3], a[3], borrow)
+	//-0 is 0 not n
+	var zero scalarVal
+	t.cmov(&zero, a.isZero())
+	*s = t
+	return s
+}
+
+/*
+foldWide
+t = hi * 2^256 + lo => lo + hi * c, the result still takes 8 limbs but
+its value shrinks by about 127 bits every time
+*/
+func foldWide(t *[8]uint64) {
+	var r [8]uint64
+	copy(r[:4], t[:4])
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 3; j++ {
+			hi, lo := bits.Mul64(t[4+i], groupOrderC[j])
+			var c uint64
+			lo, c = bits.Add64(lo, r[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			r[i+j] = lo
+			carry = hi
+		}
+		for k := i + 3; k < 8; k++ {
+			r[k], carry = bits.Add64(r[k], carry, 0)
+		}
+	}
+	*t = r
+}
+
+func (s *scalarVal) mul(a, b *scalarVal) *scalarVal {
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var c uint64
+			lo, c = bits.Add64(lo, t[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[i+j] = lo
+			carry = hi
+		}
+		t[i+4] = carry
+	}
+
+	for i := 0; i < 4; i++ {
+		foldWide(&t)
+	}
+
+	copy(s[:], t[:4])
+	s.reduceOnce(0)
+	return s
+}
+
+// inverse a^(n-2), the exponent is public so the branches on its bits are fine
+func (s *scalarVal) inverse(a *scalarVal) *scalarVal {
+	base := *a
+	var result scalarVal
+	result[0] = 1
+	for i := 255; i >= 0; i-- {
+		result.mul(&result, &result)
+		if (groupOrderMinusTwo[i/64]>>(uint(i)%64))&1 == 1 {
+			result.mul(&result, &base)
+		}
+	}
+	*s = result
+	return s
+}
@@ -0,0 +1,208 @@
+package elliptic_curve
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+BIP 340 Schnorr signatures
+
+public keys are x-only: only the 32 bytes x coordinate is kept, out of the two
+points with this x we always pick the one with even y. A private key whose
+public point has odd y is negated before signing, so the point matches the
+x-only key again.
+
+signing message m with key d and aux random data a:
+1. t = bytes(d) xor tagged_hash("BIP0340/aux", a)
+2. k = tagged_hash("BIP0340/nonce", t || x(P) || m) mod n, R = k * G,
+negate k if R has odd y
+3. e = tagged_hash("BIP0340/challenge", x(R) || x(P) || m) mod n
+4. signature is x(R) || (k + e * d) mod n, 64 bytes
+
+verifying: R = s * G - e * P must not be identity, must have even y and x(R) = r
+*/
+
+var (
+	ErrSchnorrSigLength = errors.New("schnorr signature should be 64 bytes")
+	ErrSchnorrSigR      = errors.New("schnorr signature r is not less than field size")
+	ErrSchnorrSigS      = errors.New("schnorr signature s is not less than curve order")
+	ErrXOnlyLength      = errors.New("x-only public key should be 32 bytes")
+	ErrXOnlyNotOnCurve  = errors.New("x-only public key is not an x coordinate on the curve")
+)
+
+type SchnorrSignature struct {
+	r *big.Int
+	s *big.Int
+}
+
+func (s *SchnorrSignature) String() string {
+	return fmt.Sprintf("SchnorrSignature(r: {%x}, s: {%x})", s.r, s.s)
+}
+
+// Serialize r || s, 32 bytes each
+func (s *SchnorrSignature) Serialize() []byte {
+	return append(intTo32Bytes(s.r), intTo32Bytes(s.s)...)
+}
+
+func ParseSchnorrSignature(sigBin []byte) (*SchnorrSignature, error) {
+	if len(sigBin) != 64 {
+		return nil, ErrSchnorrSigLength
+	}
+	r := new(big.Int).SetBytes(sigBin[:32])
+	if r.Cmp(S256Prime()) >= 0 {
+		return nil, ErrSchnorrSigR
+	}
+	s := new(big.Int).SetBytes(sigBin[32:])
+	if s.Cmp(GetBitcoinValueN()) >= 0 {
+		return nil, ErrSchnorrSigS
+	}
+	return &SchnorrSignature{r: r, s: s}, nil
+}
+
+/*
+TaggedHash
+sha256(sha256(tag) || sha256(tag) || msg), the tag makes hashes used for one
+purpose never collide with hashes used for another one
+*/
+func TaggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hasher := sha256.New()
+	hasher.Write(tagHash[:])
+	hasher.Write(tagHash[:])
+	for _, msg := range msgs {
+		hasher.Write(msg)
+	}
+	return hasher.Sum(nil)
+}
+
+// XOnly the 32 bytes x coordinate of the point, the BIP 340 public key
+func (p *Point) XOnly() []byte {
+	return intTo32Bytes(p.x.num)
+}
+
+// hasEvenY the point must not be identity
+func (p *Point) hasEvenY() bool {
+	return p.y.num.Bit(0) == 0
+}
+
+/*
+ParseXOnly
+lift_x of BIP 340, returns the point with the given x coordinate and even y
+*/
+func ParseXOnly(xBin []byte) (*Point, error) {
+	if len(xBin) != 32 {
+		return nil, ErrXOnlyLength
+	}
+	x := new(big.Int).SetBytes(xBin)
+	point, ok := liftX(x)
+	if !ok {
+		return nil, ErrXOnlyNotOnCurve
+	}
+	return S256Point(x, point.y.toBig()), nil
+}
+
+// liftX the curve point with x coordinate x and even y, ok is false if there is none
+func liftX(x *big.Int) (affinePoint, bool) {
+	var point affinePoint
+	if x.Cmp(S256Prime()) >= 0 {
+		return point, false
+	}
+
+	//y^2 = x^3 + 7
+	var c, yy fieldVal
+	point.x.setBig(x)
+	c.square(&point.x)
+	c.mul(&c, &point.x)
+	c.add(&c, new(fieldVal).setInt(7))
+	point.y.sqrt(&c)
+	if yy.square(&point.y).equal(&c) != 1 {
+		return point, false
+	}
+	if point.y.isOdd() == 1 {
+		point.y.negate(&point.y)
+	}
+	return point, true
+}
+
+/*
+SignSchnorr
+sign msg with BIP 340, auxRand must be 32 bytes, fresh random data is
+recommended but 32 zero bytes still gives a valid signature
+*/
+func (p *PrivateKey) SignSchnorr(msg []byte, auxRand []byte) *SchnorrSignature {
+	if len(auxRand) != 32 {
+		panic("aux random data should be 32 bytes")
+	}
+	if p.secret.Sign() <= 0 || p.secret.Cmp(GetBitcoinValueN()) >= 0 {
+		panic("private key is out of range for schnorr signature")
+	}
+
+	//d = secret if P has even y, otherwise n - secret
+	var d, dNeg scalarVal
+	d.setBig(p.secret)
+	dNeg.negate(&d)
+	if !p.point.hasEvenY() {
+		d = dNeg
+	}
+	pubKey := p.point.XOnly()
+
+	t := d.bytes()
+	auxHash := TaggedHash("BIP0340/aux", auxRand)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+
+	var k, kNeg scalarVal
+	k.setBytes(TaggedHash("BIP0340/nonce", t, pubKey, msg))
+	if k.isZero() == 1 {
+		panic("schnorr nonce is zero")
+	}
+	R := GetGenerator().ScalarMul(k.toBig())
+	//R is published in the signature, branching on its y is fine
+	if !R.hasEvenY() {
+		kNeg.negate(&k)
+		k = kNeg
+	}
+	rBin := R.XOnly()
+
+	var e, s scalarVal
+	e.setBytes(TaggedHash("BIP0340/challenge", rBin, pubKey, msg))
+	//s = k + e * d
+	s.mul(&e, &d)
+	s.add(&k, &s)
+
+	return &SchnorrSignature{
+		r: new(big.Int).SetBytes(rBin),
+		s: s.toBig(),
+	}
+}
+
+/*
+VerifySchnorr
+p is the x-only public key (even y, see ParseXOnly), R = s * G - e * P is
+computed with the same Strauss-Shamir code as ECDSA verification
+*/
+func (p *Point) VerifySchnorr(msg []byte, sig *SchnorrSignature) bool {
+	if p.x == nil || !p.hasEvenY() {
+		return false
+	}
+
+	n := GetBitcoinValueN()
+	eBin := TaggedHash("BIP0340/challenge", intTo32Bytes(sig.r), p.XOnly(), msg)
+	e := new(big.Int).SetBytes(eBin)
+	//-e mod n
+	minusE := new(big.Int).Mod(e, n)
+	minusE.Sub(n, minusE)
+
+	total := s256VerifySum(sig.s, minusE, p)
+	R, ok := total.toAffine()
+	if !ok || R.y.isOdd() == 1 {
+		return false
+	}
+	var rField fieldVal
+	rField.setBig(sig.r)
+	return R.x.equal(&rField) == 1
+}
@@ -1,7 +1,19 @@
 package elliptic_curve
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+)
+
+var (
+	ErrDERLength    = errors.New("DER signature should be 8 to 72 bytes")
+	ErrDERMarker    = errors.New("DER signature should start with 0x30 and mark r and s with 0x02")
+	ErrDERSize      = errors.New("DER signature lengths do not add up")
+	ErrDERNegative  = errors.New("DER integer r or s is empty or negative")
+	ErrDERPadding   = errors.New("DER integer r or s has unnecessary zero padding")
+	ErrDERMalformed = errors.New("malformed DER signature")
+	ErrSigRange     = errors.New("signature r or s is not below the group order")
 )
 
 type Signature struct {
@@ -74,3 +86,160 @@ func (s *Signature) Der() []byte {
 
 	return derBin
 }
+
+/*
+CheckDEREncoding
+the BIP 66 rules for a DER signature without the hash type byte:
+
+	0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S]
+
+every length is one byte and they must add up exactly, R and S are positive
+big endian integers without padding, the only allowed leading 0x00 is the one
+keeping the next byte >= 0x80 from being read as a sign bit. Signatures which
+break these rules were accepted by OpenSSL before block 363725, so they can
+only be found in old blocks.
+*/
+func CheckDEREncoding(der []byte) error {
+	if len(der) < 8 || len(der) > 72 {
+		return ErrDERLength
+	}
+	if der[0] != 0x30 || der[2] != 0x02 {
+		return ErrDERMarker
+	}
+	if int(der[1]) != len(der)-2 {
+		return ErrDERSize
+	}
+	rLen := int(der[3])
+	//the marker and length of s must follow r
+	if 6+rLen > len(der) {
+		return ErrDERSize
+	}
+	sLen := int(der[5+rLen])
+	if rLen+sLen+6 != len(der) {
+		return ErrDERSize
+	}
+	if der[4+rLen] != 0x02 {
+		return ErrDERMarker
+	}
+	if err := checkDERInteger(der[4 : 4+rLen]); err != nil {
+		return err
+	}
+	return checkDERInteger(der[6+rLen:])
+}
+
+func checkDERInteger(integer []byte) error {
+	if len(integer) == 0 || integer[0]&0x80 != 0 {
+		return ErrDERNegative
+	}
+	if len(integer) > 1 && integer[0] == 0x00 && integer[1]&0x80 == 0 {
+		return ErrDERPadding
+	}
+	return nil
+}
+
+// ParseDERSignature parses a signature following the BIP 66 rules, r and s should be below n
+func ParseDERSignature(der []byte) (*Signature, error) {
+	if err := CheckDEREncoding(der); err != nil {
+		return nil, err
+	}
+	rLen := int(der[3])
+	return newSignatureFromBytes(der[4:4+rLen], der[6+rLen:])
+}
+
+/*
+ParseDERSignatureLax
+parses signatures the way OpenSSL did before BIP 66, the same as
+ecdsa_signature_parse_der_lax of Bitcoin Core: lengths may use the long form,
+integers may be padded or negative and anything after s is ignored. Use it for
+blocks before BIP 66 activation, everything newer should pass
+ParseDERSignature.
+*/
+func ParseDERSignatureLax(der []byte) (*Signature, error) {
+	pos := 0
+	if pos == len(der) || der[pos] != 0x30 {
+		return nil, ErrDERMalformed
+	}
+	pos++
+	//the sequence length is skipped, long form included
+	if pos == len(der) {
+		return nil, ErrDERMalformed
+	}
+	lenByte := int(der[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(der)-pos {
+			return nil, ErrDERMalformed
+		}
+		pos += lenByte
+	}
+
+	readInteger := func() ([]byte, error) {
+		if pos == len(der) || der[pos] != 0x02 {
+			return nil, ErrDERMalformed
+		}
+		pos++
+		if pos == len(der) {
+			return nil, ErrDERMalformed
+		}
+		length := int(der[pos])
+		pos++
+		if length&0x80 != 0 {
+			lenBytes := length - 0x80
+			if lenBytes > len(der)-pos {
+				return nil, ErrDERMalformed
+			}
+			for lenBytes > 0 && der[pos] == 0 {
+				pos++
+				lenBytes--
+			}
+			//a length which does not fit in an int can not be right
+			if lenBytes >= 4 {
+				return nil, ErrDERMalformed
+			}
+			length = 0
+			for ; lenBytes > 0; lenBytes-- {
+				length = length<<8 | int(der[pos])
+				pos++
+			}
+		}
+		if length > len(der)-pos {
+			return nil, ErrDERMalformed
+		}
+		integer := der[pos : pos+length]
+		pos += length
+		return integer, nil
+	}
+
+	rBin, err := readInteger()
+	if err != nil {
+		return nil, err
+	}
+	sBin, err := readInteger()
+	if err != nil {
+		return nil, err
+	}
+	return newSignatureFromBytes(rBin, sBin)
+}
+
+// newSignatureFromBytes the sign bit of r and s is ignored like OpenSSL does
+func newSignatureFromBytes(rBin []byte, sBin []byte) (*Signature, error) {
+	n := GetBitcoinValueN()
+	r := new(big.Int).SetBytes(rBin)
+	s := new(big.Int).SetBytes(sBin)
+	if r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return nil, ErrSigRange
+	}
+	return NewSignature(NewFieldElement(n, r), NewFieldElement(n, s)), nil
+}
+
+/*
+IsLowS
+s and n - s are both valid, anyone can flip s of a transaction in flight and
+change its id. BIP 146 makes s > n / 2 non standard, Sign always returns the
+low one.
+*/
+func (s *Signature) IsLowS() bool {
+	halfN := new(big.Int).Rsh(GetBitcoinValueN(), 1)
+	return s.s.num.Cmp(halfN) <= 0
+}
@@ -0,0 +1,162 @@
+package elliptic_curve
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+/*
+Bitcoin signed message (BIP 137)
+
+the owner of an address proves it by signing a text with the private key of
+the address, the message is never a transaction so it is prefixed first:
+
+	z = hash256(0x18 "Bitcoin Signed Message:\n" || varint(len(message)) || message)
+
+the signature is the 65 bytes compact signature in base64, the verifier
+recovers the public key from it (see recovery.go), rebuilds the address and
+compares it with the claimed one. The header byte tells which address to
+rebuild:
+
+	27-30 P2PKH with uncompressed public key
+	31-34 P2PKH with compressed public key
+	35-38 P2SH-P2WPKH
+	39-42 P2WPKH
+
+many wallets (Electrum, Trezor) sign for segwit addresses with header 31-34,
+so a compressed P2PKH header is also accepted for the two segwit addresses.
+*/
+
+const signedMessagePrefix = "\x18Bitcoin Signed Message:\n"
+
+type MessageAddressType int
+
+const (
+	MessageP2PKHUncompressed MessageAddressType = iota
+	MessageP2PKH
+	MessageP2SHP2WPKH
+	MessageP2WPKH
+)
+
+var (
+	ErrMessageSigEncoding = errors.New("signed message signature is not valid base64")
+	ErrMessageSigHeader   = errors.New("signed message signature header should be in [27, 42]")
+)
+
+// messageVarint varint encoding of the length, the same as EncodeVariant of transaction
+func messageVarint(v uint64) []byte {
+	switch {
+	case v < 0xfd:
+		return []byte{byte(v)}
+	case v <= 0xffff:
+		b := []byte{0xfd, 0, 0}
+		binary.LittleEndian.PutUint16(b[1:], uint16(v))
+		return b
+	case v <= 0xffffffff:
+		b := []byte{0xfe, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(b[1:], uint32(v))
+		return b
+	default:
+		b := []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(b[1:], v)
+		return b
+	}
+}
+
+// MessageHash the z signed for a Bitcoin signed message
+func MessageHash(message string) *big.Int {
+	data := []byte(signedMessagePrefix)
+	data = append(data, messageVarint(uint64(len(message)))...)
+	data = append(data, message...)
+	return new(big.Int).SetBytes(Hash256(string(data)))
+}
+
+/*
+SignMessage
+returns the base64 signature of message, addressType is the kind of address of
+this key the signature will be verified against
+*/
+func (p *PrivateKey) SignMessage(message string, addressType MessageAddressType) string {
+	compact := p.SignCompact(MessageHash(message), addressType != MessageP2PKHUncompressed)
+	sigBin := compact.Serialize()
+	sigBin[0] = compactSigHeaderBase + 4*byte(addressType) + compact.RecoveryID()
+	return base64.StdEncoding.EncodeToString(sigBin)
+}
+
+/*
+VerifyMessage
+checks signature is made for message by the owner of address, address may be
+P2PKH, P2SH-P2WPKH or P2WPKH of any network in AllChainParams. An error is only returned
+when the signature can not be decoded or its r or s is out of range, a well formed signature of someone else
+gives false
+*/
+func VerifyMessage(address string, signature string, message string) (bool, error) {
+	sigBin, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, ErrMessageSigEncoding
+	}
+	if len(sigBin) != 65 {
+		return false, ErrCompactSigLength
+	}
+	header := sigBin[0]
+	if header < compactSigHeaderBase || header > compactSigHeaderBase+15 {
+		return false, ErrMessageSigHeader
+	}
+	addressType := MessageAddressType((header - compactSigHeaderBase) / 4)
+
+	//the segwit headers are out of the compact range, give the parser the plain one
+	compactBin := append([]byte{}, sigBin...)
+	compactBin[0] = compactSigHeaderBase + (header-compactSigHeaderBase)&3
+	if addressType != MessageP2PKHUncompressed {
+		compactBin[0] += 4
+	}
+	compact, err := ParseCompactSignature(compactBin)
+	if err != nil {
+		return false, err
+	}
+	pubKey, err := RecoverPublicKey(MessageHash(message), compact)
+	if err != nil {
+		return false, nil
+	}
+
+	candidates := []MessageAddressType{addressType}
+	if addressType == MessageP2PKH {
+		candidates = append(candidates, MessageP2SHP2WPKH, MessageP2WPKH)
+	}
+	for _, candidate := range candidates {
+		for _, params := range AllChainParams() {
+			if messageAddress(pubKey, candidate, params) == normalizeAddress(address) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func messageAddress(pubKey *Point, addressType MessageAddressType, params *ChainParams) string {
+	switch addressType {
+	case MessageP2PKHUncompressed:
+		return pubKey.Address(false, params)
+	case MessageP2PKH:
+		return pubKey.Address(true, params)
+	case MessageP2SHP2WPKH:
+		return pubKey.P2shP2wpkhAddress(params)
+	case MessageP2WPKH:
+		return pubKey.P2wpkhAddress(params)
+	}
+	panic(fmt.Sprintf("unknown message address type %d", addressType))
+}
+
+// normalizeAddress bech32 addresses may be all upper case, base58 ones are case sensitive
+func normalizeAddress(address string) string {
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, MainnetHrp+"1") || strings.HasPrefix(lower, TestnetHrp+"1") ||
+		strings.HasPrefix(lower, RegtestHrp+"1") {
+		return lower
+	}
+	return address
+}
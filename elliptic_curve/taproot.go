@@ -0,0 +1,65 @@
+package elliptic_curve
+
+import (
+	"math/big"
+)
+
+/*
+Taproot key tweak (BIP 341)
+
+a taproot output does not pay to the internal key P of the owner but to
+
+	Q = P + t * G, t = tagged_hash("TapTweak", x(P) || merkle root)
+
+where P is taken with even y and the merkle root commits to the script tree,
+it is empty for a key only output. Spending by key path is a BIP 340 signature
+for Q, the owner signs with the tweaked secret d + t.
+*/
+
+func taprootTweak(internalKey *Point, merkleRoot []byte) *big.Int {
+	t := new(big.Int).SetBytes(TaggedHash("TapTweak", internalKey.XOnly(), merkleRoot))
+	if t.Cmp(GetBitcoinValueN()) >= 0 {
+		panic("taproot tweak is not less than curve order")
+	}
+	return t
+}
+
+/*
+TaprootOutputKey
+the output key Q for internalKey, merkleRoot is nil for a key path only output,
+the result is an x-only key (even y) ready for the witness program
+*/
+func TaprootOutputKey(internalKey *Point, merkleRoot []byte) *Point {
+	P, err := ParseXOnly(internalKey.XOnly())
+	if err != nil {
+		panic(err)
+	}
+	//Q = t * G + 1 * P, all public
+	total := s256VerifySum(taprootTweak(P, merkleRoot), big.NewInt(1), P)
+	Q, ok := total.toAffine()
+	if !ok {
+		panic("taproot output key is identity")
+	}
+	//the x-only key stands for the point with even y
+	if Q.y.isOdd() == 1 {
+		Q.y.negate(&Q.y)
+	}
+	return S256Point(Q.x.toBig(), Q.y.toBig())
+}
+
+/*
+TaprootTweak
+the private key for the output key of TaprootOutputKey, the secret is negated
+first when the internal key has odd y
+*/
+func (p *PrivateKey) TaprootTweak(merkleRoot []byte) *PrivateKey {
+	var d, dNeg, t scalarVal
+	d.setBig(p.secret)
+	dNeg.negate(&d)
+	if !p.point.hasEvenY() {
+		d = dNeg
+	}
+	t.setBig(taprootTweak(p.point, merkleRoot))
+	d.add(&d, &t)
+	return NewPrivateKey(d.toBig())
+}
@@ -1,9 +1,10 @@
 package elliptic_curve
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"math/big"
 
 	"strings"
@@ -30,69 +31,112 @@ func GetGenerator() *Point {
 	return G
 }
 
+// S256Prime the order of the field for secp256k1, 2^256 - 2^32 - 977
+func S256Prime() *big.Int {
+	p := new(big.Int)
+	p.SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	return p
+}
+
 func GetBitcoinValueN() *big.Int {
 	n := new(big.Int)
 	n.SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
 	return n
 }
 
-func ParseSEC(secBin []byte) *Point {
-	//check the first byte to decide it is compressed or uncompressed
-	if secBin[0] == 4 {
-		//uncompressed
-		x := new(big.Int)
-		x.SetBytes(secBin[1:33])
-		y := new(big.Int)
-		y.SetBytes(secBin[33:65])
-		return S256Point(x, y)
-	}
+var (
+	ErrSECFormat     = errors.New("sec should be 33 bytes with prefix 02/03 or 65 bytes with prefix 04")
+	ErrSECNotOnCurve = errors.New("sec point is not on the curve")
+)
 
-	//check first byte for y is odd or even
-	isEven := secBin[0] == 2
-	x := new(big.Int)
-	x.SetBytes(secBin[1:])
-	y2 := S256Field(x).Pow(big.NewInt(int64(3))).Add(S256Field(big.NewInt(int64(7))))
-	y := y2.Sqrt()
-	var modOp big.Int
-	var yEven *FieldElement
-	var yOdd *FieldElement
-	if modOp.Mod(y.num, big.NewInt(int64(2))).Cmp(big.NewInt(int64(0))) == 0 {
-		yEven = y
-		yOdd = y.Negate() //p - y
-	} else {
-		yOdd = y
-		yEven = y.Negate()
+/*
+ParseSEC
+parses a compressed (02/03 + x) or uncompressed (04 + x + y) public key, the
+point must be on the curve
+*/
+func ParseSEC(secBin []byte) (*Point, error) {
+	switch {
+	case len(secBin) == 65 && secBin[0] == 0x04:
+		x := new(big.Int).SetBytes(secBin[1:33])
+		y := new(big.Int).SetBytes(secBin[33:])
+		lifted, ok := liftX(x)
+		if !ok || y.Cmp(S256Prime()) >= 0 {
+			return nil, ErrSECNotOnCurve
+		}
+		//y is lifted.y or p - lifted.y
+		var negY fieldVal
+		negY.negate(&lifted.y)
+		if y.Cmp(lifted.y.toBig()) != 0 && y.Cmp(negY.toBig()) != 0 {
+			return nil, ErrSECNotOnCurve
+		}
+		return S256Point(x, y), nil
+	case len(secBin) == 33 && (secBin[0] == 0x02 || secBin[0] == 0x03):
+		x := new(big.Int).SetBytes(secBin[1:])
+		lifted, ok := liftX(x)
+		if !ok {
+			return nil, ErrSECNotOnCurve
+		}
+		if secBin[0] == 0x03 {
+			lifted.y.negate(&lifted.y)
+		}
+		return S256Point(x, lifted.y.toBig()), nil
 	}
+	return nil, ErrSECFormat
+}
 
-	if isEven {
-		return S256Point(x, yEven.num)
-	} else {
-		return S256Point(x, yOdd.num)
+/*
+DecodeBase58
+the payload of a base58 check string without its first byte (network prefix)
+and checksum
+*/
+func DecodeBase58(s string) ([]byte, error) {
+	payload, err := DecodeBase58Check(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, ErrBase58Length
 	}
+	return payload[1:], nil
 }
 
-func DecodeBase58(s string) []byte {
+var (
+	ErrBase58Char     = errors.New("invalid char in base58 string")
+	ErrBase58Checksum = errors.New("base58 checksum mismatch")
+	ErrBase58Length   = errors.New("base58 payload is too short")
+)
+
+/*
+DecodeBase58Check
+the payload of a base58 string with its 4 bytes checksum checked and removed,
+unlike DecodeBase58 the version byte is kept and every leading '1' gives back
+its leading zero byte
+*/
+func DecodeBase58Check(s string) ([]byte, error) {
 	Base58Alphabet := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
-	num := big.NewInt(int64(0))
-	for _, char := range s {
-		mulOp := new(big.Int)
-		num = mulOp.Mul(num, big.NewInt(int64(58)))
-		idx := strings.Index(Base58Alphabet, string(char))
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros += 1
+	}
+	num := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		idx := strings.IndexByte(Base58Alphabet, s[i])
 		if idx == -1 {
-			panic("can't find char in base58 alphabet")
+			return nil, ErrBase58Char
 		}
-		addOp := new(big.Int)
-		num = addOp.Add(num, big.NewInt(int64(idx)))
+		num.Mul(num, big.NewInt(int64(58)))
+		num.Add(num, big.NewInt(int64(idx)))
 	}
-	combined := num.Bytes()
-	checksum := combined[len(combined)-4:]
-	h256 := Hash256(string(combined[0 : len(combined)-4]))
-	if bytes.Equal(h256[0:4], checksum) != true {
-		panic("decode base58 checksum error")
+	combined := append(make([]byte, zeros), num.Bytes()...)
+	if len(combined) < 4 {
+		return nil, ErrBase58Checksum
 	}
-
-	//first byte is network prefix
-	return combined[1 : len(combined)-4]
+	payload := combined[:len(combined)-4]
+	h256 := Hash256(string(payload))
+	if !bytes.Equal(h256[:4], combined[len(combined)-4:]) {
+		return nil, ErrBase58Checksum
+	}
+	return payload, nil
 }
 
 /*
@@ -142,56 +186,16 @@ func Hash160(s []byte) []byte {
 	return hashBytes
 }
 
-func ParseSigBin(sigBin []byte) *Signature {
-	reader := bytes.NewReader(sigBin)
-	bufReader := bufio.NewReader(reader)
-	//the first byte should be 0x30
-	firstByte := make([]byte, 1)
-	bufReader.Read(firstByte)
-	if firstByte[0] != 0x30 {
-		panic("Bad Signature, first byte is not 0x30")
-	}
-	//the second byte is the length of r and s
-	lenBuf := make([]byte, 1)
-	bufReader.Read(lenBuf)
-	//the first two bytes with the length of r and s should be the total length of sigBin
-	if lenBuf[0]+2 != byte(len(sigBin)) {
-		panic("Bad Signature length")
-	}
+/*
+ParseSigBin
+parses a DER signature like ParseDERSignatureLax and panics on malformed input
 
-	//marker 0x02 as the beginning of r
-	marker := make([]byte, 1)
-	bufReader.Read(marker)
-	if marker[0] != 0x02 {
-		panic("signature marker for r is not 0x02")
-	}
-	//following is the length of r bin
-	lenBuf = make([]byte, 1)
-	bufReader.Read(lenBuf)
-	rLength := lenBuf[0]
-	rBin := make([]byte, rLength)
-	//it may have 0x00 append at the head, but it does not affect the value of r
-	bufReader.Read(rBin)
-	r := new(big.Int)
-	r.SetBytes(rBin)
-	//marketer 0x02 for the beginning of s
-	marker = make([]byte, 1)
-	bufReader.Read(marker)
-	if marker[0] != 0x02 {
-		panic("signature marker for s is not 0x02")
-	}
-	//following is the length of s bin
-	lenBuf = make([]byte, 1)
-	bufReader.Read(lenBuf)
-	sLength := lenBuf[0]
-	sBin := make([]byte, sLength)
-	bufReader.Read(sBin)
-	s := new(big.Int)
-	s.SetBytes(sBin)
-	if len(sigBin) != int(6+rLength+sLength) {
-		panic("signature wrong length")
+Deprecated: use ParseDERSignature or ParseDERSignatureLax
+*/
+func ParseSigBin(sigBin []byte) *Signature {
+	sig, err := ParseDERSignatureLax(sigBin)
+	if err != nil {
+		panic(fmt.Sprintf("parse signature err: %v\n", err))
 	}
-
-	n := GetBitcoinValueN()
-	return NewSignature(NewFieldElement(n, r), NewFieldElement(n, s))
+	return sig
 }
@@ -5,6 +5,7 @@ go 1.24.0
 require (
 	github.com/tsuna/endian v0.0.0-20151020052604-29b3a4178852
 	golang.org/x/crypto v0.36.0
+	golang.org/x/text v0.23.0
 )
 
 require (
@@ -0,0 +1,369 @@
+package hdwallet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+/*
+Hierarchical deterministic wallet (BIP 32)
+
+instead of backing up every private key, the wallet derives all of them from
+one seed. The seed gives the master key:
+
+	I = hmac_sha512(key: "Bitcoin seed", data: seed)
+	master secret = first 32 bytes of I, chain code = last 32 bytes of I
+
+an extended key is a key together with its chain code, the chain code is the
+extra entropy that keeps the children unpredictable from the key alone. Child
+i of an extended key is
+
+	I = hmac_sha512(key: chain code, data: sec(K) || i)            i < 2^31
+	I = hmac_sha512(key: chain code, data: 0x00 || secret || i)    i >= 2^31
+	child secret = first 32 bytes of I + secret (mod n)
+	child public key = first 32 bytes of I * G + K
+	child chain code = last 32 bytes of I
+
+indexes from 2^31 are hardened, they need the secret so an extended public key
+can derive the normal children only, and a leaked child secret together with
+the parent extended public key does not reveal the parent secret.
+
+the 78 bytes serialization is
+
+	4 bytes version (xprv, xpub, tprv, tpub)
+	1 byte depth, 0 for master
+	4 bytes fingerprint of parent, first 4 bytes of hash160 of its sec
+	4 bytes child index, big endian
+	32 bytes chain code
+	33 bytes key, 0x00 || secret or compressed sec
+
+encoded by Base58Checksum.
+*/
+
+const (
+	HardenedKeyStart = uint32(0x80000000)
+	//length of the serialized extended key without checksum
+	serializedKeyLen = 78
+	minSeedLen       = 16
+	maxSeedLen       = 64
+)
+
+var masterHmacKey = []byte("Bitcoin seed")
+
+var (
+	ErrInvalidSeedLen           = errors.New("seed should be 16 to 64 bytes")
+	ErrUnusableSeed             = errors.New("seed gives an invalid master key, use another seed")
+	ErrInvalidChild             = errors.New("derived child key is invalid, use the next index")
+	ErrDeriveHardenedFromPublic = errors.New("can not derive a hardened child from an extended public key")
+	ErrDepthTooLarge            = errors.New("can not derive beyond depth 255")
+	ErrNotPrivate               = errors.New("extended key is not private")
+	ErrInvalidKeyLen            = errors.New("serialized extended key should be 78 bytes")
+	ErrUnknownVersion           = errors.New("unknown extended key version")
+	ErrInvalidPrivateKey        = errors.New("invalid private key in extended key")
+	ErrInvalidPublicKey         = errors.New("invalid public key in extended key")
+	ErrInvalidMasterKey         = errors.New("master key should have zero parent fingerprint and child index")
+	ErrInvalidPath              = errors.New("invalid derivation path")
+)
+
+type ExtendedKey struct {
+	//nil for an extended public key
+	privateKey  *ecc.PrivateKey
+	publicKey   *ecc.Point
+	chainCode   []byte
+	depth       uint8
+	parentFP    []byte
+	childNumber uint32
+	//selects xprv/xpub or tprv/tpub, nil is main-net
+	params *ecc.ChainParams
+}
+
+func hmacSHA512(key []byte, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// validSecret 0 < secret < n
+func validSecret(secret *big.Int) bool {
+	return secret.Sign() > 0 && secret.Cmp(ecc.GetBitcoinValueN()) < 0
+}
+
+// NewMasterKey the master extended private key of the given seed
+func NewMasterKey(seed []byte, params *ecc.ChainParams) (*ExtendedKey, error) {
+	if len(seed) < minSeedLen || len(seed) > maxSeedLen {
+		return nil, ErrInvalidSeedLen
+	}
+	I := hmacSHA512(masterHmacKey, seed)
+	secret := new(big.Int).SetBytes(I[:32])
+	if !validSecret(secret) {
+		return nil, ErrUnusableSeed
+	}
+	privateKey := ecc.NewPrivateKey(secret)
+	return &ExtendedKey{
+		privateKey: privateKey,
+		publicKey:  privateKey.GetPublicKey(),
+		chainCode:  I[32:],
+		parentFP:   make([]byte, 4),
+		params:     params,
+	}, nil
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.privateKey != nil
+}
+
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+// ChildNumber the index this key is derived with, hardened ones include HardenedKeyStart
+func (k *ExtendedKey) ChildNumber() uint32 {
+	return k.childNumber
+}
+
+func (k *ExtendedKey) ChainCode() []byte {
+	return append([]byte{}, k.chainCode...)
+}
+
+func (k *ExtendedKey) ParentFingerprint() []byte {
+	return append([]byte{}, k.parentFP...)
+}
+
+// Fingerprint first 4 bytes of hash160 of the compressed sec of this key
+func (k *ExtendedKey) Fingerprint() []byte {
+	_, sec := k.publicKey.Sec(true)
+	return ecc.Hash160(sec)[:4]
+}
+
+func (k *ExtendedKey) PublicKey() *ecc.Point {
+	return k.publicKey
+}
+
+func (k *ExtendedKey) PrivateKey() (*ecc.PrivateKey, error) {
+	if k.privateKey == nil {
+		return nil, ErrNotPrivate
+	}
+	return k.privateKey, nil
+}
+
+// Neuter the extended public key of k, k itself if it is public already
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if k.privateKey == nil {
+		return k
+	}
+	return &ExtendedKey{
+		publicKey:   k.publicKey,
+		chainCode:   k.chainCode,
+		depth:       k.depth,
+		parentFP:    k.parentFP,
+		childNumber: k.childNumber,
+		params:      k.params,
+	}
+}
+
+/*
+Child
+derives child index of k, index from HardenedKeyStart is a hardened child and
+needs k to be private. ErrInvalidChild happens with probability below 2^-127,
+the caller should go on with the next index.
+*/
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, ErrDepthTooLarge
+	}
+	hardened := index >= HardenedKeyStart
+	if hardened && k.privateKey == nil {
+		return nil, ErrDeriveHardenedFromPublic
+	}
+
+	data := make([]byte, 0, 37)
+	if hardened {
+		data = append(data, 0x00)
+		data = append(data, k.privateKey.Bytes()...)
+	} else {
+		_, sec := k.publicKey.Sec(true)
+		data = append(data, sec...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+	I := hmacSHA512(k.chainCode, data)
+	IL := new(big.Int).SetBytes(I[:32])
+	if IL.Cmp(ecc.GetBitcoinValueN()) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	child := &ExtendedKey{
+		chainCode:   I[32:],
+		depth:       k.depth + 1,
+		parentFP:    k.Fingerprint(),
+		childNumber: index,
+		params:      k.params,
+	}
+	if k.privateKey != nil {
+		var opAdd big.Int
+		secret := opAdd.Add(IL, new(big.Int).SetBytes(k.privateKey.Bytes()))
+		secret.Mod(secret, ecc.GetBitcoinValueN())
+		if secret.Sign() == 0 {
+			return nil, ErrInvalidChild
+		}
+		child.privateKey = ecc.NewPrivateKey(secret)
+		child.publicKey = child.privateKey.GetPublicKey()
+		return child, nil
+	}
+
+	child.publicKey = ecc.GetGenerator().ScalarMul(IL).Add(k.publicKey)
+	if child.publicKey.IsIdentity() {
+		return nil, ErrInvalidChild
+	}
+	return child, nil
+}
+
+/*
+ParsePath
+parses a derivation path like m/84'/0'/0'/0/5 into child indexes, hardened
+indexes are marked with ', h or H. The leading m (M for public derivation) is
+optional, "m" alone is the master key itself.
+*/
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] == "m" || parts[0] == "M" {
+		parts = parts[1:]
+	}
+	indexes := make([]uint32, 0, len(parts))
+	for _, part := range parts {
+		hardened := false
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") || strings.HasSuffix(part, "H") {
+			hardened = true
+			part = part[:len(part)-1]
+		}
+		//no sign, no leading + and no empty part
+		if part == "" || part[0] < '0' || part[0] > '9' {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedKeyStart {
+			return nil, fmt.Errorf("%w: index %s out of range in %q", ErrInvalidPath, part, path)
+		}
+		if hardened {
+			index += uint64(HardenedKeyStart)
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// Derive the descendant of k following path, see ParsePath
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	key := k
+	for _, index := range indexes {
+		key, err = key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (k *ExtendedKey) version() []byte {
+	if k.privateKey != nil {
+		return k.params.HDPrivateKeyID()
+	}
+	return k.params.HDPublicKeyID()
+}
+
+/*
+ChainParams
+the network of the key, the test networks share tprv and tpub so a parsed
+one always gives TestNet3Params
+*/
+func (k *ExtendedKey) ChainParams() *ecc.ChainParams {
+	return k.params
+}
+
+// Serialize the 78 bytes of the extended key, without checksum
+func (k *ExtendedKey) Serialize() []byte {
+	result := make([]byte, 0, serializedKeyLen)
+	result = append(result, k.version()...)
+	result = append(result, k.depth)
+	result = append(result, k.parentFP...)
+	result = binary.BigEndian.AppendUint32(result, k.childNumber)
+	result = append(result, k.chainCode...)
+	if k.privateKey != nil {
+		result = append(result, 0x00)
+		result = append(result, k.privateKey.Bytes()...)
+	} else {
+		_, sec := k.publicKey.Sec(true)
+		result = append(result, sec...)
+	}
+	return result
+}
+
+// String the xprv, xpub, tprv or tpub string
+func (k *ExtendedKey) String() string {
+	return ecc.Base58Checksum(k.Serialize())
+}
+
+// ParseExtendedKey parses an xprv, xpub, tprv or tpub string
+func ParseExtendedKey(key string) (*ExtendedKey, error) {
+	payload, err := ecc.DecodeBase58Check(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != serializedKeyLen {
+		return nil, ErrInvalidKeyLen
+	}
+
+	extendedKey := &ExtendedKey{
+		depth:       payload[4],
+		parentFP:    payload[5:9],
+		childNumber: binary.BigEndian.Uint32(payload[9:13]),
+		chainCode:   payload[13:45],
+	}
+	version := payload[:4]
+	private := false
+	for _, params := range []*ecc.ChainParams{ecc.MainNetParams, ecc.TestNet3Params} {
+		switch {
+		case bytes.Equal(version, params.HDPrivateKeyID()):
+			private, extendedKey.params = true, params
+		case bytes.Equal(version, params.HDPublicKeyID()):
+			extendedKey.params = params
+		}
+	}
+	if extendedKey.params == nil {
+		return nil, ErrUnknownVersion
+	}
+	if extendedKey.depth == 0 &&
+		(!bytes.Equal(extendedKey.parentFP, make([]byte, 4)) || extendedKey.childNumber != 0) {
+		return nil, ErrInvalidMasterKey
+	}
+
+	keyData := payload[45:]
+	if private {
+		secret := new(big.Int).SetBytes(keyData[1:])
+		if keyData[0] != 0x00 || !validSecret(secret) {
+			return nil, ErrInvalidPrivateKey
+		}
+		extendedKey.privateKey = ecc.NewPrivateKey(secret)
+		extendedKey.publicKey = extendedKey.privateKey.GetPublicKey()
+		return extendedKey, nil
+	}
+
+	publicKey, err := ecc.ParseSEC(keyData)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	extendedKey.publicKey = publicKey
+	return extendedKey, nil
+}
@@ -0,0 +1,358 @@
+package hdwallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+func TestBIP32Vectors(t *testing.T) {
+	testVec1 := "000102030405060708090a0b0c0d0e0f"
+	testVec2 := "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542"
+	//leading zeros in the private key
+	testVec3 := "4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be"
+
+	tests := []struct {
+		seed     string
+		path     string
+		wantPriv string
+		wantPub  string
+	}{
+		{testVec1, "m",
+			"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+			"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"},
+		{testVec1, "m/0'",
+			"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7",
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"},
+		{testVec1, "m/0'/1",
+			"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs",
+			"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ"},
+		{testVec1, "m/0'/1/2'",
+			"xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM",
+			"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5"},
+		{testVec1, "m/0'/1/2'/2",
+			"xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334",
+			"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV"},
+		{testVec1, "m/0'/1/2'/2/1000000000",
+			"xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76",
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy"},
+		{testVec2, "m",
+			"xprv9s21ZrQH143K31xYSDQpPDxsXRTUcvj2iNHm5NUtrGiGG5e2DtALGdso3pGz6ssrdK4PFmM8NSpSBHNqPqm55Qn3LqFtT2emdEXVYsCzC2U",
+			"xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB"},
+		{testVec2, "m/0",
+			"xprv9vHkqa6EV4sPZHYqZznhT2NPtPCjKuDKGY38FBWLvgaDx45zo9WQRUT3dKYnjwih2yJD9mkrocEZXo1ex8G81dwSM1fwqWpWkeS3v86pgKt",
+			"xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH"},
+		{testVec2, "m/0/2147483647'",
+			"xprv9wSp6B7kry3Vj9m1zSnLvN3xH8RdsPP1Mh7fAaR7aRLcQMKTR2vidYEeEg2mUCTAwCd6vnxVrcjfy2kRgVsFawNzmjuHc2YmYRmagcEPdU9",
+			"xpub6ASAVgeehLbnwdqV6UKMHVzgqAG8Gr6riv3Fxxpj8ksbH9ebxaEyBLZ85ySDhKiLDBrQSARLq1uNRts8RuJiHjaDMBU4Zn9h8LZNnBC5y4a"},
+		{testVec2, "m/0/2147483647'/1",
+			"xprv9zFnWC6h2cLgpmSA46vutJzBcfJ8yaJGg8cX1e5StJh45BBciYTRXSd25UEPVuesF9yog62tGAQtHjXajPPdbRCHuWS6T8XA2ECKADdw4Ef",
+			"xpub6DF8uhdarytz3FWdA8TvFSvvAh8dP3283MY7p2V4SeE2wyWmG5mg5EwVvmdMVCQcoNJxGoWaU9DCWh89LojfZ537wTfunKau47EL2dhHKon"},
+		{testVec2, "m/0/2147483647'/1/2147483646'",
+			"xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc",
+			"xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL"},
+		{testVec2, "m/0/2147483647'/1/2147483646'/2",
+			"xprvA2nrNbFZABcdryreWet9Ea4LvTJcGsqrMzxHx98MMrotbir7yrKCEXw7nadnHM8Dq38EGfSh6dqA9QWTyefMLEcBYJUuekgW4BYPJcr9E7j",
+			"xpub6FnCn6nSzZAw5Tw7cgR9bi15UV96gLZhjDstkXXxvCLsUXBGXPdSnLFbdpq8p9HmGsApME5hQTZ3emM2rnY5agb9rXpVGyy3bdW6EEgAtqt"},
+		{testVec3, "m",
+			"xprv9s21ZrQH143K25QhxbucbDDuQ4naNntJRi4KUfWT7xo4EKsHt2QJDu7KXp1A3u7Bi1j8ph3EGsZ9Xvz9dGuVrtHHs7pXeTzjuxBrCmmhgC6",
+			"xpub661MyMwAqRbcEZVB4dScxMAdx6d4nFc9nvyvH3v4gJL378CSRZiYmhRoP7mBy6gSPSCYk6SzXPTf3ND1cZAceL7SfJ1Z3GC8vBgp2epUt13"},
+		{testVec3, "m/0'",
+			"xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L",
+			"xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y"},
+	}
+
+	for _, test := range tests {
+		seed, _ := hex.DecodeString(test.seed)
+		master, err := NewMasterKey(seed, ecc.MainNetParams)
+		if err != nil {
+			t.Fatalf("master key: %v", err)
+		}
+		key, err := master.Derive(test.path)
+		if err != nil {
+			t.Fatalf("derive %s: %v", test.path, err)
+		}
+		if key.String() != test.wantPriv {
+			t.Fatalf("%s private is %s, want %s", test.path, key, test.wantPriv)
+		}
+		if key.Neuter().String() != test.wantPub {
+			t.Fatalf("%s public is %s, want %s", test.path, key.Neuter(), test.wantPub)
+		}
+
+		for _, serialized := range []string{test.wantPriv, test.wantPub} {
+			parsed, err := ParseExtendedKey(serialized)
+			if err != nil {
+				t.Fatalf("parse %s: %v", serialized, err)
+			}
+			if parsed.String() != serialized {
+				t.Fatalf("parse %s gives %s", serialized, parsed)
+			}
+		}
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, _ := NewMasterKey(seed, ecc.MainNetParams)
+	account, err := master.Derive("m/84'/0'/0'")
+	if err != nil {
+		t.Fatalf("derive account: %v", err)
+	}
+
+	//normal children of an xpub are the public keys of the xprv children
+	private, _ := account.Derive("0/5")
+	public, err := account.Neuter().Derive("M/0/5")
+	if err != nil {
+		t.Fatalf("derive from public: %v", err)
+	}
+	if private.Neuter().String() != public.String() {
+		t.Fatalf("public derivation %s, want %s", public, private.Neuter())
+	}
+	if private.Depth() != 5 || private.ChildNumber() != 5 {
+		t.Fatalf("wrong depth %d or child number %d", private.Depth(), private.ChildNumber())
+	}
+	parent, _ := account.Derive("0")
+	if hex.EncodeToString(private.ParentFingerprint()) != hex.EncodeToString(parent.Fingerprint()) {
+		t.Fatalf("parent fingerprint mismatch")
+	}
+
+	if _, err := account.Neuter().Child(HardenedKeyStart); err != ErrDeriveHardenedFromPublic {
+		t.Fatalf("hardened derivation from public gives err %v", err)
+	}
+	if _, err := account.Neuter().PrivateKey(); err != ErrNotPrivate {
+		t.Fatalf("private key of public extended key gives err %v", err)
+	}
+
+	//hardened markers
+	for _, path := range []string{"m/84h/0H/0'", "84'/0'/0'"} {
+		key, err := master.Derive(path)
+		if err != nil || key.String() != account.String() {
+			t.Fatalf("path %s gives %v, err %v", path, key, err)
+		}
+	}
+
+	testnetMaster, _ := NewMasterKey(seed, ecc.TestNet3Params)
+	want := "tprv8ZgxMBicQKsPeDgjzdC36fs6bMjGApWDNLR9erAXMs5skhMv36j9MV5ecvfavji5khqjWaWSFhN3YcCUUdiKH6isR4Pwy3U5y5egddBr16m"
+	if testnetMaster.String() != want {
+		t.Fatalf("testnet master %s, want %s", testnetMaster, want)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	indexes, err := ParsePath("m/84'/0'/0'/0/5")
+	if err != nil {
+		t.Fatalf("parse path: %v", err)
+	}
+	want := []uint32{HardenedKeyStart + 84, HardenedKeyStart, HardenedKeyStart, 0, 5}
+	if len(indexes) != len(want) {
+		t.Fatalf("path indexes %v, want %v", indexes, want)
+	}
+	for i := range want {
+		if indexes[i] != want[i] {
+			t.Fatalf("path indexes %v, want %v", indexes, want)
+		}
+	}
+	if indexes, err := ParsePath("m"); err != nil || len(indexes) != 0 {
+		t.Fatalf("master path gives %v, err %v", indexes, err)
+	}
+
+	for _, path := range []string{"m/", "m//1", "m/-1", "m/+1", "m/2147483648", "m/1''", "m/a", "x/1"} {
+		if _, err := ParsePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("path %q gives err %v", path, err)
+		}
+	}
+}
+
+func TestParseInvalidExtendedKey(t *testing.T) {
+	tests := []struct {
+		key string
+		err error
+	}{
+		{ecc.Base58Checksum(ecc.MainNetParams.HDPublicKeyID()), ErrInvalidKeyLen},
+		//last chars changed
+		{"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EBygr15", ecc.ErrBase58Checksum},
+		//public key not on curve
+		{"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ1hr9Rwbk95YadvBkQXxzHBSngB8ndpW6QH7zhhsXZ2jHyZqPjk", ErrInvalidPublicKey},
+		{"xbad4LfUL9eKmA66w2GJdVMqhvDmYGJpTGjWRAtjHqoUY17sGaymoMV9Cm3ocn9Ud6Hh2vLFVC7KSKCRVVrqc6dsEdsTjRV1WUmkK85YEUujAPX", ErrUnknownVersion},
+	}
+	for _, test := range tests {
+		_, err := ParseExtendedKey(test.key)
+		if err != test.err {
+			t.Fatalf("parse %s gives err %v, want %v", test.key, err, test.err)
+		}
+	}
+
+	if _, err := NewMasterKey(make([]byte, 15), ecc.MainNetParams); err != ErrInvalidSeedLen {
+		t.Fatalf("short seed gives err %v", err)
+	}
+}
+
+func TestBIP39Vectors(t *testing.T) {
+	//vectors of the BIP 39 reference implementation, all with passphrase TREZOR
+	tests := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607"},
+		{"80808080808080808080808080808080",
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8"},
+		{"ffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069"},
+		{"000000000000000000000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon agent",
+			"035895f2f481b1b0f01fcf8c289c794660b289981a78f8106447707fdd9666ca06da5a9a565181599b79f53b844d8a71dd9f439c52a3d7b3e8a79c906ac845fa"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal will",
+			"f2b94508732bcbacbcc020faefecfc89feafa6649a5491b8c952cede496c214a0c7b3c392d168748f2d4a612bada0753b52a1c7ac53c1e93abd5c6320b9e95dd"},
+		{"808080808080808080808080808080808080808080808080",
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter always",
+			"107d7c02a5aa6f38c58083ff74f04c607c2d2c0ecc55501dadd72d025b751bc27fe913ffb796f841c49b1d33b610cf0e91d3aa239027f5e99fe4ce9e5088cd65"},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo when",
+			"0cd6e5d827bb62eb8fc1e262254223817fd068a74b5b449cc2f667c3f1f985a76379b43348d952e2265b4cd129090758b3e3c2c49103b5051aac2eaeb890a528"},
+		{"0000000000000000000000000000000000000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+			"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth title",
+			"bc09fca1804f7e69da93c2f2028eb238c227f2e9dda30cd63699232578480a4021b146ad717fbb7e451ce9eb835f43620bf5c514db0f8add49f5d121449d3e87"},
+		{"8080808080808080808080808080808080808080808080808080808080808080",
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless",
+			"c0c519bd0e91a2ed54357d9d1ebef6f5af218a153624cf4f2da911a0ed8f7a09e2ef61af0aca007096df430022f7a2b6fb91661a9589097069720d015e4e982f"},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+			"dd48c104698c30cfe2b6142103248622fb7bb0ff692eebb00089b32d22484e1613912f0a5b694407be899ffd31ed3992c456cdf60f5d4564b8ba3f05a69890ad"},
+		{"77c2b00716cec7213839159e404db50d",
+			"jelly better achieve collect unaware mountain thought cargo oxygen act hood bridge",
+			"b5b6d0127db1a9d2226af0c3346031d77af31e918dba64287a1b44b8ebf63cdd52676f672a290aae502472cf2d602c051f3e6f18055e84e4c43897fc4e51a6ff"},
+		{"b63a9c59a6e641f288ebc103017f1da9f8290b3da6bdef7b",
+			"renew stay biology evidence goat welcome casual join adapt armor shuffle fault little machine walk stumble urge swap",
+			"9248d83e06f4cd98debf5b6f010542760df925ce46cf38a1bdb4e4de7d21f5c39366941c69e1bdbf2966e0f6e6dbece898a0e2f0a4c2b3e640953dfe8b7bbdc5"},
+		{"3e141609b97933b66a060dcddc71fad1d91677db872031e85f4c015c5e7e8982",
+			"dignity pass list indicate nasty swamp pool script soccer toe leaf photo multiply desk host tomato cradle drill spread actor shine dismiss champion exotic",
+			"ff7f3184df8696d8bef94b6c03114dbee0ef89ff938712301d27ed8336ca89ef9635da20af07d4175f2bf5f3de130f39c9d9e8dd0472489c19b1a020a940da67"},
+		{"0460ef47585604c5660618db2e6a7e7f",
+			"afford alter spike radar gate glance object seek swamp infant panel yellow",
+			"65f93a9f36b6c85cbe634ffc1f99f2b82cbb10b31edc7f087b4f6cb9e976e9faf76ff41f8f27c99afdf38f7a303ba1136ee48a4c1e7fcd3dba7aa876113a36e4"},
+		{"72f60ebac5dd8add8d2a25a797102c3ce21bc029c200076f",
+			"indicate race push merry suffer human cruise dwarf pole review arch keep canvas theme poem divorce alter left",
+			"3bbf9daa0dfad8229786ace5ddb4e00fa98a044ae4c4975ffd5e094dba9e0bb289349dbe2091761f30f382d4e35c4a670ee8ab50758d2c55881be69e327117ba"},
+		{"2c85efc7f24ee4573d2b81a6ec66cee209b2dcbd09d8eddc51e0215b0b68e416",
+			"clutch control vehicle tonight unusual clog visa ice plunge glimpse recipe series open hour vintage deposit universe tip job dress radar refuse motion taste",
+			"fe908f96f46668b2d5b37d82f558c77ed0d69dd0e7e043a5b0511c48c2f1064694a956f86360c93dd04052a8899497ce9e985ebe0c8c52b955e6ae86d4ff4449"},
+		{"eaebabb2383351fd31d703840b32e9e2",
+			"turtle front uncle idea crush write shrug there lottery flower risk shell",
+			"bdfb76a0759f301b0b899a1e3985227e53b3f51e67e3f2a65363caedf3e32fde42a66c404f18d7b05818c95ef3ca1e5146646856c461c073169467511680876c"},
+		{"7ac45cfe7722ee6c7ba84fbc2d5bd61b45cb2fe5eb65aa78",
+			"kiss carry display unusual confirm curtain upgrade antique rotate hello void custom frequent obey nut hole price segment",
+			"ed56ff6c833c07982eb7119a8f48fd363c4a9b1601cd2de736b01045c5eb8ab4f57b079403485d1c4924f0790dc10a971763337cb9f9c62226f64fff26397c79"},
+		{"4fa1a8bc3e6d80ee1316050e862c1812031493212b7ec3f3bb1b08f168cabeef",
+			"exile ask congress lamp submit jacket era scheme attend cousin alcohol catch course end lucky hurt sentence oven short ball bird grab wing top",
+			"095ee6f817b4c2cb30a5a797360a81a40ab0f9a4e25ecd672a3f58a0b5ba0687c096a6b14d2c0deb3bdefce4f61d01ae07417d502429352e27695163f7447a8c"},
+		{"18ab19a9f54a9274f03e5209a2ac8a91",
+			"board flee heavy tunnel powder denial science ski answer betray cargo cat",
+			"6eff1bb21562918509c73cb990260db07c0ce34ff0e3cc4a8cb3276129fbcb300bddfe005831350efd633909f476c45c88253276d9fd0df6ef48609e8bb7dca8"},
+		{"18a2e1d81b8ecfb2a333adcb0c17a5b9eb76cc5d05db91a4",
+			"board blade invite damage undo sun mimic interest slam gaze truly inherit resist great inject rocket museum chief",
+			"f84521c777a13b61564234bf8f8b62b3afce27fc4062b51bb5e62bdfecb23864ee6ecf07c1d5a97c0834307c5c852d8ceb88e7c97923c0a3b496bedd4e5f88a9"},
+		{"15da872c95a13dd738fbf50e427583ad61f18fd99f628c417a61cf8343c90419",
+			"beyond stage sleep clip because twist token leaf atom beauty genius food business side grid unable middle armed observe pair crouch tonight away coconut",
+			"b15509eaa2d09d3efd3e006ef42151b30367dc6e3aa5e44caba3fe4d3e352e65101fbdb86a96776b91946ff06f8eac594dc6ee1d3e82a42dfe1b40fef6bcc3fd"},
+	}
+
+	for _, test := range tests {
+		entropy, _ := hex.DecodeString(test.entropy)
+		mnemonic, err := NewMnemonic(entropy)
+		if err != nil || mnemonic != test.mnemonic {
+			t.Fatalf("mnemonic of %s is %q, err %v", test.entropy, mnemonic, err)
+		}
+		decoded, err := MnemonicToEntropy(mnemonic)
+		if err != nil || hex.EncodeToString(decoded) != test.entropy {
+			t.Fatalf("entropy of %q is %x, err %v", mnemonic, decoded, err)
+		}
+		seed, err := SeedFromMnemonic(mnemonic, "TREZOR")
+		if err != nil || hex.EncodeToString(seed) != test.seed {
+			t.Fatalf("seed of %q is %x, err %v", mnemonic, seed, err)
+		}
+	}
+
+	master, err := NewMasterKeyFromMnemonic(tests[0].mnemonic, "TREZOR", ecc.MainNetParams)
+	if err != nil {
+		t.Fatalf("master key from mnemonic: %v", err)
+	}
+	want := "xprv9s21ZrQH143K3h3fDYiay8mocZ3afhfULfb5GX8kCBdno77K4HiA15Tg23wpbeF1pLfs1c5SPmYHrEpTuuRhxMwvKDwqdKiGJS9XFKzUsAF"
+	if master.String() != want {
+		t.Fatalf("master key %s, want %s", master, want)
+	}
+}
+
+func TestInvalidMnemonic(t *testing.T) {
+	for _, mnemonic := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow yellow",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice caged above",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo, wrong",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal will will will",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter always.",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo why",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art art",
+		"legal winner thank year wave sausage worth useful legal winner thanks year wave worth useful legal winner thank year wave sausage worth title",
+		"letter advice cage absurd amount doctor acoustic avoid letters advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo voted",
+		"jello better achieve collect unaware mountain thought cargo oxygen act hood bridge",
+		"renew, stay, biology, evidence, goat, welcome, casual, join, adapt, armor, shuffle, fault, little, machine, walk, stumble, urge, swap",
+		"dignity pass list indicate nasty",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon letter",
+	} {
+		if ValidateMnemonic(mnemonic) == nil {
+			t.Fatalf("invalid mnemonic %q accepted", mnemonic)
+		}
+		if _, err := SeedFromMnemonic(mnemonic, ""); err == nil {
+			t.Fatalf("seed of invalid mnemonic %q", mnemonic)
+		}
+	}
+
+	if _, err := MnemonicToEntropy("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err != ErrMnemonicChecksum {
+		t.Fatalf("bad checksum gives err %v", err)
+	}
+	if _, err := MnemonicToEntropy("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abouts"); !errors.Is(err, ErrMnemonicWord) {
+		t.Fatalf("unknown word gives err %v", err)
+	}
+	if _, err := NewMnemonic(make([]byte, 15)); err != ErrEntropyLength {
+		t.Fatalf("short entropy gives err %v", err)
+	}
+}
+
+func TestNewEntropy(t *testing.T) {
+	for bits, words := range map[int]int{128: 12, 160: 15, 192: 18, 224: 21, 256: 24} {
+		entropy, err := NewEntropy(bits)
+		if err != nil {
+			t.Fatalf("new entropy: %v", err)
+		}
+		mnemonic, _ := NewMnemonic(entropy)
+		if len(strings.Fields(mnemonic)) != words || ValidateMnemonic(mnemonic) != nil {
+			t.Fatalf("%d bits entropy gives mnemonic %q", bits, mnemonic)
+		}
+	}
+	if _, err := NewEntropy(100); err != ErrEntropyLength {
+		t.Fatalf("100 bits entropy gives err %v", err)
+	}
+
+	//the passphrase is NFKD normalized, composed and decomposed e acute give the same seed
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	if hex.EncodeToString(NewSeed(mnemonic, "caf\u00e9")) != hex.EncodeToString(NewSeed(mnemonic, "cafe\u0301")) {
+		t.Fatalf("passphrase is not normalized")
+	}
+}
@@ -0,0 +1,163 @@
+package hdwallet
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+Mnemonic code (BIP 39)
+
+people can not back up 32 random bytes by hand, so the entropy is written as
+words:
+
+	1. take 128 to 256 bits of entropy, a multiple of 32 bits
+	2. append the first entropy bits / 32 bits of sha256(entropy) as checksum
+	3. split the result into 11 bits groups, every group is the index of a
+	   word in a list of 2048 words
+
+128 bits give 12 words and 256 bits give 24 words. The seed for BIP 32 is not
+the entropy, it is
+
+	pbkdf2(hmac-sha512, password: mnemonic, salt: "mnemonic" || passphrase,
+	2048 iterations, 64 bytes)
+
+both in unicode NFKD. The passphrase is never checked, every passphrase gives
+a valid but different wallet.
+*/
+
+const (
+	pbkdf2Iterations = 2048
+	seedLen          = 64
+	bitsPerWord      = 11
+)
+
+var (
+	ErrEntropyLength     = errors.New("entropy should be 128 to 256 bits and a multiple of 32 bits")
+	ErrMnemonicWordCount = errors.New("mnemonic should have 12, 15, 18, 21 or 24 words")
+	ErrMnemonicWord      = errors.New("word is not in the BIP 39 wordlist")
+	ErrMnemonicChecksum  = errors.New("mnemonic checksum mismatch")
+)
+
+func validEntropyLen(byteLen int) bool {
+	return byteLen >= 16 && byteLen <= 32 && byteLen%4 == 0
+}
+
+// NewEntropy random entropy of bitSize bits for NewMnemonic, 128 for 12 words, 256 for 24 words
+func NewEntropy(bitSize int) ([]byte, error) {
+	if bitSize%8 != 0 || !validEntropyLen(bitSize/8) {
+		return nil, ErrEntropyLength
+	}
+	entropy := make([]byte, bitSize/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// NewMnemonic the words encoding entropy and its checksum
+func NewMnemonic(entropy []byte) (string, error) {
+	if !validEntropyLen(len(entropy)) {
+		return "", ErrEntropyLength
+	}
+	hash := sha256.Sum256(entropy)
+	//the checksum is at most 8 bits, the first byte of the hash is enough
+	data := append(append([]byte{}, entropy...), hash[0])
+	wordCount := (len(entropy)*8 + len(entropy)/4) / bitsPerWord
+
+	words := make([]string, wordCount)
+	for i := 0; i < wordCount; i++ {
+		index := 0
+		for bit := i * bitsPerWord; bit < (i+1)*bitsPerWord; bit++ {
+			index = index<<1 | int(data[bit/8]>>(7-bit%8)&1)
+		}
+		words[i] = englishWordlist[index]
+	}
+	return strings.Join(words, " "), nil
+}
+
+func wordIndex(word string) (int, bool) {
+	index := sort.SearchStrings(englishWordlist, word)
+	return index, index < len(englishWordlist) && englishWordlist[index] == word
+}
+
+// MnemonicToEntropy the entropy encoded by mnemonic, the checksum is verified
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(norm.NFKD.String(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrMnemonicWordCount
+	}
+
+	totalBits := len(words) * bitsPerWord
+	checksumBits := totalBits / 33
+	//one more byte for the checksum bits
+	data := make([]byte, (totalBits+7)/8)
+	for i, word := range words {
+		index, ok := wordIndex(word)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrMnemonicWord, word)
+		}
+		for j := 0; j < bitsPerWord; j++ {
+			if index>>(bitsPerWord-1-j)&1 == 1 {
+				bit := i*bitsPerWord + j
+				data[bit/8] |= 0x80 >> (bit % 8)
+			}
+		}
+	}
+
+	entropy := data[:(totalBits-checksumBits)/8]
+	hash := sha256.Sum256(entropy)
+	mask := byte(0xff) << (8 - checksumBits)
+	if data[len(entropy)]&mask != hash[0]&mask {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+// ValidateMnemonic nil if mnemonic has a valid word count, words and checksum
+func ValidateMnemonic(mnemonic string) error {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err
+}
+
+/*
+NewSeed
+the 64 bytes BIP 32 seed of mnemonic and passphrase, mnemonic is not validated
+so phrases of other wallets (for example Electrum) or other languages work as
+well, use SeedFromMnemonic to reject typos
+*/
+func NewSeed(mnemonic string, passphrase string) []byte {
+	password := norm.NFKD.String(strings.Join(strings.Fields(mnemonic), " "))
+	salt := []byte("mnemonic" + norm.NFKD.String(passphrase))
+	seed, err := pbkdf2.Key(sha512.New, password, salt, pbkdf2Iterations, seedLen)
+	if err != nil {
+		panic(fmt.Sprintf("pbkdf2 err: %v\n", err))
+	}
+	return seed
+}
+
+// SeedFromMnemonic the same as NewSeed after checking mnemonic is a valid English mnemonic
+func SeedFromMnemonic(mnemonic string, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	return NewSeed(mnemonic, passphrase), nil
+}
+
+// NewMasterKeyFromMnemonic the BIP 32 master key of a valid mnemonic and passphrase
+func NewMasterKeyFromMnemonic(mnemonic string, passphrase string, params *ecc.ChainParams) (*ExtendedKey, error) {
+	seed, err := SeedFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewMasterKey(seed, params)
+}
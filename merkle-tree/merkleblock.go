@@ -91,12 +91,6 @@ type MerkleBlock struct {
 	flagBits          []byte
 }
 
-func ErrorPanic(err error, msg string) {
-	if err != nil {
-		panic(msg)
-	}
-}
-
 // convert bytes to bits
 func BytesToBitsField(bytes []byte) []string {
 	flagBits := make([]string, 0)
@@ -107,65 +101,54 @@ func BytesToBitsField(bytes []byte) []string {
 	return flagBits
 }
 
-func ParseMerkleBlock(payload []byte) *MerkleBlock {
+func ParseMerkleBlock(payload []byte) (*MerkleBlock, error) {
 	merkleBlock := &MerkleBlock{}
 	reader := bytes.NewReader(payload)
 	bufReader := bufio.NewReader(reader)
-	version := make([]byte, 4)
-	_, err := bufReader.Read(version)
-	ErrorPanic(err, "MerkleBlock read version")
-	merkleBlock.version = transaction.LittleEndianToBigInt(version, transaction.LittleEndian4Bytes)
-
-	prevBlock := make([]byte, 32)
-	_, err = bufReader.Read(prevBlock)
-	ErrorPanic(err, "MerkleBlock read previous block")
-	merkleBlock.previousBlock = transaction.ReverseByteSlice(prevBlock)
-
-	merkleRoot := make([]byte, 32)
-	_, err = bufReader.Read(merkleRoot)
-	ErrorPanic(err, "MerkleBlock read merkle root")
-	merkleBlock.merkleRoot = transaction.ReverseByteSlice(merkleRoot)
-
-	timeStamp := make([]byte, 4)
-	_, err = bufReader.Read(timeStamp)
-	ErrorPanic(err, "MerkleBlock read timestamp")
-	merkleBlock.timeStamp = transaction.LittleEndianToBigInt(timeStamp, transaction.LittleEndian4Bytes)
-
-	bits := make([]byte, 4)
-	_, err = bufReader.Read(bits)
-	ErrorPanic(err, "MerkleBlock read bits")
-	merkleBlock.bits = bits
-
-	nonce := make([]byte, 4)
-	_, err = bufReader.Read(nonce)
-	ErrorPanic(err, "MerkleBlock read nonce")
-	merkleBlock.nonce = nonce
-
-	total := make([]byte, 4)
-	_, err = bufReader.Read(total)
-	ErrorPanic(err, "MerkleBlock read total")
+	header, err := transaction.ReadField(bufReader, transaction.BlockHeaderLen, "merkleblock header")
+	if err != nil {
+		return nil, err
+	}
+	merkleBlock.version = transaction.LittleEndianToBigInt(header[0:4], transaction.LittleEndian4Bytes)
+	merkleBlock.previousBlock = transaction.ReverseByteSlice(header[4:36])
+	merkleBlock.merkleRoot = transaction.ReverseByteSlice(header[36:68])
+	merkleBlock.timeStamp = transaction.LittleEndianToBigInt(header[68:72], transaction.LittleEndian4Bytes)
+	merkleBlock.bits = header[72:76]
+	merkleBlock.nonce = header[76:80]
+
+	total, err := transaction.ReadField(bufReader, 4, "merkleblock total")
+	if err != nil {
+		return nil, err
+	}
 	merkleBlock.totalTransactions = transaction.LittleEndianToBigInt(total, transaction.LittleEndian4Bytes)
 
-	numHashes := transaction.ReadVariant(bufReader)
+	numHashes, err := transaction.ReadVariant(bufReader)
+	if err != nil {
+		return nil, transaction.NewParseError("merkleblock hash count", err)
+	}
 	merkleBlock.numHahses = numHashes
 
 	hashes := make([][]byte, 0)
 	for i := 0; i < int(numHashes.Int64()); i++ {
-		hash := make([]byte, 32)
-		_, err = bufReader.Read(hash)
-		ErrorPanic(err, "MerkleBlock read hash")
+		hash, err := transaction.ReadField(bufReader, 32, "merkleblock hash")
+		if err != nil {
+			return nil, err
+		}
 		hashes = append(hashes, transaction.ReverseByteSlice(hash))
 	}
 	merkleBlock.hashes = hashes
 
-	flagLen := transaction.ReadVariant(bufReader)
-	flags := make([]byte, flagLen.Int64())
-	_, err = bufReader.Read(flags)
-	ErrorPanic(err, "MerkleBlock read flags")
+	flagLen, err := transaction.ReadVariant(bufReader)
+	if err != nil {
+		return nil, transaction.NewParseError("merkleblock flag length", err)
+	}
+	flags, err := transaction.ReadField(bufReader, flagLen.Uint64(), "merkleblock flags")
+	if err != nil {
+		return nil, err
+	}
 	merkleBlock.flagBits = flags
 
-	return merkleBlock
-
+	return merkleBlock, nil
 }
 
 func (m *MerkleBlock) String() string {
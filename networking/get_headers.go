@@ -3,8 +3,9 @@ package networking
 import (
 	"bufio"
 	"bytes"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
 	tx "github.com/Gharib110/Bitcoin/transaction"
 	"math/big"
 )
@@ -17,12 +18,11 @@ type GetHeaderMessage struct {
 	endBlock   []byte
 }
 
-func GetGenesisBlockHash() []byte {
-	genesisBlockRawData, err := hex.DecodeString("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
+func GetGenesisBlockHash(params *ecc.ChainParams) []byte {
+	genesisBlock, err := tx.ParseBlock(params.GenesisBlock())
 	if err != nil {
 		panic(err)
 	}
-	genesisBlock := tx.ParseBlock(genesisBlockRawData)
 	return genesisBlock.Hash()
 }
 
@@ -76,53 +76,43 @@ it is always fixed to 0x00
 
 */
 
+var ErrHeadersTxCount = errors.New("transaction count after a header in headers message should be 0")
+
 func LenOfVariant(val *big.Int) int {
 	//returning how many bytes needed by the variant value
-	shiftBytes := len(val.Bytes())
-	if val.Cmp(big.NewInt(0xfd)) >= 0 {
-		shiftBytes += 1
-	}
-
-	return shiftBytes
+	return len(tx.EncodeVariant(val))
 }
 
-func ParseGetHeader(rawData []byte) []*tx.Block {
+func ParseGetHeader(rawData []byte) ([]*tx.Block, error) {
 	reader := bytes.NewReader(rawData)
 	bufReader := bufio.NewReader(reader)
-	numHeads := tx.ReadVariant(bufReader)
+	numHeads, err := tx.ReadVariant(bufReader)
+	if err != nil {
+		return nil, tx.NewParseError("header count", err)
+	}
 	fmt.Printf("header count%d\n", numHeads)
-	shiftBytes := LenOfVariant(numHeads)
-	rawData = rawData[shiftBytes:]
 
 	blocks := make([]*tx.Block, 0)
 	for i := 0; i < int(numHeads.Int64()); i++ {
-		block := tx.ParseBlock(rawData)
+		field := fmt.Sprintf("header %d", i)
+		header, err := tx.ReadField(bufReader, tx.BlockHeaderLen, field)
+		if err != nil {
+			return nil, err
+		}
+		block, err := tx.ParseBlock(header)
+		if err != nil {
+			return nil, tx.NewParseError(field, err)
+		}
 		blocks = append(blocks, block)
 
-		rawData = rawData[len(block.Serialize()):]
-		reader := bytes.NewReader(rawData)
-		bufReader := bufio.NewReader(reader)
-		numTxs := tx.ReadVariant(bufReader)
-		if numTxs.Cmp(big.NewInt(0)) != 0 {
-			panic("number of transaction is not 0")
+		numTxs, err := tx.ReadVariant(bufReader)
+		if err != nil {
+			return nil, tx.NewParseError(field, err)
 		}
-
-		shift := LenOfVariant(numTxs)
-		if shift == 0 {
-			/*
-				a := big.NewInt(0), len(a.Bytes()) == 0
-				big.Int will remove any prefix 0 in the buffer, if the value is 0,
-				then the buffer will only contain 0, it will remove the 0 in the buffer
-				which leads to 0 length of buffer
-				buf := [0x00] => []
-				b := make(big.Int)
-				b.SetBytes(buf),
-				len(b.Bytes()) => 0
-			*/
-			shift = 1
+		if numTxs.Cmp(big.NewInt(0)) != 0 {
+			return nil, tx.NewParseError(field, ErrHeadersTxCount)
 		}
-		rawData = rawData[shift:]
 	}
 
-	return blocks
+	return blocks, nil
 }
@@ -1,9 +1,15 @@
 package networking
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
+	"testing/iotest"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+	tx "github.com/Gharib110/Bitcoin/transaction"
 )
 
 func TestOne(t *testing.T) {
@@ -12,7 +18,10 @@ func TestOne(t *testing.T) {
 		panic(err)
 	}
 
-	network := ParseNetwork(networkRawData, false)
+	network, err := ParseNetwork(networkRawData, ecc.MainNetParams)
+	if err != nil {
+		t.Fatalf("parse network envelope: %v", err)
+	}
 	fmt.Printf("%s\n", network)
 	fmt.Printf("%x\n", network.Serialize())
 	version := NewVersionMessage()
@@ -20,6 +29,85 @@ func TestOne(t *testing.T) {
 }
 
 func TestTwo(t *testing.T) {
-	getHeaderMsg := NewGetHeaderMessage(GetGenesisBlockHash())
+	getHeaderMsg := NewGetHeaderMessage(GetGenesisBlockHash(ecc.MainNetParams))
 	fmt.Printf("raw data for get headers msg:%x\n", getHeaderMsg.Serialize())
 }
+
+const versionEnvelopeHex = "f9beb4d976657273696f6e0000000000650000005f1a69d2721101000100000000000000bc8f5e5400000000010000000000000000000000000000000000ffffc61b6409208d010000000000000000000000000000000000ffffcb0071c0208d128035cbc97953f80f2f5361746f7368693a302e392e332fcf05050001"
+
+func TestParseNetworkErrors(t *testing.T) {
+	rawData, _ := hex.DecodeString(versionEnvelopeHex)
+	for i := 0; i < len(rawData); i++ {
+		if _, err := ParseNetwork(rawData[:i], ecc.MainNetParams); !errors.Is(err, tx.ErrTruncated) {
+			t.Fatalf("%d bytes of %d give err %v", i, len(rawData), err)
+		}
+	}
+	if _, err := ParseNetwork(rawData, ecc.TestNet3Params); !errors.Is(err, ErrNetworkMagic) {
+		t.Fatalf("main-net envelope on testnet gives err %v", err)
+	}
+	badChecksum := append([]byte{}, rawData...)
+	badChecksum[20] ^= 0x01
+	if _, err := ParseNetwork(badChecksum, ecc.MainNetParams); !errors.Is(err, ErrNetworkChecksum) {
+		t.Fatalf("bad checksum gives err %v", err)
+	}
+	tooLarge := append([]byte{}, rawData...)
+	copy(tooLarge[16:20], []byte{0x01, 0x00, 0x00, 0x02})
+	if _, err := ParseNetwork(tooLarge, ecc.MainNetParams); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("huge payload length gives err %v", err)
+	}
+}
+
+func TestReadNetworkEnvelope(t *testing.T) {
+	rawData, _ := hex.DecodeString(versionEnvelopeHex)
+	verack := NewNetworkEnvelope([]byte("verack"), nil, ecc.MainNetParams)
+	var stream bytes.Buffer
+	stream.Write(rawData)
+	if err := verack.Encode(&stream); err != nil {
+		t.Fatalf("encode verack: %v", err)
+	}
+
+	//a peer sending version and verack at once, read a byte at a time
+	reader := iotest.OneByteReader(&stream)
+	version, err := ReadNetworkEnvelope(reader, ecc.MainNetParams)
+	if err != nil {
+		t.Fatalf("read version: %v", err)
+	}
+	if !bytes.Equal(version.Serialize(), rawData) || version.SerializeSize() != len(rawData) {
+		t.Fatalf("version envelope serializes to %x", version.Serialize())
+	}
+	received, err := ReadNetworkEnvelope(reader, ecc.MainNetParams)
+	if err != nil {
+		t.Fatalf("read verack: %v", err)
+	}
+	if string(bytes.Trim(received.command, "\x00")) != "verack" || len(received.payload) != 0 {
+		t.Fatalf("second envelope %s", received)
+	}
+	if _, err := ReadNetworkEnvelope(reader, ecc.MainNetParams); !errors.Is(err, tx.ErrTruncated) {
+		t.Fatalf("empty stream gives err %v", err)
+	}
+}
+
+func FuzzParseNetwork(f *testing.F) {
+	rawData, _ := hex.DecodeString(versionEnvelopeHex)
+	f.Add(rawData)
+	f.Add(rawData[:24])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		envelope, err := ParseNetwork(data, ecc.MainNetParams)
+		if err != nil {
+			return
+		}
+		//the envelope is at the beginning of data
+		if !bytes.HasPrefix(data, envelope.Serialize()) {
+			t.Fatalf("envelope serializes to %x", envelope.Serialize())
+		}
+	})
+}
+
+func FuzzParseGetHeader(f *testing.F) {
+	header, _ := hex.DecodeString("00000020df3b053dc46f162a9b00c7f0d5124e2676d47bbe7c5d0793a500000000000000ef445fef2ed495c275892206ca533e7411907971013ab83e3b47bd0d692d14d4dc7c835b67d8001ac157e670")
+	f.Add(append(append([]byte{0x01}, header...), 0x00))
+	f.Add([]byte{0x02})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseGetHeader(data)
+	})
+}
@@ -1,13 +1,13 @@
 package networking
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
 	tx "github.com/Gharib110/Bitcoin/transaction"
 	"io"
-	"math/big"
 )
 
 /*
@@ -25,7 +25,7 @@ f9beb4d9
 
 1. first 4 bytes we call the magic number: f9beb4d9-> main-net
 it is used to tell the receiver that, here is the beginning of a network packet,
-for testnet: 0b110907
+for testnet3: 0b110907, every network has its own, see ecc.ChainParams
 
 2. the following 12 bytes is the command of the packet:76657273696f6e0000000000
 actually it is human-readable string, string(76657273696f6e0000000000)
@@ -40,107 +40,125 @@ command is used to indicate the purpose of this packet
 type NetworkEnvelope struct {
 	command []byte
 	payload []byte
-	testnet bool
 	magic   []byte
 }
 
-func NewNetworkEnvelope(command []byte, payload []byte, testnet bool) *NetworkEnvelope {
-	network := &NetworkEnvelope{
+func NewNetworkEnvelope(command []byte, payload []byte, params *ecc.ChainParams) *NetworkEnvelope {
+	return &NetworkEnvelope{
 		command: command,
 		payload: payload,
-		testnet: testnet,
+		magic:   params.Magic(),
 	}
+}
 
-	if testnet {
-		network.magic = []byte{0x0b, 0x11, 0x09, 0x07}
-	} else {
-		network.magic = []byte{0xf9, 0xbe, 0xb4, 0xd9}
-	}
+// MaxPayloadLen the largest payload we accept from a peer, the MAX_SIZE of Bitcoin Core
+const MaxPayloadLen = 0x02000000
+
+var (
+	ErrNetworkMagic    = errors.New("network magic does not match the network")
+	ErrNetworkChecksum = errors.New("payload checksum does not match")
+	ErrPayloadTooLarge = errors.New("payload is larger than the maximum message size")
+)
+
+// envelopeHeaderLen magic, command, payload length and checksum
+const envelopeHeaderLen = 24
 
-	return network
+/*
+ParseNetwork
+parses the envelope at the beginning of rawData, a peer may send several
+messages at once so anything after the payload is ignored
+*/
+func ParseNetwork(rawData []byte, params *ecc.ChainParams) (*NetworkEnvelope, error) {
+	return ReadNetworkEnvelope(bytes.NewReader(rawData), params)
 }
 
-func ParseNetwork(rawData []byte, testnet bool) *NetworkEnvelope {
-	reader := bytes.NewReader(rawData)
-	bufReader := bufio.NewReader(reader)
+/*
+ReadNetworkEnvelope
+reads one envelope from r, a connection to a peer for example, nothing after
+its payload is read so the next message stays in r
+*/
+func ReadNetworkEnvelope(r io.Reader, params *ecc.ChainParams) (*NetworkEnvelope, error) {
+	envelope := NewNetworkEnvelope(nil, nil, params)
+	if err := envelope.Decode(r); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
 
-	magic := make([]byte, 4)
-	n, err := io.ReadFull(bufReader, magic)
+// Decode reads one envelope with the magic of n from r, see tx.Decoder
+func (n *NetworkEnvelope) Decode(r io.Reader) error {
+	magic, err := tx.ReadField(r, 4, "magic")
 	if err != nil {
-		panic(err)
-	}
-	if n == 0 {
-		panic("connection reset")
+		return err
 	}
 
-	var expectedMagic []byte
-	if testnet {
-		expectedMagic = []byte{0x0b, 0x11, 0x09, 0x07}
-	} else {
-		expectedMagic = []byte{0xf9, 0xbe, 0xb4, 0xd9}
-	}
-	if !bytes.Equal(magic, expectedMagic) {
-		panic("magic is not right")
+	if !bytes.Equal(magic, n.magic) {
+		return tx.NewParseError("magic", ErrNetworkMagic)
 	}
 
-	command := make([]byte, 12)
-	_, err = io.ReadFull(bufReader, command)
+	command, err := tx.ReadField(r, 12, "command")
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	payloadLenBuf := make([]byte, 4)
-	_, err = io.ReadFull(bufReader, payloadLenBuf)
+	payloadLenBuf, err := tx.ReadField(r, 4, "payload length")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	payLoadLen := binary.LittleEndian.Uint32(payloadLenBuf)
+	if payLoadLen > MaxPayloadLen {
+		return tx.NewParseError("payload length", ErrPayloadTooLarge)
 	}
-	payLoadLen := new(big.Int)
-	payLoadLen.SetBytes(tx.ReverseByteSlice(payloadLenBuf))
 
-	checksum := make([]byte, 4)
-	_, err = io.ReadFull(bufReader, checksum)
+	checksum, err := tx.ReadField(r, 4, "checksum")
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	payload := make([]byte, payLoadLen.Int64())
-	_, err = io.ReadFull(bufReader, payload)
+	payload, err := tx.ReadField(r, uint64(payLoadLen), "payload")
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	calculatedChecksum := ecc.Hash256(string(payload))[0:4]
 	if !bytes.Equal(checksum, calculatedChecksum) {
-		panic("checksum dose not match")
+		return tx.NewParseError("checksum", ErrNetworkChecksum)
 	}
 
-	return NewNetworkEnvelope(command, payload, testnet)
+	n.magic = magic
+	n.command = command
+	n.payload = payload
+	return nil
 }
 
-func (n *NetworkEnvelope) Serialize() []byte {
-	result := make([]byte, 0)
-	result = append(result, n.magic...)
-	/*
-		the command field needs to be 12 bytes long. if it is not enough, we will pad
-		it with 0x00
-	*/
-	command := make([]byte, 0)
-	command = append(command, n.command...)
-	commandLen := len(command)
-	if len(command) < 12 {
-		for i := 0; i < 12-commandLen; i++ {
-			command = append(command, 0x00)
-		}
-	}
-	result = append(result, command...)
+// SerializeSize the length of Serialize, the 24 bytes header and the payload
+func (n *NetworkEnvelope) SerializeSize() int {
+	return envelopeHeaderLen + len(n.payload)
+}
 
-	payloadLen := big.NewInt(int64(len(n.payload)))
-	result = append(result, tx.BigIntToLittleEndian(payloadLen, tx.LittleEndian4Bytes)...)
-	//checksum
-	result = append(result, ecc.Hash256(string(n.payload))[0:4]...)
-	result = append(result, n.payload...)
+// Encode see tx.Encoder
+func (n *NetworkEnvelope) Encode(w io.Writer) error {
+	return tx.EncodeBuffered(w, func(w io.Writer) error {
+		header := make([]byte, envelopeHeaderLen)
+		copy(header[0:4], n.magic)
+		/*
+			the command field needs to be 12 bytes long. if it is not enough,
+			the rest stays 0x00
+		*/
+		copy(header[4:16], n.command)
+		binary.LittleEndian.PutUint32(header[16:20], uint32(len(n.payload)))
+		//checksum
+		copy(header[20:24], ecc.Hash256(string(n.payload))[0:4])
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		_, err := w.Write(n.payload)
+		return err
+	})
+}
 
-	return result
+func (n *NetworkEnvelope) Serialize() []byte {
+	return tx.EncodeToBytes(n)
 }
 
 func (n *NetworkEnvelope) String() string {
@@ -190,7 +208,7 @@ mapping ip4 => ip6, 00000000000000000000ffff => telling the sender is in ip4 for
 00. 00 .00. 00 => ip
 
 6. the following 2 bytes: 8d20, it is port of sender, 8333 is default port for bitcoin node
-of main-net, if the node is on the testnet, 18333
+of main-net, if the node is on the testnet3, 18333, see ecc.ChainParams.DefaultPort
 
 7. the following 8 bytes: 0000000000000000 in little endian it is a network service of sender
 
@@ -1,12 +1,15 @@
 package networking
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/hex"
 	"fmt"
 	"github.com/Gharib110/Bitcoin/bloom-filter"
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
 	"github.com/Gharib110/Bitcoin/merkle-tree"
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -16,37 +19,48 @@ type Message interface {
 }
 
 type SimpleNode struct {
-	host    string
-	port    uint16
-	testnet bool
+	host   string
+	port   uint16
+	params *ecc.ChainParams
+	//the connection Read reads from, with what is already received from it
+	conn   net.Conn
+	reader *bufio.Reader
 }
 
-func NewSimpleNode(host string, port uint16, testnet bool) *SimpleNode {
+// NewSimpleNode a node of the network params, port 0 is the default port of that network
+func NewSimpleNode(host string, port uint16, params *ecc.ChainParams) *SimpleNode {
+	if port == 0 {
+		port = params.DefaultPort()
+	}
 	return &SimpleNode{
-		host:    host,
-		port:    port,
-		testnet: testnet,
+		host:   host,
+		port:   port,
+		params: params,
 	}
 }
 
-func (s *SimpleNode) Run() {
+func (s *SimpleNode) Run() error {
 	/*
 		using socket connect to given host with given port, then
 		construct package with payload is a version message and send to
 		the peer, waiting peer to send back its version message and verack,
 		and we send verack back to peer and close the connection
 	*/
-	conStr := fmt.Sprintf("%s:%d", s.host, s.port)
+	//JoinHostPort puts an IPv6 host in brackets
+	conStr := net.JoinHostPort(s.host, strconv.Itoa(int(s.port)))
 	conn, err := net.Dial("tcp", conStr)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer conn.Close()
+	if err := s.WaitFor(conn); err != nil {
+		return err
 	}
-	s.WaitFor(conn)
 
-	s.GetData(conn)
+	return s.GetData(conn)
 }
 
-func (s *SimpleNode) GetData(conn net.Conn) {
+func (s *SimpleNode) GetData(conn net.Conn) error {
 	//prepare the bloom filter,
 	txHash, err := hex.DecodeString("1df77b894e1910628714bb73df59e20fb9114f9dcc051d8c03ca197dd112cc8a")
 	if err != nil {
@@ -56,7 +70,9 @@ func (s *SimpleNode) GetData(conn net.Conn) {
 	//set up the bloom filter map the transaction hash into buckets
 	bf.Add(txHash)
 	//send filterload command to fullnode
-	s.Send(conn, bf.FilterLoadMsg())
+	if err := s.Send(conn, bf.FilterLoadMsg()); err != nil {
+		return err
+	}
 	getData := bloomfilter.NewGetDataMessage()
 	receiveMerkleBlock := false
 	/*
@@ -66,19 +82,30 @@ func (s *SimpleNode) GetData(conn net.Conn) {
 			filter and put them in to merkleblock command
 	*/
 	blockHash, err := hex.DecodeString("0000000000000138f016a6fc1666fd667b7d282d65ad14b7f0b16a75a2e90e50")
+	if err != nil {
+		panic(err)
+	}
 	getData.AddData(bloomfilter.FilteredDataType(), blockHash)
-	s.Send(conn, getData)
+	if err := s.Send(conn, getData); err != nil {
+		return err
+	}
 
 	for !receiveMerkleBlock {
 		time.Sleep(2 * time.Second)
-		messages := s.Read(conn)
+		messages, err := s.Read(conn)
+		if err != nil {
+			return err
+		}
 		for i := 0; i < len(messages); i++ {
 			msg := messages[i]
 			fmt.Printf("receiving command %s\n", msg.command)
 			command := string(bytes.Trim(msg.command, "\x00"))
 
 			if command == "merkleblock" {
-				merkleBlock := merkletree.ParseMerkleBlock(msg.payload)
+				merkleBlock, err := merkletree.ParseMerkleBlock(msg.payload)
+				if err != nil {
+					return err
+				}
 				fmt.Printf("merkleblock received: %s\n", merkleBlock)
 				fmt.Printf("merkleblock valid: %v\n", merkleBlock.IsValid())
 				receiveMerkleBlock = true
@@ -86,84 +113,89 @@ func (s *SimpleNode) GetData(conn net.Conn) {
 		}
 	}
 
+	return nil
 }
 
-func (s *SimpleNode) GetHeaders(conn net.Conn) {
-	getHeaderMsg := NewGetHeaderMessage(GetGenesisBlockHash())
-	s.Send(conn, getHeaderMsg)
+func (s *SimpleNode) GetHeaders(conn net.Conn) error {
+	getHeaderMsg := NewGetHeaderMessage(GetGenesisBlockHash(s.params))
+	if err := s.Send(conn, getHeaderMsg); err != nil {
+		return err
+	}
 
 	receivedGetHeader := false
 	for !receivedGetHeader {
 		//let the peer have a rest
 		time.Sleep(2 * time.Second)
-		messages := s.Read(conn)
+		messages, err := s.Read(conn)
+		if err != nil {
+			return err
+		}
 		for i := 0; i < len(messages); i++ {
 			msg := messages[i]
 			fmt.Printf("receiving command:%s\n", msg.command)
 			command := string(bytes.Trim(msg.command, "\x00"))
 			if command == "headers" {
 				receivedGetHeader = true
-				blocks := ParseGetHeader(msg.payload)
+				blocks, err := ParseGetHeader(msg.payload)
+				if err != nil {
+					return err
+				}
 				for i := 0; i < len(blocks); i++ {
 					fmt.Printf("block header:\n%s\n", blocks[i])
 				}
 			}
 		}
 	}
+	return nil
 }
 
-func (s *SimpleNode) Send(conn net.Conn, msg Message) {
-	envelop := NewNetworkEnvelope([]byte(msg.Command()), msg.Serialize(), s.testnet)
-	n, err := conn.Write(envelop.Serialize())
-	if err != nil {
-		panic(err)
+func (s *SimpleNode) Send(conn net.Conn, msg Message) error {
+	envelop := NewNetworkEnvelope([]byte(msg.Command()), msg.Serialize(), s.params)
+	if err := envelop.Encode(conn); err != nil {
+		return err
 	}
-	fmt.Printf("write to %d\n bytes", n)
+	fmt.Printf("write to %d\n bytes", envelop.SerializeSize())
+	return nil
 }
 
-func (s *SimpleNode) Read(conn net.Conn) []*NetworkEnvelope {
-	receivedBuf := make([]byte, 0)
-	totalLen := 0
-	for {
-		buf := make([]byte, 4096)
-		n, err := conn.Read(buf)
-		if err != nil {
-			panic(err)
-		}
-		totalLen += n
-		receivedBuf = append(receivedBuf, buf...)
-		if n < 4096 {
-			break
-		}
+func (s *SimpleNode) Read(conn net.Conn) ([]*NetworkEnvelope, error) {
+	if s.conn != conn {
+		s.conn = conn
+		s.reader = bufio.NewReader(conn)
 	}
 	/*
-		the peer node may return a version and verack
-		at once
+		a message may come in several reads, and the peer may send a version
+		and verack at once, so wait for one whole envelope and then take
+		every one already received
 	*/
-	var messages []*NetworkEnvelope
-	parsedLen := 0
-	for {
-		if parsedLen >= totalLen {
-			break
+	msg, err := ReadNetworkEnvelope(s.reader, s.params)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*NetworkEnvelope{msg}
+	for s.reader.Buffered() > 0 {
+		msg, err := ReadNetworkEnvelope(s.reader, s.params)
+		if err != nil {
+			return nil, err
 		}
-		msg := ParseNetwork(receivedBuf, s.testnet)
 		messages = append(messages, msg)
-		if parsedLen < totalLen {
-			parsedLen += len(msg.Serialize())
-			receivedBuf = receivedBuf[len(msg.Serialize()):]
-		}
 	}
 
-	return messages
+	return messages, nil
 }
 
-func (s *SimpleNode) WaitFor(conn net.Conn) {
-	s.Send(conn, NewVersionMessage())
+func (s *SimpleNode) WaitFor(conn net.Conn) error {
+	if err := s.Send(conn, NewVersionMessage()); err != nil {
+		return err
+	}
 
 	verackReceived := false
 	versionReceived := false
 	for !verackReceived || !versionReceived {
-		messages := s.Read(conn)
+		messages, err := s.Read(conn)
+		if err != nil {
+			return err
+		}
 		for i := 0; i < len(messages); i++ {
 			msg := messages[i]
 			command := string(bytes.Trim(msg.command, "\x00"))
@@ -175,10 +207,13 @@ func (s *SimpleNode) WaitFor(conn net.Conn) {
 			if command == "version" {
 				versionReceived = true
 				fmt.Printf("receiving version message from peer\n:%s", msg)
-				s.Send(conn, NewVerAckMessage())
+				if err := s.Send(conn, NewVerAckMessage()); err != nil {
+					return err
+				}
 			}
 		}
 	}
+	return nil
 }
 
 /*
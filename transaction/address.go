@@ -0,0 +1,239 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+/*
+Address
+
+an address is only a short way to write a scriptPubKey (locking script):
+
+	P2PKH   base58 version 0x00 (testnet 0x6f) + hash160 of the sec
+	        OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG
+	P2SH    base58 version 0x05 (testnet 0xc4) + hash160 of the redeem script
+	        OP_HASH160 <20 bytes> OP_EQUAL
+	P2WPKH  bech32 witness v0, 20 bytes program
+	        OP_0 <20 bytes>
+	P2WSH   bech32 witness v0, 32 bytes program, sha256 of the witness script
+	        OP_0 <32 bytes>
+	P2TR    bech32m witness v1, 32 bytes x-only output key
+	        OP_1 <32 bytes>
+
+witness versions 2 to 16 have no meaning yet, but wallets must be able to pay
+to them so they are kept as AddressWitnessUnknown. Testnet3, testnet4 and
+signet share the testnet prefixes, regtest shares the base58 ones and has its
+own hrp bcrt, so an address can only tell TestNet3Params or RegTestParams.
+*/
+
+type AddressType int
+
+const (
+	AddressP2PKH AddressType = iota
+	AddressP2SH
+	AddressP2WPKH
+	AddressP2WSH
+	AddressP2TR
+	AddressWitnessUnknown
+)
+
+var (
+	ErrUnknownAddress       = errors.New("unknown address format")
+	ErrAddressVersion       = errors.New("unknown base58 address version")
+	ErrAddressHrp           = errors.New("unknown segwit address human readable part")
+	ErrNonStandardScript    = errors.New("script has no address")
+	ErrAddressPayloadLength = errors.New("invalid address payload length")
+)
+
+func (a AddressType) String() string {
+	switch a {
+	case AddressP2PKH:
+		return "p2pkh"
+	case AddressP2SH:
+		return "p2sh"
+	case AddressP2WPKH:
+		return "p2wpkh"
+	case AddressP2WSH:
+		return "p2wsh"
+	case AddressP2TR:
+		return "p2tr"
+	case AddressWitnessUnknown:
+		return "witness_unknown"
+	}
+	return fmt.Sprintf("unknown address type %d", int(a))
+}
+
+type Address struct {
+	addressType AddressType
+	//hash160 for base58 addresses, witness program for segwit ones
+	hash []byte
+	//only for segwit addresses
+	witnessVersion byte
+	params         *ecc.ChainParams
+}
+
+// ParseAddress parses a base58 or bech32(m) address of main-net, testnet, signet or regtest
+func ParseAddress(address string) (*Address, error) {
+	lower := strings.ToLower(address)
+	for _, hrp := range []string{ecc.MainnetHrp, ecc.TestnetHrp, ecc.RegtestHrp} {
+		if strings.HasPrefix(lower, hrp+"1") {
+			hrp, version, program, err := ecc.DecodeSegwitAddress(address)
+			if err != nil {
+				return nil, err
+			}
+			return NewSegwitAddress(hrp, version, program)
+		}
+	}
+
+	payload, err := ecc.DecodeBase58Check(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownAddress, err)
+	}
+	return parseBase58Address(payload)
+}
+
+func parseBase58Address(payload []byte) (*Address, error) {
+	if len(payload) != 21 {
+		return nil, ErrAddressPayloadLength
+	}
+	address := &Address{hash: payload[1:]}
+	for _, params := range []*ecc.ChainParams{ecc.MainNetParams, ecc.TestNet3Params} {
+		switch payload[0] {
+		case params.PubKeyHashAddrID():
+			address.addressType, address.params = AddressP2PKH, params
+			return address, nil
+		case params.ScriptHashAddrID():
+			address.addressType, address.params = AddressP2SH, params
+			return address, nil
+		}
+	}
+	return nil, ErrAddressVersion
+}
+
+// NewSegwitAddress the address of a witness program, hrp is bc, tb or bcrt
+func NewSegwitAddress(hrp string, version byte, program []byte) (*Address, error) {
+	address := &Address{
+		hash:           program,
+		witnessVersion: version,
+	}
+	for _, params := range []*ecc.ChainParams{ecc.MainNetParams, ecc.TestNet3Params, ecc.RegTestParams} {
+		if params.Bech32Hrp() == hrp {
+			address.params = params
+		}
+	}
+	if address.params == nil {
+		return nil, ErrAddressHrp
+	}
+	//checks the witness version and program length
+	if _, err := ecc.EncodeSegwitAddress(hrp, version, program); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version == 0 && len(program) == 20:
+		address.addressType = AddressP2WPKH
+	case version == 0:
+		address.addressType = AddressP2WSH
+	case version == 1 && len(program) == 32:
+		address.addressType = AddressP2TR
+	default:
+		address.addressType = AddressWitnessUnknown
+	}
+	return address, nil
+}
+
+/*
+AddressFromScript
+the address paying to scriptPubKey, for showing the receiver of an output.
+Scripts without address (bare multisig, OP_RETURN...) give ErrNonStandardScript
+*/
+func AddressFromScript(scriptPubKey *ScriptSig, params *ecc.ChainParams) (*Address, error) {
+	//the templates are matched on the bytes, a push which is not minimal is another script
+	raw := scriptPubKey.raw
+	if len(raw) == 25 && raw[0] == OP_DUP && raw[1] == OP_HASH160 && raw[2] == 20 &&
+		raw[23] == OP_EQUALVERIFY && raw[24] == OP_CHECKSIG {
+		return &Address{addressType: AddressP2PKH, hash: raw[3:23], params: params}, nil
+	}
+	if isP2shScript(raw) {
+		return &Address{addressType: AddressP2SH, hash: raw[2:22], params: params}, nil
+	}
+	if version, program, ok := witnessProgram(raw); ok {
+		address, err := NewSegwitAddress(params.Bech32Hrp(), byte(version), program)
+		if err == nil {
+			//signet and testnet4 share tb with testnet3, keep the network asked for
+			address.params = params
+			return address, nil
+		}
+	}
+	return nil, ErrNonStandardScript
+}
+
+func (a *Address) Type() AddressType {
+	return a.addressType
+}
+
+// ChainParams the network of the address, see the package doc for the test networks
+func (a *Address) ChainParams() *ecc.ChainParams {
+	return a.params
+}
+
+// Hash hash160 of P2PKH and P2SH, witness program of segwit addresses
+func (a *Address) Hash() []byte {
+	return append([]byte{}, a.hash...)
+}
+
+func (a *Address) IsSegwit() bool {
+	return a.addressType >= AddressP2WPKH
+}
+
+// WitnessVersion only meaningful for segwit addresses
+func (a *Address) WitnessVersion() byte {
+	return a.witnessVersion
+}
+
+// ScriptPubKey the locking script of an output paying to this address
+func (a *Address) ScriptPubKey() *ScriptSig {
+	switch a.addressType {
+	case AddressP2PKH:
+		return P2pkhScrip(a.hash)
+	case AddressP2SH:
+		return P2shScript(a.hash)
+	}
+	op := byte(OP_0)
+	if a.witnessVersion > 0 {
+		op = OP_1 + a.witnessVersion - 1
+	}
+	return InitScriptSig([][]byte{[]byte{op}, a.hash})
+}
+
+func (a *Address) String() string {
+	if a.IsSegwit() {
+		address, err := ecc.EncodeSegwitAddress(a.params.Bech32Hrp(), a.witnessVersion, a.hash)
+		if err != nil {
+			panic(fmt.Sprintf("encode segwit address err: %v\n", err))
+		}
+		return address
+	}
+	prefix := a.params.ScriptHashAddrID()
+	if a.addressType == AddressP2PKH {
+		prefix = a.params.PubKeyHashAddrID()
+	}
+	return ecc.Base58Checksum(append([]byte{prefix}, a.hash...))
+}
+
+func (a *Address) Equal(other *Address) bool {
+	return a.addressType == other.addressType && a.witnessVersion == other.witnessVersion &&
+		bytes.Equal(a.hash, other.hash) && a.String() == other.String()
+}
+
+// P2wshProgram sha256 of the witness script, the program of its P2WSH address
+func P2wshProgram(witnessScript *ScriptSig) []byte {
+	h := sha256.Sum256(witnessScript.rawSerialize())
+	return h[:]
+}
@@ -0,0 +1,255 @@
+package transaction
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+/*
+BIP 322 generic signed message
+
+instead of signing the message hash directly like BIP 137, we prove we could
+spend a (virtual) output locked by the address script, so any script the
+interpreter can run works, not only P2PKH.
+
+to_spend is a transaction that can never be valid:
+version 0, lock time 0
+one input: previous tx 000...000, index 0xffffffff, sequence 0,
+scriptSig OP_0 PUSH32[tagged_hash("BIP0322-signed-message", message)]
+one output: amount 0, scriptPubKey = the script of the address (message challenge)
+
+to_sign spends it:
+version 0, lock time 0
+one input: previous tx to_spend, index 0, sequence 0, scriptSig and witness
+holding the proof
+one output: amount 0, scriptPubKey OP_RETURN
+
+the "simple" signature is the witness stack of the to_sign input only, encoded
+in base64, it works for segwit scripts. The "full" signature is the whole
+to_sign transaction encoded in base64, legacy scripts need it since their proof
+lives in the scriptSig.
+*/
+
+var (
+	ErrBIP322UnsupportedScript = errors.New("bip322 signing supports P2PKH, P2WPKH and P2TR key path only")
+	ErrBIP322KeyMismatch       = errors.New("bip322 message challenge is not locked by the given private key")
+	ErrBIP322SimpleLegacy      = errors.New("bip322 simple signature can not prove a legacy script, use full signature")
+	ErrBIP322ToSign            = errors.New("bip322 full signature is not a to_sign transaction of the message")
+)
+
+func BIP322MessageHash(message string) []byte {
+	return ecc.TaggedHash("BIP0322-signed-message", []byte(message))
+}
+
+func BIP322ToSpend(messageChallenge *ScriptSig, message string) *Transaction {
+	txIn := InitTransactionInput(make([]byte, 32), big.NewInt(int64(0xffffffff)))
+	txIn.SetSequence(big.NewInt(0))
+	txIn.SetScriptSig(InitScriptSig([][]byte{[]byte{OP_0}, BIP322MessageHash(message)}))
+	txOut := InitTransactionOutput(big.NewInt(0), messageChallenge)
+	return InitTransaction(big.NewInt(0), []*TransactionInput{txIn}, []*TransactionOutput{txOut},
+		big.NewInt(0), nil)
+}
+
+// BIP322ToSign the unsigned to_sign transaction, its input carries the to_spend output
+func BIP322ToSign(toSpend *Transaction) *Transaction {
+	txIn := InitTransactionInput(toSpend.Hash(), big.NewInt(0))
+	txIn.SetSequence(big.NewInt(0))
+	txIn.SetScriptSig(InitScriptSig([][]byte{}))
+	txIn.SetPreviousOutput(toSpend.txOutputs[0])
+	txOut := InitTransactionOutput(big.NewInt(0), InitScriptSig([][]byte{[]byte{OP_RETURN}}))
+	return InitTransaction(big.NewInt(0), []*TransactionInput{txIn}, []*TransactionOutput{txOut},
+		big.NewInt(0), nil)
+}
+
+func sameScript(a *ScriptSig, b *ScriptSig) bool {
+	return bytes.Equal(a.Serialize(), b.Serialize())
+}
+
+/*
+signBIP322 fills the proof into the to_sign input, legacy tells the proof is in
+the scriptSig
+*/
+func signBIP322(privateKey *ecc.PrivateKey, messageChallenge *ScriptSig, message string) (*Transaction, bool, error) {
+	toSign := BIP322ToSign(BIP322ToSpend(messageChallenge, message))
+	txIn := toSign.txInputs[0]
+	pubKey := privateKey.GetPublicKey()
+	_, compressedSec := pubKey.Sec(true)
+	_, uncompressedSec := pubKey.Sec(false)
+
+	switch {
+	case toSign.IsP2WPKH(messageChallenge) && len(messageChallenge.bitcoinOpCode.commands[1]) == 20:
+		if !sameScript(messageChallenge, P2wpkhScript(ecc.Hash160(compressedSec))) {
+			return nil, false, ErrBIP322KeyMismatch
+		}
+		sigHash, err := toSign.BIP143SigHash(0)
+		if err != nil {
+			return nil, false, err
+		}
+		z := new(big.Int).SetBytes(sigHash)
+		der := privateKey.Sign(z).Der()
+		txIn.SetWitness([][]byte{append(der, SIGHASH_ALL), compressedSec})
+		toSign.SetSegwit()
+		return toSign, false, nil
+
+	case toSign.IsP2TR(messageChallenge):
+		outputKey := ecc.TaprootOutputKey(pubKey, nil)
+		if !sameScript(messageChallenge, P2trScript(outputKey.XOnly())) {
+			return nil, false, ErrBIP322KeyMismatch
+		}
+		aux := make([]byte, 32)
+		if _, err := rand.Read(aux); err != nil {
+			return nil, false, err
+		}
+		sigHash, err := toSign.TaprootSigHash(0, SIGHASH_DEFAULT)
+		if err != nil {
+			return nil, false, err
+		}
+		sig := privateKey.TaprootTweak(nil).SignSchnorr(sigHash, aux)
+		txIn.SetWitness([][]byte{sig.Serialize()})
+		toSign.SetSegwit()
+		return toSign, false, nil
+	}
+
+	var sec []byte
+	if sameScript(messageChallenge, P2pkhScrip(ecc.Hash160(compressedSec))) {
+		sec = compressedSec
+	} else if sameScript(messageChallenge, P2pkhScrip(ecc.Hash160(uncompressedSec))) {
+		sec = uncompressedSec
+	} else {
+		return nil, false, ErrBIP322UnsupportedScript
+	}
+	sigHash, err := toSign.SignHash(0)
+	if err != nil {
+		return nil, false, err
+	}
+	z := new(big.Int).SetBytes(sigHash)
+	der := privateKey.Sign(z).Der()
+	txIn.SetScriptSig(InitScriptSig([][]byte{append(der, SIGHASH_ALL), sec}))
+	return toSign, true, nil
+}
+
+// SignBIP322Simple base64 of the witness stack, for P2WPKH and P2TR message challenges
+func SignBIP322Simple(privateKey *ecc.PrivateKey, messageChallenge *ScriptSig, message string) (string, error) {
+	toSign, legacy, err := signBIP322(privateKey, messageChallenge, message)
+	if err != nil {
+		return "", err
+	}
+	if legacy {
+		return "", ErrBIP322SimpleLegacy
+	}
+	return base64.StdEncoding.EncodeToString(encodeWitness(toSign.txInputs[0].witness)), nil
+}
+
+// SignBIP322Full base64 of the whole to_sign transaction, for P2PKH, P2WPKH and P2TR message challenges
+func SignBIP322Full(privateKey *ecc.PrivateKey, messageChallenge *ScriptSig, message string) (string, error) {
+	toSign, _, err := signBIP322(privateKey, messageChallenge, message)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(toSign.Serialize()), nil
+}
+
+/*
+VerifyBIP322Simple
+whether the witness stack in signature proves the message for the challenge,
+the error is for a signature which can not be decoded or a script the
+interpreter does not support, a proof that does not hold is false without error
+*/
+func VerifyBIP322Simple(messageChallenge *ScriptSig, message string, signature string) (bool, error) {
+	witnessBin, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+	witness, err := parseWitness(witnessBin)
+	if err != nil {
+		return false, err
+	}
+
+	toSign := BIP322ToSign(BIP322ToSpend(messageChallenge, message))
+	toSign.txInputs[0].SetWitness(witness)
+	toSign.SetSegwit()
+	return verifyBIP322ToSign(toSign)
+}
+
+/*
+VerifyBIP322Full
+signature is a to_sign transaction, version, lock time and sequence are up to
+the signer (time locked scripts need them), everything else must match BIP 322
+or the error is ErrBIP322ToSign. Proof of funds, that is more inputs after the
+first one, is not supported.
+*/
+func VerifyBIP322Full(messageChallenge *ScriptSig, message string, signature string) (bool, error) {
+	txBin, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+	toSign, err := ParseTransaction(txBin)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(toSign.Serialize(), txBin) {
+		return false, ErrBIP322ToSign
+	}
+
+	toSpend := BIP322ToSpend(messageChallenge, message)
+	if len(toSign.txInputs) != 1 || len(toSign.txOutputs) != 1 {
+		return false, ErrBIP322ToSign
+	}
+	txIn := toSign.txInputs[0]
+	if !bytes.Equal(txIn.previousTransactionID, toSpend.Hash()) || txIn.previousTransactionIndex.Sign() != 0 {
+		return false, ErrBIP322ToSign
+	}
+	txOut := toSign.txOutputs[0]
+	if txOut.amount.Sign() != 0 || !sameScript(txOut.scriptPubKey, InitScriptSig([][]byte{[]byte{OP_RETURN}})) {
+		return false, ErrBIP322ToSign
+	}
+
+	txIn.SetPreviousOutput(toSpend.txOutputs[0])
+	return verifyBIP322ToSign(toSign)
+}
+
+// verifyBIP322ToSign runs the to_sign input through the interpreter with the standard rules BIP 322 asks for
+func verifyBIP322ToSign(toSign *Transaction) (bool, error) {
+	return toSign.VerifyInputWithFlags(0, ScriptVerifyPolicy)
+}
+
+// encodeWitness item count then every item with its length, the same as in a segwit transaction
+func encodeWitness(witness [][]byte) []byte {
+	result := EncodeVariant(big.NewInt(int64(len(witness))))
+	for _, item := range witness {
+		result = append(result, EncodeVariant(big.NewInt(int64(len(item))))...)
+		result = append(result, item...)
+	}
+	return result
+}
+
+func parseWitness(witnessBin []byte) ([][]byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(witnessBin))
+	count, err := ReadVariant(reader)
+	if err != nil {
+		return nil, NewParseError("witness item count", err)
+	}
+	items := make([][]byte, 0)
+	for i := int64(0); i < count.Int64(); i++ {
+		itemLen, err := ReadVariant(reader)
+		if err != nil {
+			return nil, NewParseError("witness item length", err)
+		}
+		item, err := ReadField(reader, itemLen.Uint64(), "witness item")
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		return nil, ErrTrailingData
+	}
+	return items, nil
+}
@@ -1,7 +1,6 @@
 package transaction
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
@@ -22,9 +21,15 @@ const (
 	TWO_WEEKS = 60 * 60 * 24 * 14
 )
 
-func ComputeNewTarget(firstBlockBytes []byte, lastBlockBytes []byte) *big.Int {
-	firstBlock := ParseBlock(firstBlockBytes)
-	lastBlock := ParseBlock(lastBlockBytes)
+func ComputeNewTarget(firstBlockBytes []byte, lastBlockBytes []byte) (*big.Int, error) {
+	firstBlock, err := ParseBlock(firstBlockBytes)
+	if err != nil {
+		return nil, err
+	}
+	lastBlock, err := ParseBlock(lastBlockBytes)
+	if err != nil {
+		return nil, err
+	}
 
 	firstBlockTime := new(big.Int)
 	firstBlockTime.SetBytes(firstBlock.timeStamp)
@@ -44,7 +49,7 @@ func ComputeNewTarget(firstBlockBytes []byte, lastBlockBytes []byte) *big.Int {
 	var opMul big.Int
 	var opDiv big.Int
 	newTarget := opDiv.Div(opMul.Mul(lastBlock.Target(), timeDifferential), big.NewInt(TWO_WEEKS))
-	return newTarget
+	return newTarget, nil
 }
 
 func TargetToBits(target *big.Int) []byte {
@@ -57,57 +62,81 @@ func TargetToBits(target *big.Int) []byte {
 	return bits
 }
 
-func ParseBlock(rawBlock []byte) *Block {
-	block := &Block{}
-
-	reader := bytes.NewReader(rawBlock)
-	bufReader := bufio.NewReader(reader)
-
-	buffer := make([]byte, 4)
-	io.ReadFull(bufReader, buffer)
-	block.version = reverseByteSlice(buffer)
-
-	buffer = make([]byte, 32)
-	io.ReadFull(bufReader, buffer)
-	block.previousBlockID = reverseByteSlice(buffer)
-
-	buffer = make([]byte, 32)
-	io.ReadFull(bufReader, buffer)
-	block.merkleRoot = reverseByteSlice(buffer)
+// BlockHeaderLen a block header is always 80 bytes
+const BlockHeaderLen = 80
 
-	buffer = make([]byte, 4)
-	io.ReadFull(bufReader, buffer)
-	block.timeStamp = reverseByteSlice(buffer)
+// ParseBlock parses the 80 bytes header at the beginning of rawBlock, anything after it is ignored
+func ParseBlock(rawBlock []byte) (*Block, error) {
+	block := &Block{}
+	if err := block.Decode(bytes.NewReader(rawBlock)); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
 
-	buffer = make([]byte, 4)
-	io.ReadFull(bufReader, buffer)
-	block.bits = buffer
+// Decode reads the 80 bytes header from r, see Decoder
+func (b *Block) Decode(r io.Reader) error {
+	var header [BlockHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return NewParseError("block header", err)
+	}
+	b.version = reverseByteSlice(header[0:4])
+	b.previousBlockID = reverseByteSlice(header[4:36])
+	b.merkleRoot = reverseByteSlice(header[36:68])
+	b.timeStamp = reverseByteSlice(header[68:72])
+	b.bits = append([]byte{}, header[72:76]...)
+	b.nonce = append([]byte{}, header[76:80]...)
+	return nil
+}
 
-	buffer = make([]byte, 4)
-	io.ReadFull(bufReader, buffer)
-	block.nonce = buffer
+/*
+DecodeBlockTransactions
+reads the transactions of a full block from r, which is right after the 80
+bytes header. Every transaction is given to handle as soon as it is read and
+may be dropped after, so a block of megabytes is never in memory at once.
+An error from handle stops the reading and is returned
+*/
+func DecodeBlockTransactions(r io.Reader, handle func(idx int, transaction *Transaction) error) error {
+	reader := asWireReader(r)
+	count, err := ReadVariant(reader)
+	if err != nil {
+		return NewParseError("transaction count", err)
+	}
+	for i := 0; i < int(count.Int64()); i++ {
+		transaction := &Transaction{}
+		if err := transaction.Decode(reader); err != nil {
+			return NewParseError(fmt.Sprintf("transaction %d", i), err)
+		}
+		if err := handle(i, transaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return block
+// SerializeSize always BlockHeaderLen
+func (b *Block) SerializeSize() int {
+	return BlockHeaderLen
 }
 
-func (b *Block) Serialize() []byte {
-	result := make([]byte, 0)
+func (b *Block) encode(ww *wireWriter) {
 	//four bytes version in little endian format
-	version := new(big.Int)
-	version.SetBytes(b.version)
-	result = append(result, BigIntToLittleEndian(version, LittleEndian4Bytes)...)
+	ww.reversed(b.version)
 	//previous block header hash and merkle root in little endian
-	result = append(result, reverseByteSlice(b.previousBlockID)...)
-	result = append(result, reverseByteSlice(b.merkleRoot)...)
-
-	timeStamp := new(big.Int)
-	timeStamp.SetBytes(b.timeStamp)
-	result = append(result, BigIntToLittleEndian(timeStamp, LittleEndian4Bytes)...)
+	ww.reversed(b.previousBlockID)
+	ww.reversed(b.merkleRoot)
+	ww.reversed(b.timeStamp)
+	ww.write(b.bits)
+	ww.write(b.nonce)
+}
 
-	result = append(result, b.bits...)
-	result = append(result, b.nonce...)
+// Encode see Encoder
+func (b *Block) Encode(w io.Writer) error {
+	return encodeWire(w, b.encode)
+}
 
-	return result
+func (b *Block) Serialize() []byte {
+	return EncodeToBytes(b)
 }
 
 func (b *Block) Hash() []byte {
@@ -0,0 +1,177 @@
+package transaction
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+Encoder and Decoder
+the streaming form of Serialize and the Parse functions.
+
+Encode writes the wire format straight to w, a transaction or a block can go
+to a socket, a file or a hash without being built as one []byte first, and
+SerializeSize is the exact number of bytes Encode writes, so whoever needs
+the bytes (Serialize) allocates them once instead of growing by append.
+
+Decode reads exactly one message from r and not a byte more, the next
+message is still in r for the following Decode. Scripts and varints are read
+byte by byte, when r is a file or a socket wrap it in a bufio.Reader.
+*/
+type Encoder interface {
+	Encode(w io.Writer) error
+	SerializeSize() int
+}
+
+type Decoder interface {
+	Decode(r io.Reader) error
+}
+
+// wireReader what the decoders read from, see asWireReader
+type wireReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// oneByteReader gives ReadByte to a plain reader without reading ahead of the message
+type oneByteReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (o *oneByteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(o.Reader, o.buf[:]); err != nil {
+		return 0, err
+	}
+	return o.buf[0], nil
+}
+
+func asWireReader(r io.Reader) wireReader {
+	if reader, ok := r.(wireReader); ok {
+		return reader
+	}
+	return &oneByteReader{Reader: r}
+}
+
+var writerPool = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, 4096)
+	},
+}
+
+/*
+EncodeBuffered
+runs encode on a pooled bufio.Writer in front of w, the many small writes of
+a message become a few large ones on the socket or file. Writers buffering by
+themselves (bytes.Buffer, bufio.Writer) are given to encode as they are
+*/
+func EncodeBuffered(w io.Writer, encode func(w io.Writer) error) error {
+	if _, ok := w.(io.ByteWriter); ok {
+		return encode(w)
+	}
+	bufWriter := writerPool.Get().(*bufio.Writer)
+	bufWriter.Reset(w)
+	defer func() {
+		//do not keep w alive from the pool
+		bufWriter.Reset(nil)
+		writerPool.Put(bufWriter)
+	}()
+	if err := encode(bufWriter); err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+// EncodeToBytes the bytes Encode writes, allocated once with SerializeSize
+func EncodeToBytes(e Encoder) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, e.SerializeSize()))
+	if err := e.Encode(buf); err != nil {
+		//bytes.Buffer never fails a write
+		panic(fmt.Sprintf("encode to bytes err: %v\n", err))
+	}
+	return buf.Bytes()
+}
+
+// VarIntSize the number of bytes EncodeVariant gives for v
+func VarIntSize(v uint64) int {
+	switch {
+	case v < 0xfd:
+		return 1
+	case v <= 0xffff:
+		return 3
+	case v <= 0xffffffff:
+		return 5
+	}
+	return 9
+}
+
+/*
+wireWriter
+keeps the first write error, so an encoder writes field after field and
+checks once at the end
+*/
+type wireWriter struct {
+	w       io.Writer
+	err     error
+	scratch [9]byte
+}
+
+func encodeWire(w io.Writer, encode func(ww *wireWriter)) error {
+	return EncodeBuffered(w, func(w io.Writer) error {
+		ww := &wireWriter{w: w}
+		encode(ww)
+		return ww.err
+	})
+}
+
+func (ww *wireWriter) write(data []byte) {
+	if ww.err != nil {
+		return
+	}
+	_, ww.err = ww.w.Write(data)
+}
+
+// reversed writes data from its last byte to the first, for ids kept in big endian
+func (ww *wireWriter) reversed(data []byte) {
+	for i := len(data) - 1; i >= 0; i-- {
+		ww.scratch[0] = data[i]
+		ww.write(ww.scratch[:1])
+	}
+}
+
+func (ww *wireWriter) uint32LE(v uint64) {
+	binary.LittleEndian.PutUint32(ww.scratch[:4], uint32(v))
+	ww.write(ww.scratch[:4])
+}
+
+func (ww *wireWriter) uint64LE(v uint64) {
+	binary.LittleEndian.PutUint64(ww.scratch[:8], v)
+	ww.write(ww.scratch[:8])
+}
+
+func (ww *wireWriter) varInt(v uint64) {
+	switch VarIntSize(v) {
+	case 1:
+		ww.scratch[0] = byte(v)
+	case 3:
+		ww.scratch[0] = 0xfd
+		binary.LittleEndian.PutUint16(ww.scratch[1:3], uint16(v))
+	case 5:
+		ww.scratch[0] = 0xfe
+		binary.LittleEndian.PutUint32(ww.scratch[1:5], uint32(v))
+	default:
+		ww.scratch[0] = 0xff
+		binary.LittleEndian.PutUint64(ww.scratch[1:9], v)
+	}
+	ww.write(ww.scratch[:VarIntSize(v)])
+}
+
+// varBytes the length of data as varint and then data
+func (ww *wireWriter) varBytes(data []byte) {
+	ww.varInt(uint64(len(data)))
+	ww.write(data)
+}
@@ -3,9 +3,12 @@ package transaction
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
 )
 
 type TransactionInput struct {
@@ -16,6 +19,11 @@ type TransactionInput struct {
 	fetcher                  *TransactionFetcher
 	//add new here
 	witness [][]byte
+	/*
+		the output this input spends when we already have it, for example the
+		virtual transactions of BIP 322, then nothing is fetched from network
+	*/
+	previousOutput *TransactionOutput
 }
 
 func InitTransactionInput(previousTx []byte, previousIndex *big.Int) *TransactionInput {
@@ -24,6 +32,7 @@ func InitTransactionInput(previousTx []byte, previousIndex *big.Int) *Transactio
 		previousTransactionIndex: previousIndex,
 		scriptSig:                nil,
 		sequence:                 big.NewInt(int64(0xffffffff)),
+		fetcher:                  NewTransactionFetch(),
 	}
 }
 
@@ -40,6 +49,23 @@ func (t *TransactionInput) SetString(sig *ScriptSig) {
 	t.scriptSig = sig
 }
 
+func (t *TransactionInput) SetSequence(sequence *big.Int) {
+	t.sequence = sequence
+}
+
+func (t *TransactionInput) SetWitness(witness [][]byte) {
+	t.witness = witness
+}
+
+func (t *TransactionInput) Witness() [][]byte {
+	return t.witness
+}
+
+// SetPreviousOutput gives the output spent by this input, Value and Script will not fetch it anymore
+func (t *TransactionInput) SetPreviousOutput(output *TransactionOutput) {
+	t.previousOutput = output
+}
+
 func reverseByteSlice(bytes []byte) []byte {
 	reverseBytes := []byte{}
 	for i := len(bytes) - 1; i >= 0; i-- {
@@ -49,88 +75,166 @@ func reverseByteSlice(bytes []byte) []byte {
 	return reverseBytes
 }
 
-func NewTractionInput(reader *bufio.Reader) *TransactionInput {
+var (
+	ErrPreviousTxHash      = errors.New("fetched transaction does not have the requested id")
+	ErrPreviousOutputIndex = errors.New("previous transaction has no output at the spent index")
+	ErrRedeemScript        = errors.New("scriptSig of a P2SH input has no redeem script")
+)
+
+func NewTractionInput(reader *bufio.Reader) (*TransactionInput, error) {
+	transactionInput := &TransactionInput{}
+	if err := transactionInput.Decode(reader); err != nil {
+		return nil, err
+	}
+	return transactionInput, nil
+}
+
+// Decode reads outpoint, scriptSig and sequence of one input from r, see Decoder
+func (t *TransactionInput) Decode(r io.Reader) error {
+	reader := asWireReader(r)
 	//the first 32 bytes are hash256 of previous transaction
 	transactionInput := &TransactionInput{}
 	transactionInput.fetcher = NewTransactionFetch()
 
-	previousTransaction := make([]byte, 32)
-	//bug fix
-	//reader.Read(previousTransaction)
-	io.ReadFull(reader, previousTransaction)
+	previousTransaction, err := ReadField(reader, 32, "previous transaction id")
+	if err != nil {
+		return err
+	}
 	//convert it from little endian to big endian
 	//reverse the byte array [0x01, 0x02, 0x03, 0x04] -> [0x04, 0x03, 0x02, 0x01]
 	transactionInput.previousTransactionID = reverseByteSlice(previousTransaction)
 
 	//4 bytes for previous transaction index
-	idx := make([]byte, 4)
-	//bug fix, io.ReadFull
-	//reader.Read(idx)
-	io.ReadFull(reader, idx)
+	idx, err := ReadField(reader, 4, "previous transaction index")
+	if err != nil {
+		return err
+	}
 	transactionInput.previousTransactionIndex = LittleEndianToBigInt(idx, LittleEndian4Bytes)
 
-	transactionInput.scriptSig = NewScriptSig(reader)
+	transactionInput.scriptSig = &ScriptSig{}
+	if err := transactionInput.scriptSig.Decode(reader); err != nil {
+		return err
+	}
 
 	//last four bytes for the sequence
-	seqBytes := make([]byte, 4)
-	//bug fix, io.ReadFull
-	//reader.Read(seqBytes)
-	io.ReadFull(reader, seqBytes)
+	seqBytes, err := ReadField(reader, 4, "sequence")
+	if err != nil {
+		return err
+	}
 	transactionInput.sequence = LittleEndianToBigInt(seqBytes, LittleEndian4Bytes)
 
-	return transactionInput
+	*t = *transactionInput
+	return nil
 }
 
-func (t *TransactionInput) getPreviousTx(testnet bool) *Transaction {
+func (t *TransactionInput) getPreviousTx(params *ecc.ChainParams) (*Transaction, error) {
 	previousTxID := fmt.Sprintf("%x", t.previousTransactionID)
-	previousTX := t.fetcher.Fetch(previousTxID, testnet)
-	tx := ParseTransaction(previousTX)
-	return tx
+	previousTX, err := t.fetcher.Fetch(previousTxID, params)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := ParseTransaction(previousTX)
+	if err != nil {
+		return nil, err
+	}
+	//never trust the server, the transaction must be the one we asked for
+	if !bytes.Equal(tx.Hash(), t.previousTransactionID) {
+		return nil, ErrPreviousTxHash
+	}
+	return tx, nil
 }
 
-func (t *TransactionInput) Value(testnet bool) *big.Int {
-	tx := t.getPreviousTx(testnet)
-
-	return tx.txOutputs[t.previousTransactionIndex.Int64()].amount
+/*
+PreviousOutput
+the output this input spends, it is fetched once and kept, so after a
+successful call Value and Script do not go to the network anymore. Every
+method needing the output spent returns the error of this one
+*/
+func (t *TransactionInput) PreviousOutput(params *ecc.ChainParams) (*TransactionOutput, error) {
+	if t.previousOutput != nil {
+		return t.previousOutput, nil
+	}
+	tx, err := t.getPreviousTx(params)
+	if err != nil {
+		return nil, err
+	}
+	index := t.previousTransactionIndex.Int64()
+	if index >= int64(len(tx.txOutputs)) {
+		return nil, ErrPreviousOutputIndex
+	}
+	t.previousOutput = tx.txOutputs[index]
+	return t.previousOutput, nil
 }
 
-func (t *TransactionInput) Script(testnet bool) *ScriptSig {
-	previousTxID := fmt.Sprintf("%x", t.previousTransactionID)
-	previousTX := t.fetcher.Fetch(previousTxID, testnet)
-	tx := ParseTransaction(previousTX)
+func (t *TransactionInput) Value(params *ecc.ChainParams) (*big.Int, error) {
+	output, err := t.PreviousOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	return output.amount, nil
+}
 
-	scriptPubKey := tx.txOutputs[t.previousTransactionIndex.Int64()].scriptPubKey
-	return t.scriptSig.Add(scriptPubKey)
+func (t *TransactionInput) Script(params *ecc.ChainParams) (*ScriptSig, error) {
+	scriptPubKey, err := t.scriptPubKey(params)
+	if err != nil {
+		return nil, err
+	}
+	return t.scriptSig.Add(scriptPubKey), nil
 }
 
-func (t *TransactionInput) scriptPubKey(testnet bool) *ScriptSig {
-	tx := t.getPreviousTx(testnet)
-	return tx.txOutputs[t.previousTransactionIndex.Int64()].scriptPubKey
+func (t *TransactionInput) scriptPubKey(params *ecc.ChainParams) (*ScriptSig, error) {
+	output, err := t.PreviousOutput(params)
+	if err != nil {
+		return nil, err
+	}
+	return output.scriptPubKey, nil
 }
 
 func (t *TransactionInput) isP2sh(script *ScriptSig) bool {
-	isP2sh := true
-	if len(script.bitcoinOpCode.commands[0]) != 1 || script.bitcoinOpCode.commands[0][0] != OP_HASH160 {
-		isP2sh = false
-	}
+	return isP2shScript(script.raw)
+}
 
-	if len(script.bitcoinOpCode.commands[1]) == 1 {
-		isP2sh = false
+/*
+scriptCode
+the script a signature of this input signs, the scriptPubKey spent or for
+P2SH the redeem script, the last element pushed by the scriptSig
+*/
+func (t *TransactionInput) scriptCode(params *ecc.ChainParams) ([]byte, error) {
+	script, err := t.scriptPubKey(params)
+	if err != nil {
+		return nil, err
 	}
-
-	if len(script.bitcoinOpCode.commands[2]) != 1 || script.bitcoinOpCode.commands[2][0] != OP_EQUAL {
-		isP2sh = false
+	if !t.isP2sh(script) || len(t.scriptSig.bitcoinOpCode.commands) == 0 {
+		return script.raw, nil
 	}
+	commands := t.scriptSig.bitcoinOpCode.commands
+	return commands[len(commands)-1], nil
+}
 
-	return isP2sh
+/*
+witnessProgram
+the version and program of the witness program the input spends, the
+scriptPubKey or for P2SH-P2WPKH and P2SH-P2WSH the redeem script, ok is false
+when it is not a witness program
+*/
+func (t *TransactionInput) witnessProgram(params *ecc.ChainParams) (version int, program []byte, ok bool, err error) {
+	script, err := t.scriptCode(params)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	version, program, ok = witnessProgram(script)
+	return version, program, ok, nil
 }
 
-func (t *TransactionInput) ReplaceWithScriptPubKey(testnet bool) {
+func (t *TransactionInput) ReplaceWithScriptPubKey(params *ecc.ChainParams) error {
 	/*
 		if it is a P2SH transaction, we use the redeem script to replace the
 		scriptSig of the current input
 	*/
-	script := t.scriptPubKey(testnet)
+	script, err := t.scriptPubKey(params)
+	if err != nil {
+		return err
+	}
 	isP2sh := t.isP2sh(script)
 	if isP2sh != true {
 		t.scriptSig = script
@@ -139,22 +243,45 @@ func (t *TransactionInput) ReplaceWithScriptPubKey(testnet bool) {
 			for P2SH, we need to use the redeem script to replace the input script;
 			the redeem script is at the bottom of scriptSig command stack
 		*/
+		if len(t.scriptSig.bitcoinOpCode.commands) == 0 {
+			return ErrRedeemScript
+		}
 		redeemScriptBinary := t.scriptSig.bitcoinOpCode.commands[len(t.scriptSig.bitcoinOpCode.commands)-1]
 		//bug here, append total length to the head
-		redeemScriptBinary = append([]byte{byte(len(redeemScriptBinary))}, redeemScriptBinary...)
+		redeemScriptBinary = append(EncodeVariant(big.NewInt(int64(len(redeemScriptBinary)))), redeemScriptBinary...)
 		redeemScriptReader := bytes.NewReader(redeemScriptBinary)
-		redeemScript := NewScriptSig(bufio.NewReader(redeemScriptReader))
+		redeemScript, err := NewScriptSig(bufio.NewReader(redeemScriptReader))
+		if err != nil {
+			return fmt.Errorf("parse redeem script: %w", err)
+		}
 		t.scriptSig = redeemScript
 	}
+	return nil
+}
+
+// outpoint previous transaction id in little endian and 4 bytes output index
+func (t *TransactionInput) outpoint() []byte {
+	result := reverseByteSlice(t.previousTransactionID)
+	return append(result, BigIntToLittleEndian(t.previousTransactionIndex, LittleEndian4Bytes)...)
+}
+
+// SerializeSize the length of Serialize, witness data is not part of the input
+func (t *TransactionInput) SerializeSize() int {
+	return 32 + 4 + t.scriptSig.SerializeSize() + 4
+}
+
+func (t *TransactionInput) encode(ww *wireWriter) {
+	ww.reversed(t.previousTransactionID)
+	ww.uint32LE(t.previousTransactionIndex.Uint64())
+	t.scriptSig.encode(ww)
+	ww.uint32LE(t.sequence.Uint64())
+}
 
+// Encode see Encoder
+func (t *TransactionInput) Encode(w io.Writer) error {
+	return encodeWire(w, t.encode)
 }
 
 func (t *TransactionInput) Serialize() []byte {
-	result := make([]byte, 0)
-	result = append(result, reverseByteSlice(t.previousTransactionID)...)
-	result = append(result,
-		BigIntToLittleEndian(t.previousTransactionIndex, LittleEndian4Bytes)...)
-	result = append(result, t.scriptSig.Serialize()...)
-	result = append(result, BigIntToLittleEndian(t.sequence, LittleEndian4Bytes)...)
-	return result
+	return EncodeToBytes(t)
 }
@@ -0,0 +1,111 @@
+package transaction
+
+/*
+Lock times
+
+the lock time of a transaction (BIP 65 checks it in scripts) is absolute, a
+value below LockTimeThreshold is a block height and any other a unix time,
+the transaction can not be in a block before it. The lock time is only
+enforced when an input has a sequence other than SequenceFinal.
+
+the sequence of an input is a relative lock time (BIP 68) in a transaction of
+version 2 or more, the input can not be in a block before the output it spends
+is old enough:
+
+	bit 31     SequenceLockTimeDisableFlag, the sequence is not a lock time
+	bit 22     SequenceLockTimeTypeFlag, the value counts units of 512
+	           seconds instead of blocks
+	bits 0-15  SequenceLockTimeMask, the value
+
+OP_CHECKSEQUENCEVERIFY (BIP 112) checks the sequence of the input in scripts.
+Since BIP 113 the time of a block is the median time of the 11 blocks before it.
+*/
+
+const (
+	LockTimeThreshold = 500000000
+
+	SequenceFinal                    = 0xffffffff
+	SequenceLockTimeDisableFlag      = 1 << 31
+	SequenceLockTimeTypeFlag         = 1 << 22
+	SequenceLockTimeMask             = 0x0000ffff
+	SequenceLockTimeGranularity      = 9
+	sequenceLockTimeTypeAndValueMask = SequenceLockTimeTypeFlag | SequenceLockTimeMask
+)
+
+/*
+RelativeLockTime
+what the sequence of the input asks for, a number of blocks or seconds after
+the output spent was confirmed. enabled is false when the sequence has the
+disable flag or the transaction has a version below 2
+*/
+func (t *Transaction) RelativeLockTime(inputIdx int) (lockTime int64, inSeconds bool, enabled bool) {
+	sequence := t.txInputs[inputIdx].sequence.Int64()
+	if t.version.Uint64() < 2 || sequence&SequenceLockTimeDisableFlag != 0 {
+		return 0, false, false
+	}
+	value := sequence & SequenceLockTimeMask
+	if sequence&SequenceLockTimeTypeFlag != 0 {
+		return value << SequenceLockTimeGranularity, true, true
+	}
+	return value, false, true
+}
+
+/*
+IsFinal
+whether the transaction can be in the block at height with time blockTime, its
+lock time has passed or every input opts out of it with SequenceFinal
+*/
+func (t *Transaction) IsFinal(height int64, blockTime int64) bool {
+	lockTime := t.lockTime.Int64()
+	if lockTime == 0 {
+		return true
+	}
+	limit := height
+	if lockTime >= LockTimeThreshold {
+		limit = blockTime
+	}
+	if lockTime < limit {
+		return true
+	}
+	for _, txIn := range t.txInputs {
+		if txIn.sequence.Int64() != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+checkLockTime
+OP_CHECKLOCKTIMEVERIFY, the transaction lock time is a height or a time as
+lockTime is and not before it. The lock time of a transaction is not enforced
+when the input is final, so it must not be
+*/
+func (t *Transaction) checkLockTime(inputIdx int, lockTime int64) bool {
+	txLockTime := t.lockTime.Int64()
+	if (txLockTime < LockTimeThreshold) != (lockTime < LockTimeThreshold) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+	return t.txInputs[inputIdx].sequence.Int64() != SequenceFinal
+}
+
+/*
+checkSequence
+OP_CHECKSEQUENCEVERIFY, the relative lock time of the input counts the same
+unit as sequence does and not less of it
+*/
+func (t *Transaction) checkSequence(inputIdx int, sequence int64) bool {
+	txSequence := t.txInputs[inputIdx].sequence.Int64()
+	if t.version.Uint64() < 2 || txSequence&SequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+	txMasked := txSequence & sequenceLockTimeTypeAndValueMask
+	masked := sequence & sequenceLockTimeTypeAndValueMask
+	if (txMasked < SequenceLockTimeTypeFlag) != (masked < SequenceLockTimeTypeFlag) {
+		return false
+	}
+	return masked <= txMasked
+}
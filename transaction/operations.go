@@ -1,11 +1,13 @@
 package transaction
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
-	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+	"crypto/sha1"
+	"crypto/sha256"
 	"math/big"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+	"golang.org/x/crypto/ripemd160"
 )
 
 const (
@@ -13,6 +15,7 @@ const (
 )
 const (
 	OP_1NEGATE = iota + 79
+	OP_RESERVED
 )
 const (
 	OP_1 = iota + 81
@@ -32,17 +35,22 @@ const (
 	OP_15
 	OP_16
 	OP_NOP
+	OP_VER
 )
 
 const (
 	OP_IF = iota + 99
-	OP_NOTIf
+	OP_NOTIF
+	OP_VERIF
+	OP_VERNOTIF
+	OP_ELSE
+	OP_ENDIF
 )
 
 const (
 	OP_VERIFY = iota + 105
 	OP_RETURN
-	OP_TOTALSTACK
+	OP_TOALTSTACK
 	OP_FROMALTSTACK
 	OP_2DROP
 	OP_2DUP
@@ -64,17 +72,29 @@ const (
 )
 
 const (
-	OP_SIZE = iota + 130
+	OP_CAT = iota + 126
+	OP_SUBSTR
+	OP_LEFT
+	OP_RIGHT
+	OP_SIZE
+	OP_INVERT
+	OP_AND
+	OP_OR
+	OP_XOR
 )
 
 const (
 	OP_EQUAL = iota + 135
 	OP_EQUALVERIFY
+	OP_RESERVED1
+	OP_RESERVED2
 )
 
 const (
 	OP_1ADD = iota + 139
 	OP_1SUB
+	OP_2MUL
+	OP_2DIV
 )
 
 const (
@@ -85,6 +105,10 @@ const (
 	OP_ADD
 	OP_SUB
 	OP_MUL
+	OP_DIV
+	OP_MOD
+	OP_LSHIFT
+	OP_RSHIFT
 )
 
 const (
@@ -105,15 +129,16 @@ const (
 	OP_SHA256
 	OP_HASH160
 	OP_HASH256
+	OP_CODESEPARATOR
 )
 
 const (
 	OP_CHECKSIG = iota + 172
-	OP_HECKSIGVERIFY
+	OP_CHECKSIGVERIFY
 	OP_CHECKMULTISIG
 	OP_CHECKMULTISIGVERIFY
 	OP_NOP1
-	OP_CHECKLOGTIMEVERIFY
+	OP_CHECKLOCKTIMEVERIFY
 	OP_CHECKSEQUENCEVERIFY
 	OP_NOP4
 	OP_NOP5
@@ -124,16 +149,32 @@ const (
 	OP_NOP10
 )
 
+// the names these opcodes had before, with their typos
 const (
+	// Deprecated: use OP_NOTIF
+	OP_NOTIf = OP_NOTIF
+	// Deprecated: use OP_TOALTSTACK
+	OP_TOTALSTACK = OP_TOALTSTACK
+	// Deprecated: use OP_CHECKSIGVERIFY
+	OP_HECKSIGVERIFY = OP_CHECKSIGVERIFY
+	// Deprecated: use OP_CHECKLOCKTIMEVERIFY
+	OP_CHECKLOGTIMEVERIFY = OP_CHECKLOCKTIMEVERIFY
+)
 
-	/*
-	   this is not a bitcoin script command, it is defined by ourselves,
-	   if we encounter the P2SH pattern on the script stack, that is the
-	   first element is data chunk, the second element is OP_HASH160,
-	   the third element is a chunk of data, the fourth element is
-	   OP_EQUAL, then we will use this command to do P2SH parsing
-	*/
-	OP_P2SH = 254
+// consensus limits of a script, a script going over any of them fails
+const (
+	MaxScriptSize = 10000
+	//the largest element a script can push
+	MaxScriptElementSize = 520
+	//opcodes above OP_16 in a script, the keys of OP_CHECKMULTISIG count as well
+	MaxOpsPerScript = 201
+	//elements on the stack and the alt stack together
+	MaxStackSize          = 1000
+	MaxPubKeysPerMultiSig = 20
+	//numbers taken from the stack are at most 4 bytes, results can be longer
+	maxScriptNumSize = 4
+	//OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY take numbers up to 2^39 - 1
+	lockTimeNumSize = 5
 )
 
 type BitcoinOpCode struct {
@@ -142,6 +183,26 @@ type BitcoinOpCode struct {
 	altStack    [][]byte
 	commands    [][]byte
 	witness     [][]byte
+	flags       ScriptFlags
+	//one entry for each OP_IF not closed yet, whether its branch runs
+	condStack []bool
+	opCount   int
+
+	//the script running, pc is where its next operation begins
+	script []byte
+	pc     int
+	//where the script signed by OP_CHECKSIG begins, after the last OP_CODESEPARATOR run
+	codeSeparator int
+
+	/*
+		the input spending the output, signatures are checked against the
+		sighash of tx for their own hash type, without tx they are checked
+		against the z given to Evaluate
+	*/
+	tx        *Transaction
+	inputIdx  int
+	amount    *big.Int
+	witnessV0 bool
 }
 
 func NewBitCoinOpCode() *BitcoinOpCode {
@@ -151,6 +212,7 @@ func NewBitCoinOpCode() *BitcoinOpCode {
 		77:  "OP_PUSHDATA2",
 		78:  "OP_PUSHDATA4",
 		79:  "OP_1NEGATE",
+		80:  "OP_RESERVED",
 		81:  "OP_1",
 		82:  "OP_2",
 		83:  "OP_3",
@@ -168,8 +230,11 @@ func NewBitCoinOpCode() *BitcoinOpCode {
 		95:  "OP_15",
 		96:  "OP_16",
 		97:  "OP_NOP",
+		98:  "OP_VER",
 		99:  "OP_IF",
 		100: "OP_NOTIF",
+		101: "OP_VERIF",
+		102: "OP_VERNOTIF",
 		103: "OP_ELSE",
 		104: "OP_ENDIF",
 		105: "OP_VERIFY",
@@ -193,11 +258,23 @@ func NewBitCoinOpCode() *BitcoinOpCode {
 		123: "OP_ROT",
 		124: "OP_SWAP",
 		125: "OP_TUCK",
+		126: "OP_CAT",
+		127: "OP_SUBSTR",
+		128: "OP_LEFT",
+		129: "OP_RIGHT",
 		130: "OP_SIZE",
+		131: "OP_INVERT",
+		132: "OP_AND",
+		133: "OP_OR",
+		134: "OP_XOR",
 		135: "OP_EQUAL",
 		136: "OP_EQUALVERIFY",
+		137: "OP_RESERVED1",
+		138: "OP_RESERVED2",
 		139: "OP_1ADD",
 		140: "OP_1SUB",
+		141: "OP_2MUL",
+		142: "OP_2DIV",
 		143: "OP_NEGATE",
 		144: "OP_ABS",
 		145: "OP_NOT",
@@ -205,6 +282,10 @@ func NewBitCoinOpCode() *BitcoinOpCode {
 		147: "OP_ADD",
 		148: "OP_SUB",
 		149: "OP_MUL",
+		150: "OP_DIV",
+		151: "OP_MOD",
+		152: "OP_LSHIFT",
+		153: "OP_RSHIFT",
 		154: "OP_BOOLAND",
 		155: "OP_BOOLOR",
 		156: "OP_NUMEQUAL",
@@ -246,40 +327,166 @@ func NewBitCoinOpCode() *BitcoinOpCode {
 	}
 }
 
-// OP_0, OP_1, OP_2, push the given value on the top of parsing
+// OP_0, OP_1NEGATE, OP_1, OP_2, push the given value on the top of parsing
 func (b *BitcoinOpCode) opNum(op byte) bool {
-	opNum := byte(0)
-	if op >= OP_1 && op <= OP_16 {
-		opNum = (op - OP_1) + 1
+	opNum := int64(0)
+	if op == OP_1NEGATE {
+		opNum = -1
+	} else if op >= OP_1 && op <= OP_16 {
+		opNum = int64(op-OP_1) + 1
 	}
-	b.stack = append(b.stack, b.EncodeNum(int64(opNum)))
+	b.stack = append(b.stack, b.EncodeNum(opNum))
 	return true
 }
 
 /*
-isP2sh
-check the patter for P2SH
+isP2shScript
+check the patter for P2SH, OP_HASH160 <20 bytes> OP_EQUAL and nothing else
+*/
+func isP2shScript(raw []byte) bool {
+	return len(raw) == 23 && raw[0] == OP_HASH160 && raw[1] == 20 && raw[22] == OP_EQUAL
+}
+
+/*
+castToBool
+an element is false when all its bytes are zero, the sign bit of the last
+byte alone is zero as well (negative zero)
+*/
+func castToBool(element []byte) bool {
+	for i, v := range element {
+		if v != 0 {
+			if i == len(element)-1 && v == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BitcoinOpCode) pushBool(value bool) {
+	if value {
+		b.stack = append(b.stack, b.EncodeNum(1))
+	} else {
+		b.stack = append(b.stack, b.EncodeNum(0))
+	}
+}
+
+// top the element n places below the top of the stack, top(0) is the top
+func (b *BitcoinOpCode) top(n int) []byte {
+	return b.stack[len(b.stack)-1-n]
+}
+
+/*
+popNum
+pops a number for the arithmetic operations, they take numbers of at most
+4 bytes though their results can be longer
+*/
+func (b *BitcoinOpCode) popNum() (int64, bool) {
+	if len(b.stack) < 1 {
+		return 0, false
+	}
+	return b.decodeNum(b.popStack(), maxScriptNumSize)
+}
+
+// decodeNum the number of an element of at most maxSize bytes
+func (b *BitcoinOpCode) decodeNum(element []byte, maxSize int) (int64, bool) {
+	if len(element) > maxSize {
+		return 0, false
+	}
+	if b.flags.Has(ScriptVerifyMinimalData) && !isMinimalNum(element) {
+		return 0, false
+	}
+	return b.DecodeNum(element), true
+}
+
+/*
+opCheckLockTimeVerify
+fails unless the transaction lock time has passed the one on the top of the
+stack, which stays there. The lock times go past 4 bytes, so it takes 5
 */
-func (b *BitcoinOpCode) isP2sh() bool {
-	if len(b.commands) == 0 {
+func (b *BitcoinOpCode) opCheckLockTimeVerify() bool {
+	if !b.flags.Has(ScriptVerifyCheckLockTimeVerify) {
+		//OP_NOP2 before BIP 65
+		return true
+	}
+	if len(b.stack) < 1 || b.tx == nil {
 		return false
 	}
-	/*
-		the command stack has three elements; first is OP_HASH160, second is data chunk,
-		the third is OP_EQUAL
-	*/
-	if len(b.commands[0]) != 1 && b.commands[0][0] != OP_HASH160 {
+	lockTime, ok := b.decodeNum(b.top(0), lockTimeNumSize)
+	if !ok || lockTime < 0 {
 		return false
 	}
+	return b.tx.checkLockTime(b.inputIdx, lockTime)
+}
 
-	if len(b.commands[1]) == 1 {
+/*
+opCheckSequenceVerify
+fails unless the relative lock time of the input is at least the one on the
+top of the stack, which stays there. With the disable flag set in the number
+it does nothing, left for soft forks to come
+*/
+func (b *BitcoinOpCode) opCheckSequenceVerify() bool {
+	if !b.flags.Has(ScriptVerifyCheckSequenceVerify) {
+		//OP_NOP3 before BIP 112
+		return true
+	}
+	if len(b.stack) < 1 {
 		return false
 	}
+	sequence, ok := b.decodeNum(b.top(0), lockTimeNumSize)
+	if !ok || sequence < 0 {
+		return false
+	}
+	if sequence&SequenceLockTimeDisableFlag != 0 {
+		return true
+	}
+	return b.tx != nil && b.tx.checkSequence(b.inputIdx, sequence)
+}
+
+// topTrue whether the script succeeded, its stack is not empty and the top element is true
+func (b *BitcoinOpCode) topTrue() bool {
+	return len(b.stack) > 0 && castToBool(b.stack[len(b.stack)-1])
+}
 
-	if len(b.commands[2]) != 1 && b.commands[2][0] != OP_EQUAL {
+// executing whether the current branch of OP_IF runs, it does when all the enclosing ones do
+func (b *BitcoinOpCode) executing() bool {
+	for _, value := range b.condStack {
+		if !value {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BitcoinOpCode) opIf(notIf bool) bool {
+	value := false
+	if b.executing() {
+		if len(b.stack) < 1 {
+			return false
+		}
+		value = castToBool(b.popStack())
+		if notIf {
+			value = !value
+		}
+	}
+	b.condStack = append(b.condStack, value)
+	return true
+}
+
+func (b *BitcoinOpCode) opElse() bool {
+	if len(b.condStack) == 0 {
 		return false
 	}
+	b.condStack[len(b.condStack)-1] = !b.condStack[len(b.condStack)-1]
+	return true
+}
 
+func (b *BitcoinOpCode) opEndIf() bool {
+	if len(b.condStack) == 0 {
+		return false
+	}
+	b.condStack = b.condStack[:len(b.condStack)-1]
 	return true
 }
 
@@ -292,18 +499,234 @@ func (b *BitcoinOpCode) opDup() bool {
 	return true
 }
 
-func (b *BitcoinOpCode) opHash160() bool {
+func (b *BitcoinOpCode) opToAltStack() bool {
 	if len(b.stack) < 1 {
 		return false
 	}
+	b.altStack = append(b.altStack, b.popStack())
+	return true
+}
 
-	element := b.stack[len(b.stack)-1]
-	b.stack = b.stack[0 : len(b.stack)-1]
-	hash160 := ecc.Hash160(element)
-	b.stack = append(b.stack, hash160)
+func (b *BitcoinOpCode) opFromAltStack() bool {
+	if len(b.altStack) < 1 {
+		return false
+	}
+	b.stack = append(b.stack, b.altStack[len(b.altStack)-1])
+	b.altStack = b.altStack[:len(b.altStack)-1]
+	return true
+}
+
+// opDrop OP_DROP and OP_2DROP, removes count elements from the top
+func (b *BitcoinOpCode) opDrop(count int) bool {
+	if len(b.stack) < count {
+		return false
+	}
+	b.stack = b.stack[:len(b.stack)-count]
+	return true
+}
+
+/*
+opCopy
+OP_2DUP, OP_3DUP, OP_OVER and OP_2OVER, pushes a copy of count elements
+starting depth places below the top, keeping their order
+x1 x2 -> x1 x2 x1 x2 is opCopy(2, 2)
+*/
+func (b *BitcoinOpCode) opCopy(count int, depth int) bool {
+	if len(b.stack) < depth {
+		return false
+	}
+	start := len(b.stack) - depth
+	b.stack = append(b.stack, b.stack[start:start+count]...)
+	return true
+}
+
+/*
+opMoveToTop
+OP_NIP, OP_SWAP, OP_ROT, OP_2SWAP and OP_2ROT, takes count elements starting
+depth places below the top out of the stack and pushes them again
+x1 x2 x3 -> x2 x3 x1 is opMoveToTop(1, 3)
+*/
+func (b *BitcoinOpCode) opMoveToTop(count int, depth int) bool {
+	if len(b.stack) < depth {
+		return false
+	}
+	start := len(b.stack) - depth
+	moved := append([][]byte{}, b.stack[start:start+count]...)
+	b.stack = append(b.stack[:start], b.stack[start+count:]...)
+	b.stack = append(b.stack, moved...)
+	return true
+}
+
+func (b *BitcoinOpCode) opNip() bool {
+	if len(b.stack) < 2 {
+		return false
+	}
+	b.stack = append(b.stack[:len(b.stack)-2], b.top(0))
+	return true
+}
+
+func (b *BitcoinOpCode) opTuck() bool {
+	if len(b.stack) < 2 {
+		return false
+	}
+	top := b.top(0)
+	b.stack = append(b.stack[:len(b.stack)-2], top, b.top(1), top)
+	return true
+}
+
+func (b *BitcoinOpCode) opIfDup() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+	if castToBool(b.top(0)) {
+		b.stack = append(b.stack, b.top(0))
+	}
+	return true
+}
+
+// opPick OP_PICK and OP_ROLL, n on the top is how deep is the element to copy or move
+func (b *BitcoinOpCode) opPick(roll bool) bool {
+	n, ok := b.popNum()
+	if !ok || n < 0 || n >= int64(len(b.stack)) {
+		return false
+	}
+	if roll {
+		return b.opMoveToTop(1, int(n)+1)
+	}
+	return b.opCopy(1, int(n)+1)
+}
+
+func (b *BitcoinOpCode) opDepth() bool {
+	b.stack = append(b.stack, b.EncodeNum(int64(len(b.stack))))
+	return true
+}
+
+func (b *BitcoinOpCode) opSize() bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+	b.stack = append(b.stack, b.EncodeNum(int64(len(b.top(0)))))
+	return true
+}
+
+// opUnaryNum OP_1ADD to OP_0NOTEQUAL, replaces the number on the top
+func (b *BitcoinOpCode) opUnaryNum(cmd int) bool {
+	num, ok := b.popNum()
+	if !ok {
+		return false
+	}
+	switch cmd {
+	case OP_1ADD:
+		num += 1
+	case OP_1SUB:
+		num -= 1
+	case OP_NEGATE:
+		num = -num
+	case OP_ABS:
+		if num < 0 {
+			num = -num
+		}
+	case OP_NOT:
+		b.pushBool(num == 0)
+		return true
+	case OP_0NOTEQUAL:
+		b.pushBool(num != 0)
+		return true
+	}
+	b.stack = append(b.stack, b.EncodeNum(num))
+	return true
+}
+
+// opBinaryNum OP_ADD to OP_MAX, a is the element below the top and b the top
+func (b *BitcoinOpCode) opBinaryNum(cmd int) bool {
+	num2, ok := b.popNum()
+	if !ok {
+		return false
+	}
+	num1, ok := b.popNum()
+	if !ok {
+		return false
+	}
+	switch cmd {
+	case OP_ADD:
+		b.stack = append(b.stack, b.EncodeNum(num1+num2))
+	case OP_SUB:
+		b.stack = append(b.stack, b.EncodeNum(num1-num2))
+	case OP_BOOLAND:
+		b.pushBool(num1 != 0 && num2 != 0)
+	case OP_BOOLOR:
+		b.pushBool(num1 != 0 || num2 != 0)
+	case OP_NUMEQUAL:
+		b.pushBool(num1 == num2)
+	case OP_NUMEQUALVERIFY:
+		return num1 == num2
+	case OP_NUMNOTEQUAL:
+		b.pushBool(num1 != num2)
+	case OP_LESSTHAN:
+		b.pushBool(num1 < num2)
+	case OP_GREATERTHAN:
+		b.pushBool(num1 > num2)
+	case OP_LESSTHANOREQUAL:
+		b.pushBool(num1 <= num2)
+	case OP_GREATERTHANOREQUAL:
+		b.pushBool(num1 >= num2)
+	case OP_MIN:
+		b.stack = append(b.stack, b.EncodeNum(min(num1, num2)))
+	case OP_MAX:
+		b.stack = append(b.stack, b.EncodeNum(max(num1, num2)))
+	}
+	return true
+}
+
+// opWithin x min max -> whether min <= x < max
+func (b *BitcoinOpCode) opWithin() bool {
+	maxNum, ok := b.popNum()
+	if !ok {
+		return false
+	}
+	minNum, ok := b.popNum()
+	if !ok {
+		return false
+	}
+	x, ok := b.popNum()
+	if !ok {
+		return false
+	}
+	b.pushBool(minNum <= x && x < maxNum)
 	return true
 }
 
+// opHash OP_RIPEMD160 to OP_HASH256, replaces the top element with its hash
+func (b *BitcoinOpCode) opHash(cmd int) bool {
+	if len(b.stack) < 1 {
+		return false
+	}
+	element := b.popStack()
+	var hash []byte
+	switch cmd {
+	case OP_RIPEMD160:
+		hasher := ripemd160.New()
+		hasher.Write(element)
+		hash = hasher.Sum(nil)
+	case OP_SHA1:
+		sum := sha1.Sum(element)
+		hash = sum[:]
+	case OP_SHA256:
+		sum := sha256.Sum256(element)
+		hash = sum[:]
+	case OP_HASH160:
+		hash = ecc.Hash160(element)
+	case OP_HASH256:
+		hash = ecc.Hash256(string(element))
+	}
+	b.stack = append(b.stack, hash)
+	return true
+}
+
+func (b *BitcoinOpCode) opHash160() bool {
+	return b.opHash(OP_HASH160)
+}
+
 func (b *BitcoinOpCode) opEqual() bool {
 	if len(b.stack) < 2 {
 		return false
@@ -313,11 +736,7 @@ func (b *BitcoinOpCode) opEqual() bool {
 	b.stack = b.stack[0 : len(b.stack)-1]
 	elem2 := b.stack[len(b.stack)-1]
 	b.stack = b.stack[0 : len(b.stack)-1]
-	if bytes.Equal(elem1, elem2) {
-		b.stack = append(b.stack, b.EncodeNum(1))
-	} else {
-		b.stack = append(b.stack, b.EncodeNum(0))
-	}
+	b.pushBool(bytes.Equal(elem1, elem2))
 
 	return true
 }
@@ -327,19 +746,14 @@ func (b *BitcoinOpCode) opVerify() bool {
 		return false
 	}
 
-	elem := b.stack[len(b.stack)-1]
-	b.stack = b.stack[0 : len(b.stack)-1]
-	if b.DecodeNum(elem) == 0 {
-		return false
-	}
-
-	return true
+	return castToBool(b.popStack())
 }
 
 func (b *BitcoinOpCode) opEqualVerify() bool {
-	resEqual := b.opEqual()
-	resVerify := b.opVerify()
-	return resEqual && resVerify
+	if b.opEqual() != true {
+		return false
+	}
+	return b.opVerify()
 }
 
 /*
@@ -358,66 +772,145 @@ func (b *BitcoinOpCode) popStack() []byte {
 	return elem
 }
 
+/*
+scriptCode
+the script a signature signs, the running script from its last
+OP_CODESEPARATOR. Legacy signatures can not sign themselves, so their pushes
+are removed from it (FindAndDelete)
+*/
+func (b *BitcoinOpCode) scriptCode(sigs [][]byte) []byte {
+	scriptCode := b.script[b.codeSeparator:]
+	if b.witnessV0 {
+		return scriptCode
+	}
+	for _, sig := range sigs {
+		scriptCode = findAndDelete(scriptCode, pushData(sig))
+	}
+	return scriptCode
+}
+
+/*
+findAndDelete
+script without every push of target found at the start of an operation, a
+push of the same data with another opcode stays
+*/
+func findAndDelete(script []byte, target []byte) []byte {
+	result := make([]byte, 0, len(script))
+	found := false
+	start, pos := 0, 0
+	for {
+		result = append(result, script[start:pos]...)
+		for len(script)-pos >= len(target) && bytes.Equal(script[pos:pos+len(target)], target) {
+			pos += len(target)
+			found = true
+		}
+		start = pos
+		_, next, err := readScriptOp(script, pos)
+		if err != nil {
+			break
+		}
+		pos = next
+	}
+	if !found {
+		return script
+	}
+	return append(result, script[start:]...)
+}
+
+// sigHash the message sigBin signs, see scriptCode
+func (b *BitcoinOpCode) sigHash(sigBin []byte, scriptCode []byte, zBin []byte) []byte {
+	if b.tx == nil {
+		return zBin
+	}
+	hashType := uint32(sigBin[len(sigBin)-1])
+	if b.witnessV0 {
+		return b.tx.WitnessV0SigHash(b.inputIdx, scriptCode, b.amount, hashType)
+	}
+	return b.tx.LegacySigHash(b.inputIdx, scriptCode, hashType)
+}
+
+/*
+verifySignature
+whether sigBin signs the script code with the sec public key pubKey, an error
+fails the whole script, see checkSignatureEncoding. A key which is not a
+valid point never matches a signature
+*/
+func (b *BitcoinOpCode) verifySignature(sigBin []byte, pubKey []byte, scriptCode []byte, zBin []byte) (bool, error) {
+	sig, err := checkSignatureEncoding(sigBin, b.flags)
+	if err != nil || sig == nil {
+		return false, err
+	}
+	point := parsePubKey(pubKey)
+	if point == nil {
+		return false, nil
+	}
+
+	z := new(big.Int)
+	z.SetBytes(b.sigHash(sigBin, scriptCode, zBin))
+	n := ecc.GetBitcoinValueN()
+	zField := ecc.NewFieldElement(n, z)
+	return point.Verify(zField, sig), nil
+}
+
 func (b *BitcoinOpCode) opCheckMultiSig(zBin []byte) bool {
-	if len(b.stack) < 1 {
+	//read the top element to get the number of public keys
+	pubKeyCounts, ok := b.popNum()
+	if !ok || pubKeyCounts < 0 || pubKeyCounts > MaxPubKeysPerMultiSig {
 		return false
 	}
-	//read the top element to get the number of public keys
-	pubKeyCounts := int(b.DecodeNum(b.popStack()))
-	if len(b.stack) < pubKeyCounts+1 {
+	b.opCount += int(pubKeyCounts)
+	if b.opCount > MaxOpsPerScript {
+		return false
+	}
+	if len(b.stack) < int(pubKeyCounts) {
 		return false
 	}
 	secPubKeys := make([][]byte, 0)
-	for i := 0; i < pubKeyCounts; i++ {
+	for i := int64(0); i < pubKeyCounts; i++ {
 		secPubKeys = append(secPubKeys, b.popStack())
 	}
 
 	//get the number of signatures
-	sigCounts := int(b.DecodeNum(b.popStack()))
-	if len(b.stack) < sigCounts+1 {
+	sigCounts, ok := b.popNum()
+	if !ok || sigCounts < 0 || sigCounts > pubKeyCounts {
 		return false
 	}
-
-	derSignatures := make([][]byte, 0)
-	for i := 0; i < sigCounts; i++ {
-		signature := b.popStack()
-		//remove the last byte, it is a hash type
-		signature = signature[0 : len(signature)-1]
-		derSignatures = append(derSignatures, signature)
+	if len(b.stack) < int(sigCounts)+1 {
+		return false
 	}
-
-	points := make([]*ecc.Point, 0)
-	sigs := make([]*ecc.Signature, 0)
-	for i := 0; i < pubKeyCounts; i++ {
-		points = append(points, ecc.ParseSEC(secPubKeys[i]))
+	sigs := make([][]byte, 0)
+	for i := int64(0); i < sigCounts; i++ {
+		sigs = append(sigs, b.popStack())
 	}
-	for i := 0; i < sigCounts; i++ {
-		sigs = append(sigs, ecc.ParseSigBin(derSignatures[i]))
+	//the original client takes one element more than it needs, the empty element at 6.
+	dummy := b.popStack()
+	if b.flags.Has(ScriptVerifyNullDummy) && len(dummy) != 0 {
+		return false
 	}
+	scriptCode := b.scriptCode(sigs)
 
 	/*
 		m public keys, n signatures, m >= n, given the signature with index i,
-		we need to find the paring key with index after i,
+		we need to find the paring key with index after i, the script fails once
+		the keys left are fewer than the signatures left. Signatures after
+		that are never looked at, not even their encoding
 	*/
-	z := new(big.Int)
-	z.SetBytes(zBin)
-	n := ecc.GetBitcoinValueN()
-	zField := ecc.NewFieldElement(n, z)
-	for _, sig := range sigs {
-		if len(points) == 0 {
+	success := true
+	for success && len(sigs) > 0 {
+		valid, err := b.verifySignature(sigs[0], secPubKeys[0], scriptCode, zBin)
+		if err != nil {
 			return false
 		}
-
-		for len(points) > 0 {
-			point := points[0]
-			points = points[1:]
-			if point.Verify(zField, sig) {
-				break
-			}
+		if valid {
+			sigs = sigs[1:]
+		}
+		secPubKeys = secPubKeys[1:]
+		if len(sigs) > len(secPubKeys) {
+			success = false
 		}
 	}
 
-	b.stack = append(b.stack, b.EncodeNum(1))
+	b.pushBool(success)
 	return true
 }
 
@@ -440,24 +933,15 @@ func (b *BitcoinOpCode) opCheckSig(zBin []byte) bool {
 	if len(b.stack) < 2 {
 		return false
 	}
-	pubKey := b.stack[len(b.stack)-1]
-	b.stack = b.stack[0 : len(b.stack)-1]
-	derSig := b.stack[len(b.stack)-1]
-	derSig = derSig[0 : len(derSig)-1]
-	b.stack = b.stack[0 : len(b.stack)-1]
+	pubKey := b.popStack()
+	//the last byte of the signature is the hash type
+	sigBin := b.popStack()
 
-	point := ecc.ParseSEC(pubKey)
-	sig := ecc.ParseSigBin(derSig)
-
-	z := new(big.Int)
-	z.SetBytes(zBin)
-	n := ecc.GetBitcoinValueN()
-	zField := ecc.NewFieldElement(n, z)
-	if point.Verify(zField, sig) == true {
-		b.stack = append(b.stack, b.EncodeNum(1))
-	} else {
-		b.stack = append(b.stack, b.EncodeNum(0))
+	valid, err := b.verifySignature(sigBin, pubKey, b.scriptCode([][]byte{sigBin}), zBin)
+	if err != nil {
+		return false
 	}
+	b.pushBool(valid)
 
 	return true
 }
@@ -472,68 +956,149 @@ func (b *BitcoinOpCode) HasCmd() bool {
 	return len(b.commands) > 0
 }
 
-func (b *BitcoinOpCode) opP2sh() bool {
-	//the first command is OP_HASH160
-	b.RemoveCmd()
-	//second element is data chunk
-	h160 := b.RemoveCmd()
-	//buf fix, remove OP_EQUAL
-	b.RemoveCmd()
-	/*
-		the top element of stack is the content of the redeem script, cache it then
-		do hash160 on it
-	*/
-	redeemScriptBinary := b.stack[len(b.stack)-1]
-	if b.opHash160() != true {
+/*
+opP2sh
+the scriptPubKey matched the hash of the redeem script on the top of the
+stack scriptSig left, the redeem script runs on the rest of that stack
+*/
+func (b *BitcoinOpCode) opP2sh(z []byte) bool {
+	if len(b.stack) < 1 {
 		return false
 	}
-	//append the h160 on the top of stack
-	b.stack = append(b.stack, h160)
-	//compare the two top hash elements
-	if b.opEqual() != true {
+	redeemScriptBinary := b.popStack()
+	if b.evalScript(redeemScriptBinary, z) != true {
 		return false
 	}
+	return b.topTrue()
+}
 
-	//make sure the top element is 1
-	if b.opVerify() != true {
+/*
+handleP2WPKH
+the witness of P2WPKH is a signature and a public key, they are checked by
+the P2PKH script of the 20 bytes hash. The stack of the scripts before is
+not used, nothing but the witness is
+*/
+func (b *BitcoinOpCode) handleP2WPKH(h160 []byte, witness [][]byte, z []byte) bool {
+	if len(witness) != 2 {
 		return false
 	}
-
-	//need to parse the redeem script and execute its command
-	//bug here, append the total length at the beginning
-	redeemScriptBinary = append([]byte{byte(len(redeemScriptBinary))}, redeemScriptBinary...)
-	scriptReader := bytes.NewReader(redeemScriptBinary)
-	redeemScriptSig := NewScriptSig(bufio.NewReader(scriptReader))
-	//bug here
-	b.commands = append(b.commands, redeemScriptSig.bitcoinOpCode.commands...)
-	return true
-
+	return b.evalWitnessScript(P2pkScript(h160).raw, witness, z)
 }
 
-func (b *BitcoinOpCode) handleP2WPKH() {
-	if len(b.commands) == 2 && b.commands[0][0] == OP_0 && len(b.commands[1]) == 20 {
-		b.RemoveCmd()
-		//remove OP_0
-		h160 := b.RemoveCmd()
+/*
+handleP2WSH
+the last element of the witness is the witness script, its sha256 is the 32
+bytes of the scriptPubKey, it runs on the elements before it
+*/
+func (b *BitcoinOpCode) handleP2WSH(h256 []byte, witness [][]byte, z []byte) bool {
+	if len(witness) == 0 {
+		return false
+	}
+	witnessScript := witness[len(witness)-1]
+	scriptHash := sha256.Sum256(witnessScript)
+	if !bytes.Equal(scriptHash[:], h256) {
+		return false
+	}
+	return b.evalWitnessScript(witnessScript, witness[:len(witness)-1], z)
+}
 
-		//set up signature and pub key
-		b.commands = append(b.commands, b.witness...)
-		//set up P2PK verify command
-		p2sh := P2pkScript(h160)
-		b.commands = append(b.commands, p2sh.bitcoinOpCode.commands...)
+/*
+evalWitnessScript
+runs the script of a witness v0 program on the elements of the witness, its
+signatures sign with BIP 143 and it must leave exactly one true element
+*/
+func (b *BitcoinOpCode) evalWitnessScript(script []byte, stack [][]byte, z []byte) bool {
+	for _, element := range stack {
+		if len(element) > MaxScriptElementSize {
+			return false
+		}
 	}
+	b.stack = append([][]byte{}, stack...)
+	b.witnessV0 = true
+	if b.evalScript(script, z) != true {
+		return false
+	}
+	return len(b.stack) == 1 && b.topTrue()
 }
 
 func (b *BitcoinOpCode) AppendDataElement(element []byte) {
 	b.stack = append(b.stack, element)
-	/*
-		everytime we push a data element, we need to check the command stack
-		meet the pattern of P2SH
-	*/
-	if b.isP2sh() {
-		//insert the OP_P2SH to the head of the command stack
-		b.commands = append([][]byte{[]byte{OP_P2SH}}, b.commands...)
+}
+
+/*
+evalScript
+runs the script on the stack left by the scripts before, OP_IF and the alt
+stack do not go past the end of a script and every script has its own limit
+of operations
+*/
+func (b *BitcoinOpCode) evalScript(raw []byte, z []byte) bool {
+	if len(raw) > MaxScriptSize {
+		return false
+	}
+	b.script, b.pc, b.codeSeparator = raw, 0, 0
+	b.opCount = 0
+	b.condStack = b.condStack[:0]
+	b.altStack = b.altStack[:0]
+	for b.pc < len(raw) {
+		op, next, err := readScriptOp(raw, b.pc)
+		if err != nil {
+			return false
+		}
+		b.pc = next
+		if b.step(op, z) != true {
+			return false
+		}
 	}
+	//every OP_IF needs its OP_ENDIF
+	return len(b.condStack) == 0
+}
+
+// isDisabled opcodes removed from the language, a script having one fails even when not run
+func isDisabled(code byte) bool {
+	switch code {
+	case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR,
+		OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT:
+		return true
+	}
+	return false
+}
+
+/*
+step
+runs one operation of the script: data is pushed and opcodes executed only in
+branches of OP_IF which run, the opcodes of OP_IF themselves always run to
+track where the branches end. The limits of the script are checked on every
+operation, run or not
+*/
+func (b *BitcoinOpCode) step(op scriptOp, z []byte) bool {
+	executing := b.executing()
+	if len(op.data) > MaxScriptElementSize {
+		return false
+	}
+	if op.code > OP_16 {
+		b.opCount += 1
+		if b.opCount > MaxOpsPerScript {
+			return false
+		}
+	}
+	if isDisabled(op.code) {
+		return false
+	}
+
+	if op.isPush() {
+		if executing {
+			if b.flags.Has(ScriptVerifyMinimalData) && !checkMinimalPush(op) {
+				return false
+			}
+			b.AppendDataElement(op.data)
+		}
+	} else if executing || (op.code >= OP_IF && op.code <= OP_ENDIF) {
+		if b.ExecuteOperation(int(op.code), z) != true {
+			return false
+		}
+	}
+
+	return len(b.stack)+len(b.altStack) <= MaxStackSize
 }
 
 func (b *BitcoinOpCode) ExecuteOperation(cmd int, z []byte) bool {
@@ -542,61 +1107,109 @@ func (b *BitcoinOpCode) ExecuteOperation(cmd int, z []byte) bool {
 		otherwise return false
 	*/
 	switch cmd {
-	case OP_CHECKSIG:
-		return b.opCheckSig(z)
+	case OP_0, OP_1NEGATE, OP_1, OP_2, OP_3, OP_4, OP_5, OP_6, OP_7, OP_8,
+		OP_9, OP_10, OP_11, OP_12, OP_13, OP_14, OP_15, OP_16:
+		return b.opNum(byte(cmd))
+
+	//flow control
+	case OP_NOP, OP_NOP1, OP_NOP4, OP_NOP5, OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9, OP_NOP10:
+		return true
+	case OP_CHECKLOCKTIMEVERIFY:
+		return b.opCheckLockTimeVerify()
+	case OP_CHECKSEQUENCEVERIFY:
+		return b.opCheckSequenceVerify()
+	case OP_IF:
+		return b.opIf(false)
+	case OP_NOTIF:
+		return b.opIf(true)
+	case OP_ELSE:
+		return b.opElse()
+	case OP_ENDIF:
+		return b.opEndIf()
+	case OP_VERIFY:
+		return b.opVerify()
+	case OP_RETURN:
+		return false
+
+	//stack
+	case OP_TOALTSTACK:
+		return b.opToAltStack()
+	case OP_FROMALTSTACK:
+		return b.opFromAltStack()
+	case OP_2DROP:
+		return b.opDrop(2)
+	case OP_2DUP:
+		return b.opCopy(2, 2)
+	case OP_3DUP:
+		return b.opCopy(3, 3)
+	case OP_2OVER:
+		return b.opCopy(2, 4)
+	case OP_2ROT:
+		return b.opMoveToTop(2, 6)
+	case OP_2SWAP:
+		return b.opMoveToTop(2, 4)
+	case OP_IFDUP:
+		return b.opIfDup()
+	case OP_DEPTH:
+		return b.opDepth()
+	case OP_DROP:
+		return b.opDrop(1)
 	case OP_DUP:
 		return b.opDup()
-	case OP_HASH160:
-		return b.opHash160()
+	case OP_NIP:
+		return b.opNip()
+	case OP_OVER:
+		return b.opCopy(1, 2)
+	case OP_PICK:
+		return b.opPick(false)
+	case OP_ROLL:
+		return b.opPick(true)
+	case OP_ROT:
+		return b.opMoveToTop(1, 3)
+	case OP_SWAP:
+		return b.opMoveToTop(1, 2)
+	case OP_TUCK:
+		return b.opTuck()
+	case OP_SIZE:
+		return b.opSize()
+
+	//bitwise logic
+	case OP_EQUAL:
+		return b.opEqual()
 	case OP_EQUALVERIFY:
 		return b.opEqualVerify()
+
+	//arithmetic
+	case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+		return b.opUnaryNum(cmd)
+	case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY,
+		OP_NUMNOTEQUAL, OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL,
+		OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+		return b.opBinaryNum(cmd)
+	case OP_WITHIN:
+		return b.opWithin()
+
+	//crypto
+	case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+		return b.opHash(cmd)
+	case OP_CODESEPARATOR:
+		//signatures after it do not sign the script before it
+		b.codeSeparator = b.pc
+		return true
+	case OP_CHECKSIG:
+		return b.opCheckSig(z)
+	case OP_CHECKSIGVERIFY:
+		return b.opCheckSig(z) && b.opVerify()
 	case OP_CHECKMULTISIG:
-		//bug fix here
 		return b.opCheckMultiSig(z)
-	case OP_P2SH:
-		return b.opP2sh()
-
-	case OP_0:
-		fallthrough
-	case OP_1:
-		fallthrough
-	case OP_2:
-		fallthrough
-	case OP_3:
-		fallthrough
-	case OP_4:
-		fallthrough
-	case OP_5:
-		fallthrough
-	case OP_6:
-		fallthrough
-	case OP_7:
-		fallthrough
-	case OP_8:
-		fallthrough
-	case OP_9:
-		fallthrough
-	case OP_10:
-		fallthrough
-	case OP_11:
-		fallthrough
-	case OP_12:
-		fallthrough
-	case OP_13:
-		fallthrough
-	case OP_14:
-		fallthrough
-	case OP_15:
-		fallthrough
-	case OP_16:
-		return b.opNum(byte(cmd))
-	case OP_EQUAL:
-		return b.opEqual()
-	default:
-		errStr := fmt.Sprintf("opeation %s not implemented\n", b.opCodeNames[cmd])
-		panic(errStr)
+	case OP_CHECKMULTISIGVERIFY:
+		return b.opCheckMultiSig(z) && b.opVerify()
 	}
 
+	/*
+		the disabled opcodes, OP_RESERVED, OP_VER, OP_VERIF, OP_VERNOTIF,
+		OP_RESERVED1, OP_RESERVED2 and the bytes with no opcode fail the script
+	*/
 	return false
 }
 
@@ -24,25 +24,48 @@ func (t *TransactionOutput) String() string {
 	return fmt.Sprintf("amount:%v\n scriptPubKey: %x\n", t.amount, t.scriptPubKey.Serialize())
 }
 
-func NewTractionOutput(reader *bufio.Reader) *TransactionOutput {
+func NewTractionOutput(reader *bufio.Reader) (*TransactionOutput, error) {
+	transactionOutput := &TransactionOutput{}
+	if err := transactionOutput.Decode(reader); err != nil {
+		return nil, err
+	}
+	return transactionOutput, nil
+}
+
+// Decode reads amount and scriptPubKey of one output from r, see Decoder
+func (t *TransactionOutput) Decode(r io.Reader) error {
+	reader := asWireReader(r)
 	/*
 		the amount is in satoshi 1/100,000,0000 of one bitcoin
 	*/
-	amountBuf := make([]byte, 8)
-	//bug fix
-	//reader.Read(amountBuf)
-	io.ReadFull(reader, amountBuf)
-	amount := LittleEndianToBigInt(amountBuf, LittleEndian8Bytes)
-	script := NewScriptSig(reader)
-	return &TransactionOutput{
-		amount:       amount,
-		scriptPubKey: script,
+	amountBuf, err := ReadField(reader, 8, "amount")
+	if err != nil {
+		return err
+	}
+	script := &ScriptSig{}
+	if err := script.Decode(reader); err != nil {
+		return err
 	}
+	t.amount = LittleEndianToBigInt(amountBuf, LittleEndian8Bytes)
+	t.scriptPubKey = script
+	return nil
+}
+
+// SerializeSize the length of Serialize
+func (t *TransactionOutput) SerializeSize() int {
+	return 8 + t.scriptPubKey.SerializeSize()
+}
+
+func (t *TransactionOutput) encode(ww *wireWriter) {
+	ww.uint64LE(t.amount.Uint64())
+	t.scriptPubKey.encode(ww)
+}
+
+// Encode see Encoder
+func (t *TransactionOutput) Encode(w io.Writer) error {
+	return encodeWire(w, t.encode)
 }
 
 func (t *TransactionOutput) Serialize() []byte {
-	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.amount, LittleEndian8Bytes)...)
-	result = append(result, t.scriptPubKey.Serialize()...)
-	return result
+	return EncodeToBytes(t)
 }
@@ -0,0 +1,186 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+/*
+ScriptFlags
+rules the interpreter enforces on top of the original ones, soft forks only
+ever add rules so a script valid with some flags stays valid without them.
+Blocks before a soft fork activated must be checked without its flag.
+*/
+type ScriptFlags uint32
+
+// ScriptVerifyNone only the rules of the first clients
+const ScriptVerifyNone ScriptFlags = 0
+
+const (
+	// ScriptVerifyDERSig signatures must follow the strict DER rules of BIP 66
+	ScriptVerifyDERSig ScriptFlags = 1 << iota
+	// ScriptVerifyLowS signatures must have s <= n / 2 (BIP 146), policy only
+	ScriptVerifyLowS
+	// ScriptVerifyP2SH the redeem script of a P2SH output runs as well (BIP 16)
+	ScriptVerifyP2SH
+	// ScriptVerifyWitness witness programs are checked with the witness (BIP 141, 143)
+	ScriptVerifyWitness
+	// ScriptVerifyTaproot witness v1 programs of 32 bytes are taproot outputs (BIP 341)
+	ScriptVerifyTaproot
+	// ScriptVerifyNullDummy the extra element OP_CHECKMULTISIG pops must be empty (BIP 147)
+	ScriptVerifyNullDummy
+	// ScriptVerifyMinimalData pushes and numbers must use their shortest encoding, policy only
+	ScriptVerifyMinimalData
+	/*
+		ScriptVerifyCleanStack the scripts must leave exactly one element, policy
+		only. Without P2SH and witness a spend could not leave its redeem script
+		on the stack, so it needs both of them
+	*/
+	ScriptVerifyCleanStack
+	// ScriptVerifyCheckLockTimeVerify OP_NOP2 is OP_CHECKLOCKTIMEVERIFY (BIP 65)
+	ScriptVerifyCheckLockTimeVerify
+	// ScriptVerifyCheckSequenceVerify OP_NOP3 is OP_CHECKSEQUENCEVERIFY (BIP 112)
+	ScriptVerifyCheckSequenceVerify
+)
+
+const (
+	/*
+		ScriptVerifyConsensus the rules every block after the last soft fork
+		follows. Only the key path of taproot is verified, a script path spend
+		or a witness with an annex gives ErrTaprootScriptPath or ErrTaprootAnnex
+		instead of being taken as valid
+	*/
+	ScriptVerifyConsensus = ScriptVerifyP2SH | ScriptVerifyDERSig | ScriptVerifyWitness |
+		ScriptVerifyTaproot | ScriptVerifyNullDummy | ScriptVerifyCheckLockTimeVerify |
+		ScriptVerifyCheckSequenceVerify
+	/*
+		ScriptVerifyPolicy the rules of a transaction accepted into the mempool
+		and relayed, stricter than consensus so no one else can change its id
+		and the upgrades a soft fork may bring stay unused
+	*/
+	ScriptVerifyPolicy = ScriptVerifyConsensus | ScriptVerifyLowS | ScriptVerifyMinimalData |
+		ScriptVerifyCleanStack
+)
+
+var (
+	ErrSigDER   = errors.New("signature is not strict DER")
+	ErrSigHighS = errors.New("signature s is above half the group order")
+)
+
+func (f ScriptFlags) Has(flag ScriptFlags) bool {
+	return f&flag == flag
+}
+
+/*
+ConsensusFlags
+the rules of the block at height on the network of params, nil is main-net.
+P2SH and witness are checked from the genesis block, as Bitcoin Core does, no
+spend of them in older blocks breaks their rules besides the two blocks Core
+exempts by hash, which are not known here
+*/
+func ConsensusFlags(params *ecc.ChainParams, height int64) ScriptFlags {
+	flags := ScriptVerifyP2SH | ScriptVerifyWitness
+	if height >= params.BIP66Height() {
+		flags |= ScriptVerifyDERSig
+	}
+	if height >= params.BIP65Height() {
+		flags |= ScriptVerifyCheckLockTimeVerify
+	}
+	if height >= params.CSVHeight() {
+		flags |= ScriptVerifyCheckSequenceVerify
+	}
+	if height >= params.SegwitHeight() {
+		flags |= ScriptVerifyNullDummy
+	}
+	if height >= params.TaprootHeight() {
+		flags |= ScriptVerifyTaproot
+	}
+	return flags
+}
+
+/*
+checkMinimalPush
+data is pushed by the shortest operation, OP_0 for nothing, OP_1NEGATE and
+OP_1 to OP_16 for a single byte of their value, then a direct push up to 75
+bytes and OP_PUSHDATA1, 2 or 4 after
+*/
+func checkMinimalPush(op scriptOp) bool {
+	data := op.data
+	switch {
+	case len(data) == 0:
+		return op.code == OP_0
+	case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+		return false
+	case len(data) == 1 && data[0] == 0x81:
+		return false
+	case len(data) <= SCRIPT_DATA_LENGTH_END:
+		return int(op.code) == len(data)
+	case len(data) <= 0xff:
+		return op.code == OP_PUSHDATA1
+	case len(data) <= 0xffff:
+		return op.code == OP_PUSHDATA2
+	}
+	return true
+}
+
+// isMinimalNum whether the number has no extra zero byte, the sign bit must need the last byte
+func isMinimalNum(element []byte) bool {
+	if len(element) == 0 {
+		return true
+	}
+	last := element[len(element)-1]
+	if last&0x7f != 0 {
+		return true
+	}
+	return len(element) > 1 && element[len(element)-2]&0x80 != 0
+}
+
+/*
+checkSignatureEncoding
+sigBin is the DER signature followed by the hash type byte as pushed on the
+stack. An error fails the whole script, a nil signature only makes the check
+fail: empty signatures are the standard way to make OP_CHECKSIG push 0 and
+r or s out of range can never verify.
+*/
+func checkSignatureEncoding(sigBin []byte, flags ScriptFlags) (*ecc.Signature, error) {
+	if len(sigBin) == 0 {
+		return nil, nil
+	}
+	der := sigBin[:len(sigBin)-1]
+	//low s is defined on a parsed signature, so it needs strict DER as well
+	if flags&(ScriptVerifyDERSig|ScriptVerifyLowS) != 0 {
+		if err := ecc.CheckDEREncoding(der); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSigDER, err)
+		}
+	}
+	sig, err := ecc.ParseDERSignatureLax(der)
+	if err != nil {
+		return nil, nil
+	}
+	if flags.Has(ScriptVerifyLowS) && !sig.IsLowS() {
+		return nil, ErrSigHighS
+	}
+	return sig, nil
+}
+
+/*
+parsePubKey
+the point of a public key on the stack, nil when it is not one. Besides the
+SEC formats the original client took the hybrid format of OpenSSL, 0x06 or
+0x07 for the parity of y and then x and y like the uncompressed format
+*/
+func parsePubKey(pubKey []byte) *ecc.Point {
+	if len(pubKey) == 65 && (pubKey[0] == 0x06 || pubKey[0] == 0x07) {
+		if pubKey[64]&1 != pubKey[0]&1 {
+			return nil
+		}
+		pubKey = append([]byte{0x04}, pubKey[1:]...)
+	}
+	point, err := ecc.ParseSEC(pubKey)
+	if err != nil {
+		return nil
+	}
+	return point
+}
@@ -2,9 +2,11 @@ package transaction
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"math/big"
 )
 
 type ScriptSig struct {
@@ -12,6 +14,14 @@ type ScriptSig struct {
 	bitcoinOpCode *BitcoinOpCode
 	//add witness data
 	witness [][]byte
+	/*
+		raw the script bytes without the length prefix, commands is the decoded
+		view of it. A parsed script keeps the exact bytes it was read from, a
+		push of one byte is a data element in raw but looks like an opcode in
+		commands, and pushes which are not minimal must serialize as they were
+		or the transaction id changes
+	*/
+	raw []byte
 }
 
 const (
@@ -20,99 +30,207 @@ const (
 	SCRIPT_DATA_LENGTH_END   = 75
 	OP_PUSHDATA1             = 76
 	OP_PUSHDATA2             = 77
+	OP_PUSHDATA4             = 78
 )
 
+/*
+InitScriptSig
+builds a script from its commands, a command of one byte is an opcode and
+any other is data to push
+*/
 func InitScriptSig(commands [][]byte) *ScriptSig {
 	bitcoinOpCode := NewBitCoinOpCode()
 	bitcoinOpCode.commands = commands
 	return &ScriptSig{
 		bitcoinOpCode: bitcoinOpCode,
+		raw:           encodeCommands(commands),
+	}
+}
+
+var ErrScriptLength = errors.New("script pushes data past its end")
+
+func NewScriptSig(reader *bufio.Reader) (*ScriptSig, error) {
+	script := &ScriptSig{}
+	if err := script.Decode(reader); err != nil {
+		return nil, err
 	}
+	return script, nil
 }
 
-func NewScriptSig(reader *bufio.Reader) *ScriptSig {
-	commands := [][]byte{}
+// Decode reads a length prefixed script from r, see Decoder
+func (s *ScriptSig) Decode(r io.Reader) error {
+	reader := asWireReader(r)
 	/*
 		In the beginning is the total length for script field
 	*/
-	scriptLen := ReadVariant(reader).Int64()
-	count := int64(0)
-	current := make([]byte, 1)
-	var currentByte byte
-	for count < scriptLen {
-		//buf fix
-		//reader.Read(current)
-		io.ReadFull(reader, current)
-		//operation
-		count += 1
-		currentByte = current[0]
-		if currentByte >= SCRIPT_DATA_LENGTH_BEGIN &&
-			currentByte <= SCRIPT_DATA_LENGTH_END {
-			//push the following bytes of data onto stack
-			data := make([]byte, currentByte)
-			//bug fix
-			//reader.Read(data)
-			io.ReadFull(reader, data)
-			commands = append(commands, data)
-			count += int64(currentByte)
-		} else if currentByte == OP_PUSHDATA1 {
-			/*
-				read the following byte as the length of data
-			*/
-			length := make([]byte, 1)
-			//bug fix
-			//reader.Read(length)
-			io.ReadFull(reader, length)
-
-			data := make([]byte, length[0])
-			//reader.Read(data)
-			io.ReadFull(reader, data)
-			commands = append(commands, data)
-			count += int64(length[0] + 1)
-		} else if currentByte == OP_PUSHDATA2 {
-			/*
-				read the following 2 bytes as length of data
-			*/
-			lenBuf := make([]byte, 2)
-			//buf ifx
-			//reader.Read(lenBuf)
-			io.ReadFull(reader, lenBuf)
-			length := LittleEndianToBigInt(lenBuf, LittleEndian2Bytes)
-			data := make([]byte, length.Int64())
-			//reader.Read(data)
-			io.ReadFull(reader, data)
-			commands = append(commands, data)
-			count += int64(2 + length.Int64())
+	scriptLenVal, err := ReadVariant(reader)
+	if err != nil {
+		return NewParseError("script length", err)
+	}
+	raw, err := ReadField(reader, scriptLenVal.Uint64(), "script")
+	if err != nil {
+		return err
+	}
+	script, err := ParseScript(raw)
+	if err != nil {
+		return NewParseError("script", err)
+	}
+	*s = *script
+	return nil
+}
+
+/*
+ParseScript
+the script of raw bytes without a length prefix, such as a redeem script
+taken from the stack
+*/
+func ParseScript(raw []byte) (*ScriptSig, error) {
+	ops, err := parseScriptOps(raw)
+	if err != nil {
+		return nil, err
+	}
+	commands := make([][]byte, 0, len(ops))
+	for _, op := range ops {
+		if op.code >= SCRIPT_DATA_LENGTH_BEGIN && op.code <= OP_PUSHDATA4 {
+			commands = append(commands, op.data)
 		} else {
 			//is data processing instruction
-			commands = append(commands, []byte{currentByte})
+			commands = append(commands, []byte{op.code})
+		}
+	}
+	bitcoinOpCode := NewBitCoinOpCode()
+	bitcoinOpCode.commands = commands
+	return &ScriptSig{
+		bitcoinOpCode: bitcoinOpCode,
+		raw:           raw,
+	}, nil
+}
+
+// scriptOp one opcode of a script and the data it pushes
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+// isPush OP_0 and the opcodes followed by data push onto the stack
+func (o scriptOp) isPush() bool {
+	return o.code <= OP_PUSHDATA4
+}
+
+/*
+readScriptOp
+the operation of raw at pos and where the next one begins. When the push
+runs past the end of raw, next is how far the opcode and its length were
+read, the legacy sighash keeps the bytes up to there
+*/
+func readScriptOp(raw []byte, pos int) (scriptOp, int, error) {
+	if pos >= len(raw) {
+		return scriptOp{}, pos, io.EOF
+	}
+	currentByte := raw[pos]
+	//operation
+	count := pos + 1
+	dataLen := 0
+	if currentByte >= SCRIPT_DATA_LENGTH_BEGIN &&
+		currentByte <= SCRIPT_DATA_LENGTH_END {
+		//push the following bytes of data onto stack
+		dataLen = int(currentByte)
+	} else if currentByte == OP_PUSHDATA1 {
+		/*
+			read the following byte as the length of data
+		*/
+		if count+1 > len(raw) {
+			return scriptOp{}, count, ErrScriptLength
+		}
+		dataLen = int(raw[count])
+		count += 1
+	} else if currentByte == OP_PUSHDATA2 {
+		/*
+			read the following 2 bytes as length of data
+		*/
+		if count+2 > len(raw) {
+			return scriptOp{}, count, ErrScriptLength
+		}
+		dataLen = int(binary.LittleEndian.Uint16(raw[count:]))
+		count += 2
+	} else if currentByte == OP_PUSHDATA4 {
+		if count+4 > len(raw) {
+			return scriptOp{}, count, ErrScriptLength
 		}
+		dataLen64 := uint64(binary.LittleEndian.Uint32(raw[count:]))
+		count += 4
+		if dataLen64 > uint64(len(raw)-count) {
+			return scriptOp{}, count, ErrScriptLength
+		}
+		dataLen = int(dataLen64)
+	} else {
+		return scriptOp{code: currentByte}, count, nil
 	}
 
-	if count != scriptLen {
-		panic("parsing script field fail")
+	if count+dataLen > len(raw) {
+		return scriptOp{}, count, ErrScriptLength
 	}
+	return scriptOp{code: currentByte, data: raw[count : count+dataLen]}, count + dataLen, nil
+}
 
-	return InitScriptSig(commands)
+func parseScriptOps(raw []byte) ([]scriptOp, error) {
+	ops := make([]scriptOp, 0)
+	for pos := 0; pos < len(raw); {
+		op, next, err := readScriptOp(raw, pos)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+		pos = next
+	}
+	return ops, nil
+}
+
+// isPushOnly whether the script only pushes data, OP_1NEGATE to OP_16 count as pushes
+func (s *ScriptSig) isPushOnly() bool {
+	ops, err := parseScriptOps(s.raw)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if op.code > OP_16 {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *ScriptSig) SetWitness(witness [][]byte) {
 	s.bitcoinOpCode.witness = witness
 }
 
-func (s *ScriptSig) Evaluate(z []byte) bool {
-	s.bitcoinOpCode.handleP2WPKH()
+/*
+SetFlags
+the extra rules Evaluate enforces, ScriptVerifyNone (the default) checks
+signatures the way the first clients did
+*/
+func (s *ScriptSig) SetFlags(flags ScriptFlags) {
+	s.bitcoinOpCode.flags = flags
+}
 
-	for s.bitcoinOpCode.HasCmd() {
-		cmd := s.bitcoinOpCode.RemoveCmd()
-		if len(cmd) == 1 {
-			//this is op code, run it
-			opRes := s.bitcoinOpCode.ExecuteOperation(int(cmd[0]), z)
-			if opRes != true {
-				return false
-			}
-		} else {
-			s.bitcoinOpCode.AppendDataElement(cmd)
+/*
+Evaluate
+runs the script on its own, signatures are checked against z. A script
+spending an output does not run joined with the scriptPubKey, see
+Transaction.VerifyInput
+*/
+func (s *ScriptSig) Evaluate(z []byte) bool {
+	b := s.bitcoinOpCode
+	if b.evalScript(s.raw, z) != true {
+		return false
+	}
+	//P2WPKH or P2WSH spent by the witness given with SetWitness
+	if version, program, ok := witnessProgram(s.raw); ok && version == 0 && len(b.witness) > 0 {
+		switch len(program) {
+		case 20:
+			return b.handleP2WPKH(program, b.witness, z)
+		case 32:
+			return b.handleP2WSH(program, b.witness, z)
 		}
 	}
 
@@ -121,67 +239,117 @@ func (s *ScriptSig) Evaluate(z []byte) bool {
 		then evaluation fails, otherwise we check the top element of the stack,
 		if its value is 0, then fail, if the value is not 0, then success
 	*/
-	if len(s.bitcoinOpCode.stack) == 0 {
-		return false
-	}
-	if len(s.bitcoinOpCode.stack[len(s.bitcoinOpCode.stack)-1]) == 0 {
-		return false
-	}
+	return b.topTrue()
+}
 
-	return true
+// pushPrefixSize the bytes before a pushed data of length bytes, the opcode and the length
+func pushPrefixSize(length int) int {
+	switch {
+	case length <= SCRIPT_DATA_LENGTH_END:
+		return 1
+	case length < 0x100:
+		return 2
+	case length <= 0xffff:
+		return 3
+	}
+	return 5
 }
 
-func (s *ScriptSig) rawSerialize() []byte {
-	result := []byte{}
-	for _, cmd := range s.bitcoinOpCode.commands {
+// encodeCommands the script bytes of commands, the minimal push for every data element
+func encodeCommands(commands [][]byte) []byte {
+	size := 0
+	for _, cmd := range commands {
 		if len(cmd) == 1 {
-			//only one byte means it is an instruction
-			result = append(result, cmd...)
+			size += 1
 		} else {
-			length := len(cmd)
-			if length <= SCRIPT_DATA_LENGTH_END {
-				//length in [0x01, 0x4b]
-				result = append(result, byte(length))
-			} else if length > SCRIPT_DATA_LENGTH_END && length < 0x100 {
-				//this is OP_PUSHDATA1 command,
-				//push the command and then the next byte is the length of the data
-				result = append(result, OP_PUSHDATA1)
-				result = append(result, byte(length))
-			} else if length >= 0x100 && length <= 520 {
-				/*
-					this is OP_PUSHDATA2 command, we push the command
-					and then two bytes for the data length but in little endian format
-				*/
-				result = append(result, OP_PUSHDATA2)
-				lenBuf := BigIntToLittleEndian(big.NewInt(int64(length)), LittleEndian2Bytes)
-				result = append(result, lenBuf...)
-			} else {
-				panic("too long an cmd")
-			}
-
-			//append the chunk of data with given length
-			result = append(result, cmd...)
+			size += pushPrefixSize(len(cmd)) + len(cmd)
+		}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	ww := &wireWriter{w: buf}
+	for _, cmd := range commands {
+		if len(cmd) == 1 {
+			//only one byte means it is an instruction
+			ww.write(cmd)
+			continue
 		}
+		//an empty command is OP_0
+		encodePush(ww, cmd)
+	}
+	return buf.Bytes()
+}
+
+// encodePush writes the shortest push of data, even a single byte is pushed as data
+func encodePush(ww *wireWriter, data []byte) {
+	length := len(data)
+	switch pushPrefixSize(length) {
+	case 1:
+		//length in [0x00, 0x4b]
+		ww.scratch[0] = byte(length)
+	case 2:
+		//this is OP_PUSHDATA1 command,
+		//push the command and then the next byte is the length of the data
+		ww.scratch[0] = OP_PUSHDATA1
+		ww.scratch[1] = byte(length)
+	case 3:
+		/*
+			this is OP_PUSHDATA2 command, we push the command
+			and then two bytes for the data length but in little endian format
+			pushes over 520 bytes fail when executed, but they can
+			still be in a script which is never run
+		*/
+		ww.scratch[0] = OP_PUSHDATA2
+		binary.LittleEndian.PutUint16(ww.scratch[1:3], uint16(length))
+	default:
+		ww.scratch[0] = OP_PUSHDATA4
+		binary.LittleEndian.PutUint32(ww.scratch[1:5], uint32(length))
 	}
+	ww.write(ww.scratch[:pushPrefixSize(length)])
+	//append the chunk of data with given length
+	ww.write(data)
+}
 
-	return result
+// pushData the script bytes pushing data, as a signature appears in the script it signs
+func pushData(data []byte) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, pushPrefixSize(len(data))+len(data)))
+	encodePush(&wireWriter{w: buf}, data)
+	return buf.Bytes()
 }
 
-func (s *ScriptSig) Serialize() []byte {
-	rawResult := s.rawSerialize()
-	total := len(rawResult)
-	result := []byte{}
+// rawSerialize the script without its varint length prefix
+func (s *ScriptSig) rawSerialize() []byte {
+	return s.raw
+}
+
+// SerializeSize the length of Serialize, the script and its varint length prefix
+func (s *ScriptSig) SerializeSize() int {
+	return VarIntSize(uint64(len(s.raw))) + len(s.raw)
+}
+
+func (s *ScriptSig) encode(ww *wireWriter) {
 	//encode the total length of the script at the head
-	result = append(result, EncodeVariant(big.NewInt(int64(total)))...)
-	result = append(result, rawResult...)
-	return result
+	ww.varBytes(s.raw)
+}
+
+// Encode writes the script with its length prefix, see Encoder
+func (s *ScriptSig) Encode(w io.Writer) error {
+	return encodeWire(w, s.encode)
+}
+
+func (s *ScriptSig) Serialize() []byte {
+	return EncodeToBytes(s)
 }
 
 func (s *ScriptSig) Add(script *ScriptSig) *ScriptSig {
-	commands := make([][]byte, 0)
-	commands = append(commands, s.bitcoinOpCode.commands...)
-	commands = append(commands, script.bitcoinOpCode.commands...)
-	return InitScriptSig(commands)
+	raw := make([]byte, 0, len(s.raw)+len(script.raw))
+	raw = append(raw, s.raw...)
+	raw = append(raw, script.raw...)
+	combined, err := ParseScript(raw)
+	if err != nil {
+		//both scripts parse on their own, so do they one after the other
+		panic(fmt.Sprintf("add scripts err: %v\n", err))
+	}
+	return combined
 }
 
 func (s *ScriptSig) PrintCmd(idx int) {
@@ -0,0 +1,168 @@
+package transaction
+
+import (
+	"bytes"
+	"math/big"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+/*
+Signature hashes of legacy and witness v0 inputs
+
+a signature does not sign the transaction as it is, the scriptSig of the input
+is not known yet when signing. The legacy message is the transaction with the
+scriptSig of the signed input replaced by the script code (the scriptPubKey,
+or the redeem script of P2SH), every other scriptSig empty and the hash type
+appended, then hash256 of it. The low bits of the hash type choose the outputs
+signed:
+
+	SIGHASH_ALL    every output
+	SIGHASH_NONE   no output, the sequence of the other inputs is set to 0
+	SIGHASH_SINGLE only the output with the index of the input, the outputs
+	               before it are blanked, the sequence of the other inputs is 0
+
+and SIGHASH_ANYONECANPAY keeps the signed input only. BIP 143 hashes the
+outpoints, sequences and outputs once and commits to the amount spent, so
+signing many inputs is linear and hardware wallets can trust the fee.
+*/
+
+// sigHashOne what the original client signs for SIGHASH_SINGLE without a matching output
+func sigHashOne() []byte {
+	one := make([]byte, 32)
+	one[0] = 1
+	return one
+}
+
+/*
+removeCodeSeparators
+the legacy sighash signs the script code without its OP_CODESEPARATOR
+opcodes, a truncated push at the end is kept as it is
+*/
+func removeCodeSeparators(scriptCode []byte) []byte {
+	result := make([]byte, 0, len(scriptCode))
+	start := 0
+	for pos := 0; pos < len(scriptCode); {
+		op, next, err := readScriptOp(scriptCode, pos)
+		if err != nil {
+			break
+		}
+		if op.code == OP_CODESEPARATOR {
+			result = append(result, scriptCode[start:pos]...)
+			start = next
+		}
+		pos = next
+	}
+	return append(result, scriptCode[start:]...)
+}
+
+// legacySigHashPreimage the modified transaction hashed by LegacySigHash
+func (t *Transaction) legacySigHashPreimage(inputIdx int, scriptCode []byte, hashType uint32) []byte {
+	outputType := hashType & 0x1f
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+
+	buf := new(bytes.Buffer)
+	ww := &wireWriter{w: buf}
+	ww.uint32LE(t.version.Uint64())
+
+	if anyoneCanPay {
+		ww.varInt(1)
+	} else {
+		ww.varInt(uint64(len(t.txInputs)))
+	}
+	for i, txIn := range t.txInputs {
+		if anyoneCanPay && i != inputIdx {
+			continue
+		}
+		ww.write(txIn.outpoint())
+		if i == inputIdx {
+			ww.varBytes(removeCodeSeparators(scriptCode))
+			ww.uint32LE(txIn.sequence.Uint64())
+			continue
+		}
+		ww.varInt(0)
+		if outputType == SIGHASH_NONE || outputType == SIGHASH_SINGLE {
+			//the other inputs can be replaced
+			ww.uint32LE(0)
+		} else {
+			ww.uint32LE(txIn.sequence.Uint64())
+		}
+	}
+
+	switch outputType {
+	case SIGHASH_NONE:
+		ww.varInt(0)
+	case SIGHASH_SINGLE:
+		ww.varInt(uint64(inputIdx + 1))
+		for i := 0; i < inputIdx; i++ {
+			//amount -1 and an empty script
+			ww.uint64LE(0xffffffffffffffff)
+			ww.varInt(0)
+		}
+		t.txOutputs[inputIdx].encode(ww)
+	default:
+		ww.varInt(uint64(len(t.txOutputs)))
+		for _, txOut := range t.txOutputs {
+			txOut.encode(ww)
+		}
+	}
+
+	ww.uint32LE(t.lockTime.Uint64())
+	ww.uint32LE(uint64(hashType))
+	return buf.Bytes()
+}
+
+/*
+LegacySigHash
+message signed by a signature of hashType in a legacy input, scriptCode is
+the part of the script after the last OP_CODESEPARATOR run. SIGHASH_SINGLE
+without an output at inputIdx gives 1, a bug every client must keep
+*/
+func (t *Transaction) LegacySigHash(inputIdx int, scriptCode []byte, hashType uint32) []byte {
+	if inputIdx >= len(t.txInputs) {
+		return sigHashOne()
+	}
+	if hashType&0x1f == SIGHASH_SINGLE && inputIdx >= len(t.txOutputs) {
+		return sigHashOne()
+	}
+	return ecc.Hash256(string(t.legacySigHashPreimage(inputIdx, scriptCode, hashType)))
+}
+
+/*
+WitnessV0SigHash
+message signed by a signature of hashType in a witness v0 input (BIP 143),
+amount is the value of the output spent
+*/
+func (t *Transaction) WitnessV0SigHash(inputIdx int, scriptCode []byte, amount *big.Int, hashType uint32) []byte {
+	outputType := hashType & 0x1f
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	zeroHash := make([]byte, 32)
+
+	hashPrevouts, hashSequence, hashOutputs := zeroHash, zeroHash, zeroHash
+	if !anyoneCanPay {
+		hashPrevouts = t.previousTxInBIP134Hash()
+	}
+	if !anyoneCanPay && outputType != SIGHASH_SINGLE && outputType != SIGHASH_NONE {
+		hashSequence = t.previousHashSequence()
+	}
+	if outputType != SIGHASH_SINGLE && outputType != SIGHASH_NONE {
+		hashOutputs = t.txOutBIP134Hash()
+	} else if outputType == SIGHASH_SINGLE && inputIdx < len(t.txOutputs) {
+		hashOutputs = ecc.Hash256(string(t.txOutputs[inputIdx].Serialize()))
+	}
+
+	txInput := t.txInputs[inputIdx]
+	buf := new(bytes.Buffer)
+	ww := &wireWriter{w: buf}
+	ww.uint32LE(t.version.Uint64())
+	ww.write(hashPrevouts)
+	ww.write(hashSequence)
+	ww.write(txInput.outpoint())
+	ww.varBytes(scriptCode)
+	ww.uint64LE(amount.Uint64())
+	ww.uint32LE(txInput.sequence.Uint64())
+	ww.write(hashOutputs)
+	ww.uint32LE(t.lockTime.Uint64())
+	ww.uint32LE(uint64(hashType))
+	return ecc.Hash256(string(buf.Bytes()))
+}
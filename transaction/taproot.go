@@ -0,0 +1,180 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+/*
+Taproot key path spending (BIP 341)
+
+the scriptPubKey is OP_1 <32 bytes output key>, spending by key path puts only
+a BIP 340 signature in the witness, 64 bytes for SIGHASH_DEFAULT or 65 bytes
+with the hash type appended. The message signed is not the hash of a modified
+transaction like legacy or BIP 143, it is
+
+	tagged_hash("TapSighash", 0x00 || hash type || version || lock time ||
+	sha256 of all outpoints || sha256 of all amounts ||
+	sha256 of all scriptPubKeys || sha256 of all sequences ||
+	sha256 of all outputs || spend type || input index)
+
+the hashes of inputs and outputs are left out or replaced by the data of this
+input only depending on ANYONECANPAY, NONE and SINGLE. Committing to every
+amount and scriptPubKey spent lets hardware wallets trust the fee.
+*/
+
+func (t *Transaction) IsP2TR(script *ScriptSig) bool {
+	commands := script.bitcoinOpCode.commands
+	return len(commands) == 2 && len(commands[0]) == 1 && commands[0][0] == OP_1 &&
+		len(commands[1]) == 32
+}
+
+func sha256Bytes(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func validTaprootHashType(hashType byte) bool {
+	return hashType <= SIGHASH_SINGLE ||
+		(hashType >= SIGHASH_ANYONECANPAY|SIGHASH_ALL && hashType <= SIGHASH_ANYONECANPAY|SIGHASH_SINGLE)
+}
+
+var (
+	ErrTaprootHashType      = errors.New("invalid taproot hash type")
+	ErrTaprootSigHashSingle = errors.New("no output for SIGHASH_SINGLE at the input index")
+)
+
+/*
+TaprootSigHash message of the key path signature for the given input, no
+annex. The error is for an invalid hash type, SIGHASH_SINGLE without its
+output or an output spent that can not be fetched
+*/
+func (t *Transaction) TaprootSigHash(inputIdx int, hashType byte) ([]byte, error) {
+	if !validTaprootHashType(hashType) {
+		return nil, ErrTaprootHashType
+	}
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	outputType := hashType & 3
+	if outputType == SIGHASH_DEFAULT {
+		outputType = SIGHASH_ALL
+	}
+
+	msg := []byte{0x00, hashType}
+	msg = append(msg, BigIntToLittleEndian(t.version, LittleEndian4Bytes)...)
+	msg = append(msg, BigIntToLittleEndian(t.lockTime, LittleEndian4Bytes)...)
+
+	if !anyoneCanPay {
+		outpoints, amounts, scriptPubKeys, sequences := []byte{}, []byte{}, []byte{}, []byte{}
+		for _, txIn := range t.txInputs {
+			prevOutput, err := txIn.PreviousOutput(t.params)
+			if err != nil {
+				return nil, err
+			}
+			outpoints = append(outpoints, txIn.outpoint()...)
+			amounts = append(amounts, BigIntToLittleEndian(prevOutput.amount, LittleEndian8Bytes)...)
+			scriptPubKeys = append(scriptPubKeys, prevOutput.scriptPubKey.Serialize()...)
+			sequences = append(sequences, BigIntToLittleEndian(txIn.sequence, LittleEndian4Bytes)...)
+		}
+		msg = append(msg, sha256Bytes(outpoints)...)
+		msg = append(msg, sha256Bytes(amounts)...)
+		msg = append(msg, sha256Bytes(scriptPubKeys)...)
+		msg = append(msg, sha256Bytes(sequences)...)
+	}
+	if outputType == SIGHASH_ALL {
+		outputs := []byte{}
+		for _, txOut := range t.txOutputs {
+			outputs = append(outputs, txOut.Serialize()...)
+		}
+		msg = append(msg, sha256Bytes(outputs)...)
+	}
+
+	//spend type: key path and no annex
+	msg = append(msg, 0x00)
+	txIn := t.txInputs[inputIdx]
+	if anyoneCanPay {
+		prevOutput, err := txIn.PreviousOutput(t.params)
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, txIn.outpoint()...)
+		msg = append(msg, BigIntToLittleEndian(prevOutput.amount, LittleEndian8Bytes)...)
+		msg = append(msg, prevOutput.scriptPubKey.Serialize()...)
+		msg = append(msg, BigIntToLittleEndian(txIn.sequence, LittleEndian4Bytes)...)
+	} else {
+		msg = append(msg, BigIntToLittleEndian(big.NewInt(int64(inputIdx)), LittleEndian4Bytes)...)
+	}
+	if outputType == SIGHASH_SINGLE {
+		if inputIdx >= len(t.txOutputs) {
+			return nil, ErrTaprootSigHashSingle
+		}
+		msg = append(msg, sha256Bytes(t.txOutputs[inputIdx].Serialize())...)
+	}
+
+	return ecc.TaggedHash("TapSighash", msg), nil
+}
+
+var (
+	ErrTaprootScriptPath = errors.New("taproot script path spends are not supported")
+	ErrTaprootAnnex      = errors.New("taproot witness with an annex is not supported")
+)
+
+/*
+verifyTaprootKeyPath checks the witness of a P2TR input holds a valid signature
+for the output key. A witness of more than one element is a script path spend
+or has an annex, we can not tell whether they are valid so they give an error
+*/
+func (t *Transaction) verifyTaprootKeyPath(inputIdx int, outputKey []byte) (bool, error) {
+	//the message commits to the amount and script of every output spent
+	if err := t.fetchPreviousOutputs(); err != nil {
+		return false, err
+	}
+	txIn := t.txInputs[inputIdx]
+	if len(txIn.scriptSig.bitcoinOpCode.commands) != 0 {
+		return false, nil
+	}
+	witness := txIn.witness
+	//the last element starting with 0x50 is the annex
+	if len(witness) >= 2 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == 0x50 {
+		return false, ErrTaprootAnnex
+	}
+	if len(witness) == 0 {
+		return false, nil
+	}
+	if len(witness) != 1 {
+		return false, ErrTaprootScriptPath
+	}
+
+	sigBin := witness[0]
+	hashType := byte(SIGHASH_DEFAULT)
+	if len(sigBin) == 65 {
+		hashType = sigBin[64]
+		//the explicit hash type byte must not be SIGHASH_DEFAULT
+		if hashType == SIGHASH_DEFAULT {
+			return false, nil
+		}
+		sigBin = sigBin[:64]
+	}
+	if !validTaprootHashType(hashType) {
+		return false, nil
+	}
+	if hashType&3 == SIGHASH_SINGLE && inputIdx >= len(t.txOutputs) {
+		return false, nil
+	}
+
+	sig, err := ecc.ParseSchnorrSignature(sigBin)
+	if err != nil {
+		return false, nil
+	}
+	pubKey, err := ecc.ParseXOnly(outputKey)
+	if err != nil {
+		return false, nil
+	}
+	sigHash, err := t.TaprootSigHash(inputIdx, hashType)
+	if err != nil {
+		return false, err
+	}
+	return pubKey.VerifySchnorr(sigHash, sig), nil
+}
@@ -1,8 +1,9 @@
 package transaction
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
 	"io"
@@ -10,7 +11,11 @@ import (
 )
 
 const (
-	SIGHASH_ALL = 1
+	SIGHASH_DEFAULT      = 0
+	SIGHASH_ALL          = 1
+	SIGHASH_NONE         = 2
+	SIGHASH_SINGLE       = 3
+	SIGHASH_ANYONECANPAY = 0x80
 )
 
 type Transaction struct {
@@ -18,19 +23,20 @@ type Transaction struct {
 	txInputs  []*TransactionInput
 	txOutputs []*TransactionOutput
 	lockTime  *big.Int
-	testnet   bool
+	//the network the spent outputs are fetched from, nil is main-net
+	params *ecc.ChainParams
 	//add segwit field
 	segwit bool
 }
 
 func InitTransaction(version *big.Int, txInputs []*TransactionInput, txOutputs []*TransactionOutput,
-	lockTime *big.Int, testnet bool) *Transaction {
+	lockTime *big.Int, params *ecc.ChainParams) *Transaction {
 	return &Transaction{
 		version:   version,
 		txInputs:  txInputs,
 		txOutputs: txOutputs,
 		lockTime:  lockTime,
-		testnet:   testnet,
+		params:    params,
 		//by default segwit set to false
 		segwit: false,
 	}
@@ -53,89 +59,52 @@ func (t *Transaction) String() string {
 		t.version, txIns, txOuts, t.lockTime)
 }
 
-func getInputCount(bufReader *bufio.Reader) *big.Int {
-	//we can remove the following, since we will handle it in parseSegwit
-	// firstByte, err := bufReader.Peek(1)
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// if firstByte[0] == 0x00 {
-	// 	//skip the first two bytes
-	// 	skipBuf := make([]byte, 2)
-	// 	//_, err = bufReader.Read(skipBuf)
-	// 	_, err = io.ReadFull(bufReader, skipBuf)
-	// 	if err != nil {
-	// 		panic(err)
-	// 	}
-	// }
-
-	count := ReadVariant(bufReader)
-	fmt.Printf("input count is: %x\n", count)
-	return count
+/*
+SerializeWithSign
+the message of a SIGHASH_ALL signature for the given input before hashing, the
+transaction with the scriptSig of the input replaced by the scriptPubKey of the
+output it spends, or by the redeem script for P2SH. The transaction itself
+is not changed. The error tells the output spent can not be fetched
+*/
+func (t *Transaction) SerializeWithSign(inputIdx int) ([]byte, error) {
+	scriptCode, err := t.txInputs[inputIdx].scriptCode(t.params)
+	if err != nil {
+		return nil, err
+	}
+	return t.legacySigHashPreimage(inputIdx, scriptCode, SIGHASH_ALL), nil
 }
 
-func (t *Transaction) SerializeWithSign(inputIdx int) []byte {
-	/*
-		construct a signature message for the given input indicate by inputIdx,
-		we need to change the given scriptSig with the scriptSubKey from the
-		output of the previous transaction, and then do hash256 on the binary transaction
-		data
-	*/
-	signBinary := make([]byte, 0)
-	signBinary = append(signBinary, BigIntToLittleEndian(t.version, LittleEndian4Bytes)...)
-
-	inputCount := big.NewInt(int64(len(t.txInputs)))
-	signBinary = append(signBinary, EncodeVariant(inputCount)...)
-	/*
-		serialize inputs, need to replace the scriptSig of the given input
-		to scriptPubKey of previous transaction
-	*/
-	for i := 0; i < len(t.txInputs); i++ {
-		if i == inputIdx {
-			t.txInputs[i].ReplaceWithScriptPubKey(t.testnet)
-			signBinary = append(signBinary, t.txInputs[i].Serialize()...)
-		} else {
-			signBinary = append(signBinary, t.txInputs[i].Serialize()...)
-		}
-	}
-
-	outputCount := big.NewInt(int64(len(t.txOutputs)))
-	signBinary = append(signBinary, EncodeVariant(outputCount)...)
-	for i := 0; i < len(t.txOutputs); i++ {
-		signBinary = append(signBinary, t.txOutputs[i].Serialize()...)
+func (t *Transaction) SignHash(inputIdx int) ([]byte, error) {
+	scriptCode, err := t.txInputs[inputIdx].scriptCode(t.params)
+	if err != nil {
+		return nil, err
 	}
+	return t.LegacySigHash(inputIdx, scriptCode, SIGHASH_ALL), nil
+}
 
-	signBinary = append(signBinary, BigIntToLittleEndian(t.lockTime, LittleEndian4Bytes)...)
-	signBinary = append(signBinary,
-		BigIntToLittleEndian(big.NewInt(int64(SIGHASH_ALL)), LittleEndian4Bytes)...)
-
-	return signBinary
+func (t *Transaction) SetChainParams(params *ecc.ChainParams) {
+	t.params = params
 }
 
-func (t *Transaction) SignHash(inputIdx int) []byte {
-	signBinary := t.SerializeWithSign(inputIdx)
-	h256 := ecc.Hash256(string(signBinary))
-	return h256
+func (t *Transaction) ChainParams() *ecc.ChainParams {
+	return t.params
 }
 
-func (t *Transaction) SetTestnet() {
-	t.testnet = true
+// SetSegwit serializes the transaction with marker, flag and witness data
+func (t *Transaction) SetSegwit() {
+	t.segwit = true
 }
 
+// IsP2WPKH OP_0 and the 20 bytes hash160 of the compressed sec public key
 func (t *Transaction) IsP2WPKH(script *ScriptSig) bool {
-	/*
-		two items on the command stack, one is POP_0, the other is
-		20 byte data item
-	*/
-	if len(script.bitcoinOpCode.commands) != 2 {
-		return false
-	}
-	if script.bitcoinOpCode.commands[0][0] != byte(OP_0) && len(script.bitcoinOpCode.commands[1]) != 20 {
-		return false
-	}
+	version, program, ok := witnessProgram(script.raw)
+	return ok && version == 0 && len(program) == 20
+}
 
-	return true
+// IsP2WSH OP_0 and the 32 bytes sha256 of the witness script
+func (t *Transaction) IsP2WSH(script *ScriptSig) bool {
+	version, program, ok := witnessProgram(script.raw)
+	return ok && version == 0 && len(program) == 32
 }
 
 func (t *Transaction) previousTxInBIP134Hash() []byte {
@@ -176,193 +145,358 @@ func P2pkhScrip(h160 []byte) *ScriptSig {
 	return InitScriptSig(cmd)
 }
 
-func (t *Transaction) BIP143SigHash(inputIdx int) []byte {
+/*
+BIP143SigHash
+message of a SIGHASH_ALL signature for a P2WPKH or P2WSH input, or one of
+them wrapped in P2SH. A P2WSH input must have its witness script as the last
+element of its witness. The error tells the output spent can not be fetched
+*/
+func (t *Transaction) BIP143SigHash(inputIdx int) ([]byte, error) {
 	txInput := t.txInputs[inputIdx]
-	//construct hash
-	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.version, LittleEndian4Bytes)...)
-	result = append(result, t.previousTxInBIP134Hash()...)
-	result = append(result, t.previousHashSequence()...)
-	result = append(result, reverseByteSlice(txInput.previousTransactionID)...)
-	result = append(result, BigIntToLittleEndian(txInput.previousTransactionIndex, LittleEndian4Bytes)...)
-	script := t.GetScript(inputIdx, true)
-	p2pkScript := P2pkScript(script.bitcoinOpCode.commands[1])
-	result = append(result, p2pkScript.Serialize()...)
-	result = append(result, BigIntToLittleEndian(txInput.Value(t.testnet), LittleEndian8Bytes)...)
-	result = append(result, BigIntToLittleEndian(txInput.sequence, LittleEndian4Bytes)...)
-	result = append(result, t.txOutBIP134Hash()...)
-	result = append(result, BigIntToLittleEndian(t.lockTime, LittleEndian4Bytes)...)
-	sigHashAll := big.NewInt(int64(SIGHASH_ALL))
-	result = append(result, BigIntToLittleEndian(sigHashAll, LittleEndian4Bytes)...)
-	hashResult := ecc.Hash256(string(result))
-	return hashResult
+	_, program, _, err := txInput.witnessProgram(t.params)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := txInput.Value(t.params)
+	if err != nil {
+		return nil, err
+	}
+	//P2WPKH signs the P2PKH script of its 20 bytes hash
+	scriptCode := P2pkScript(program).raw
+	if len(program) == 32 && len(txInput.witness) > 0 {
+		//P2WSH signs the witness script
+		scriptCode = txInput.witness[len(txInput.witness)-1]
+	}
+	return t.WitnessV0SigHash(inputIdx, scriptCode, amount, SIGHASH_ALL), nil
 }
 
+var ErrInputIndex = errors.New("transaction has no input at the index")
+
+/*
+VerifyInput checks the input with the consensus rules of today, see
+ScriptVerifyConsensus. It is false as well when the input can not be checked,
+VerifyInputWithFlags tells why
+*/
 func (t *Transaction) VerifyInput(inputIndex int) bool {
-	verifyScript := t.GetScript(inputIndex, t.testnet)
-	if t.IsP2WPKH(verifyScript) != true {
-		z := t.SignHash(inputIndex)
-		return verifyScript.Evaluate(z)
+	result, err := t.VerifyInputWithFlags(inputIndex, ScriptVerifyConsensus)
+	return err == nil && result
+}
+
+/*
+VerifyInputWithFlags
+the same as VerifyInput with the rules of flags, ConsensusFlags for a block
+at some height and ScriptVerifyPolicy for a transaction to relay. The error is
+for an input which can not be checked, the output spent can not be fetched or
+the script is not supported, a script that fails is false without error
+*/
+func (t *Transaction) VerifyInputWithFlags(inputIndex int, flags ScriptFlags) (bool, error) {
+	if inputIndex < 0 || inputIndex >= len(t.txInputs) {
+		return false, ErrInputIndex
+	}
+	//the sighashes of witness inputs need the outputs spent by every input
+	if err := t.fetchPreviousOutputs(); err != nil {
+		return false, err
+	}
+	prevOutput := t.txInputs[inputIndex].previousOutput
+	return t.verifyScript(inputIndex, prevOutput.scriptPubKey, prevOutput.amount, flags)
+}
+
+/*
+verifyScript
+whether the input spends an output of scriptPubKey and amount, the way the
+original client does it:
+
+ 1. the scriptSig runs, then the scriptPubKey runs on the stack it left, the
+    top element must be true
+ 2. with ScriptVerifyWitness a witness program for scriptPubKey is checked
+    with the witness, the scriptSig must be empty
+ 3. with ScriptVerifyP2SH the scriptSig of P2SH may only push data, the
+    redeem script pushed last runs on the other elements
+
+the two scripts never run as one, or a scriptSig could jump over the checks of
+the scriptPubKey. A witness is only allowed for a witness program
+*/
+func (t *Transaction) verifyScript(inputIndex int, scriptPubKey *ScriptSig, amount *big.Int, flags ScriptFlags) (bool, error) {
+	txInput := t.txInputs[inputIndex]
+	b := NewBitCoinOpCode()
+	b.flags = flags
+	b.tx, b.inputIdx, b.amount = t, inputIndex, amount
+
+	if b.evalScript(txInput.scriptSig.raw, nil) != true {
+		return false, nil
+	}
+	stackCopy := append([][]byte{}, b.stack...)
+	if b.evalScript(scriptPubKey.raw, nil) != true || b.topTrue() != true {
+		return false, nil
+	}
+
+	hadWitness := false
+	if version, program, ok := witnessProgram(scriptPubKey.raw); ok && flags.Has(ScriptVerifyWitness) {
+		hadWitness = true
+		if len(txInput.scriptSig.raw) != 0 {
+			return false, nil
+		}
+		if result, err := b.verifyWitnessProgram(version, program, txInput.witness, false); err != nil || !result {
+			return false, err
+		}
+		//the witness has its own rule for what it leaves on the stack
+		b.stack = b.stack[:1]
 	}
 
-	//verify segwit transaction
-	z := t.BIP143SigHash(inputIndex)
-	witness := t.txInputs[inputIndex].witness
-	verifyScript.SetWitness(witness)
-	return verifyScript.Evaluate(z)
+	if flags.Has(ScriptVerifyP2SH) && txInput.isP2sh(scriptPubKey) {
+		if txInput.scriptSig.isPushOnly() != true {
+			return false, nil
+		}
+		b.stack = stackCopy
+		if len(b.stack) == 0 {
+			return false, nil
+		}
+		redeemScript := b.stack[len(b.stack)-1]
+		if b.opP2sh(nil) != true {
+			return false, nil
+		}
+		//a witness program wrapped in P2SH, the scriptSig may only push it
+		if version, program, ok := witnessProgram(redeemScript); ok && flags.Has(ScriptVerifyWitness) {
+			hadWitness = true
+			if !bytes.Equal(txInput.scriptSig.raw, pushData(redeemScript)) {
+				return false, nil
+			}
+			if result, err := b.verifyWitnessProgram(version, program, txInput.witness, true); err != nil || !result {
+				return false, err
+			}
+			b.stack = b.stack[:1]
+		}
+	}
+
+	if flags.Has(ScriptVerifyCleanStack) && len(b.stack) != 1 {
+		return false, nil
+	}
+	if flags.Has(ScriptVerifyWitness) && !hadWitness && len(txInput.witness) > 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+/*
+verifyWitnessProgram
+checks the witness against the program of a scriptPubKey or of the redeem
+script of P2SH, the stack left by the scripts before does not matter.
+Programs without rules yet, the versions after 1 and taproot without
+ScriptVerifyTaproot or in P2SH, can be spent by anyone, a later soft fork
+gives them rules
+*/
+func (b *BitcoinOpCode) verifyWitnessProgram(version int, program []byte, witness [][]byte, inP2sh bool) (bool, error) {
+	if version == 1 && len(program) == 32 && !inP2sh && b.flags.Has(ScriptVerifyTaproot) {
+		return b.tx.verifyTaprootKeyPath(b.inputIdx, program)
+	}
+	if version != 0 {
+		return true, nil
+	}
+	switch len(program) {
+	case 20:
+		return b.handleP2WPKH(program, witness, nil), nil
+	case 32:
+		return b.handleP2WSH(program, witness, nil), nil
+	}
+	return false, nil
+}
+
+/*
+fetchPreviousOutputs gets the outputs spent by every input, fees and segwit
+sighashes need all of them, not only the one of the input being verified
+*/
+func (t *Transaction) fetchPreviousOutputs() error {
+	for i, txIn := range t.txInputs {
+		if _, err := txIn.PreviousOutput(t.params); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
 }
 
 func (t *Transaction) Verify() bool {
+	result, err := t.VerifyWithFlags(ScriptVerifyConsensus)
+	return err == nil && result
+}
+
+/*
+VerifyWithFlags
+the same as Verify with the rules of flags for the scripts, the error tells
+an input could not be checked, see VerifyInputWithFlags
+*/
+func (t *Transaction) VerifyWithFlags(flags ScriptFlags) (bool, error) {
 	/*
 		1. verify fee
 		2. verify each transaction input
 	*/
-	if t.Fee().Cmp(big.NewInt(int64(0))) < 0 {
-		return false
+	if err := t.fetchPreviousOutputs(); err != nil {
+		return false, err
+	}
+	fee, err := t.Fee()
+	if err != nil {
+		return false, err
+	}
+	if fee.Cmp(big.NewInt(int64(0))) < 0 {
+		return false, nil
 	}
 
 	for i := 0; i < len(t.txInputs); i++ {
-		if t.VerifyInput(i) != true {
-			return false
+		result, err := t.VerifyInputWithFlags(i, flags)
+		if err != nil {
+			return false, fmt.Errorf("input %d: %w", i, err)
+		}
+		if result != true {
+			return false, nil
 		}
 	}
 
-	return true
+	return true, nil
 }
 
-func ParseTransaction(binary []byte) *Transaction {
-	reader := bytes.NewReader(binary)
-	bufReader := bufio.NewReader(reader)
-
-	verBuf := make([]byte, 4)
-	//bufReader.Read(verBuf)
-	io.ReadFull(bufReader, verBuf)
+var ErrSegwitFlag = errors.New("segwit marker should be followed by flag 0x01")
 
-	segWitMarker := make([]byte, 1)
-	io.ReadFull(bufReader, segWitMarker)
-
-	reader = bytes.NewReader(binary)
-	bufReader = bufio.NewReader(reader)
-	if segWitMarker[0] == 0x00 {
-		return parseSegwit(bufReader)
+/*
+ParseTransaction
+parses a legacy or segwit (marker 0x00, flag 0x01 after the version) serialized
+transaction, binary must hold exactly one transaction
+*/
+func ParseTransaction(binary []byte) (*Transaction, error) {
+	reader := bytes.NewReader(binary)
+	transaction := &Transaction{}
+	if err := transaction.Decode(reader); err != nil {
+		return nil, err
 	}
-
-	return parseLegacy(bufReader)
+	if reader.Len() > 0 {
+		return nil, ErrTrailingData
+	}
+	return transaction, nil
 }
 
-func parseLegacy(bufReader *bufio.Reader) *Transaction {
-	transaction := &Transaction{}
-	verBuf := make([]byte, 4)
-	//bufReader.Read(verBuf)
-	io.ReadFull(bufReader, verBuf)
-	version := LittleEndianToBigInt(verBuf, LittleEndian4Bytes)
-	fmt.Printf("transaction version:%x\n", version)
-	transaction.version = version
-
-	inputs := getInputCount(bufReader)
-	transactionInputs := []*TransactionInput{}
+/*
+Decode
+reads one legacy or segwit transaction from r, see Decoder. A legacy
+transaction can not have 0 inputs, so 0x00 after the version is the segwit
+marker. The network of the transaction is not in the wire format, t keeps
+the one it had
+*/
+func (t *Transaction) Decode(r io.Reader) error {
+	reader := asWireReader(r)
+	transaction := &Transaction{params: t.params}
+	verBuf, err := ReadField(reader, 4, "version")
+	if err != nil {
+		return err
+	}
+	transaction.version = LittleEndianToBigInt(verBuf, LittleEndian4Bytes)
+
+	marker, err := reader.ReadByte()
+	if err != nil {
+		return NewParseError("input count", err)
+	}
+	var inputs *big.Int
+	if marker == 0x00 {
+		transaction.segwit = true
+		var flag byte
+		if flag, err = reader.ReadByte(); err != nil {
+			return NewParseError("segwit marker", err)
+		}
+		if flag != 0x01 {
+			return NewParseError("segwit marker", ErrSegwitFlag)
+		}
+		inputs, err = ReadVariant(reader)
+	} else {
+		inputs, err = readVariantAfter(marker, reader)
+	}
+	if err != nil {
+		return NewParseError("input count", err)
+	}
+
 	for i := 0; i < int(inputs.Int64()); i++ {
-		input := NewTractionInput(bufReader)
-		transactionInputs = append(transactionInputs, input)
+		input := &TransactionInput{}
+		if err := input.Decode(reader); err != nil {
+			return NewParseError(fmt.Sprintf("input %d", i), err)
+		}
+		transaction.txInputs = append(transaction.txInputs, input)
 	}
-	transaction.txInputs = transactionInputs
 
 	//read output counts
-	outputs := ReadVariant(bufReader)
-	transactionOutputs := []*TransactionOutput{}
+	outputs, err := ReadVariant(reader)
+	if err != nil {
+		return NewParseError("output count", err)
+	}
 	for i := 0; i < int(outputs.Int64()); i++ {
-		output := NewTractionOutput(bufReader)
-		transactionOutputs = append(transactionOutputs, output)
+		output := &TransactionOutput{}
+		if err := output.Decode(reader); err != nil {
+			return NewParseError(fmt.Sprintf("output %d", i), err)
+		}
+		transaction.txOutputs = append(transaction.txOutputs, output)
 	}
-	transaction.txOutputs = transactionOutputs
-
-	//get last four bytes for lock time
-	lockTimeBytes := make([]byte, 4)
-	//bufReader.Read(lockTimeBytes)
-	io.ReadFull(bufReader, lockTimeBytes)
-	transaction.lockTime = LittleEndianToBigInt(lockTimeBytes, LittleEndian4Bytes)
-
-	return transaction
-}
-
-func parseSegwit(bufReader *bufio.Reader) *Transaction {
-	transaction := &Transaction{}
-	transaction.segwit = true
-
-	verBuf := make([]byte, 4)
-	//bufReader.Read(verBuf)
-	io.ReadFull(bufReader, verBuf)
-	version := LittleEndianToBigInt(verBuf, LittleEndian4Bytes)
-	fmt.Printf("transaction version:%x\n", version)
-	transaction.version = version
 
-	// check the following 2 bytes
-	marker := make([]byte, 2)
-	io.ReadFull(bufReader, marker)
-	if marker[0] != 0x00 && marker[1] != 0x01 {
-		panic("Not segwit transaction")
+	if transaction.segwit {
+		if err := decodeWitness(reader, transaction.txInputs); err != nil {
+			return err
+		}
 	}
 
-	inputs := getInputCount(bufReader)
-	transactionInputs := []*TransactionInput{}
-	for i := 0; i < int(inputs.Int64()); i++ {
-		input := NewTractionInput(bufReader)
-		transactionInputs = append(transactionInputs, input)
+	//get last four bytes for lock time
+	lockTimeBytes, err := ReadField(reader, 4, "lock time")
+	if err != nil {
+		return err
 	}
-	transaction.txInputs = transactionInputs
+	transaction.lockTime = LittleEndianToBigInt(lockTimeBytes, LittleEndian4Bytes)
 
-	//read output counts
-	outputs := ReadVariant(bufReader)
-	transactionOutputs := []*TransactionOutput{}
-	for i := 0; i < int(outputs.Int64()); i++ {
-		output := NewTractionOutput(bufReader)
-		transactionOutputs = append(transactionOutputs, output)
-	}
-	transaction.txOutputs = transactionOutputs
+	*t = *transaction
+	return nil
+}
 
-	//parsing witness data,
-	for _, input := range transactionInputs {
-		numItems := ReadVariant(bufReader)
+// decodeWitness the witness items of every input, they follow the outputs
+func decodeWitness(reader wireReader, txInputs []*TransactionInput) error {
+	for idx, input := range txInputs {
+		field := fmt.Sprintf("witness %d", idx)
+		numItems, err := ReadVariant(reader)
+		if err != nil {
+			return NewParseError(field, err)
+		}
 		items := make([][]byte, 0)
 		for i := 0; i < int(numItems.Int64()); i++ {
-			itemLen := ReadVariant(bufReader)
-			if itemLen.Int64() == 0 {
-				items = append(items, []byte{})
-			} else {
-				item := make([]byte, itemLen.Int64())
-				io.ReadFull(bufReader, item)
-				items = append(items, item)
+			itemLen, err := ReadVariant(reader)
+			if err != nil {
+				return NewParseError(field, err)
+			}
+			item, err := ReadField(reader, itemLen.Uint64(), field)
+			if err != nil {
+				return err
+			}
+			if item == nil {
+				item = []byte{}
 			}
+			items = append(items, item)
 		}
 		input.witness = items
 	}
-
-	//get last four bytes for lock time
-	lockTimeBytes := make([]byte, 4)
-	//bufReader.Read(lockTimeBytes)
-	io.ReadFull(bufReader, lockTimeBytes)
-	transaction.lockTime = LittleEndianToBigInt(lockTimeBytes, LittleEndian4Bytes)
-
-	return transaction
+	return nil
 }
 
-func (t *Transaction) GetScript(idx int, testnet bool) *ScriptSig {
+func (t *Transaction) GetScript(idx int, params *ecc.ChainParams) (*ScriptSig, error) {
 	if idx < 0 || idx >= len(t.txInputs) {
-		panic("invalid idx for transaction input")
+		return nil, ErrInputIndex
 	}
 
 	txInput := t.txInputs[idx]
-	return txInput.Script(testnet)
+	return txInput.Script(params)
 }
 
-func (t *Transaction) Fee() *big.Int {
+// Fee the error tells the output spent by some input can not be fetched
+func (t *Transaction) Fee() (*big.Int, error) {
 	//amount of input - amount of ouptput > 0
 	inputSum := big.NewInt(int64(0))
 	outputSum := big.NewInt(int64(0))
 
 	for i := 0; i < len(t.txInputs); i++ {
 		addOp := new(big.Int)
-		value := t.txInputs[i].Value(t.testnet)
+		value, err := t.txInputs[i].Value(t.params)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
 		inputSum = addOp.Add(inputSum, value)
 	}
 
@@ -372,7 +506,7 @@ func (t *Transaction) Fee() *big.Int {
 	}
 
 	opSub := new(big.Int)
-	return opSub.Sub(inputSum, outputSum)
+	return opSub.Sub(inputSum, outputSum), nil
 }
 
 func (t *Transaction) IsCoinBase() bool {
@@ -399,74 +533,92 @@ func (t *Transaction) IsCoinBase() bool {
 	return true
 }
 
-func (t *Transaction) Serialize() []byte {
+/*
+SerializeSize
+the length of Serialize, with marker, flag and witness data when the
+transaction is segwit
+*/
+func (t *Transaction) SerializeSize() int {
+	size := t.baseSize()
 	if t.segwit {
-		return t.serializeSegwit()
+		//marker and flag
+		size += 2
+		for _, txInput := range t.txInputs {
+			size += VarIntSize(uint64(len(txInput.witness)))
+			for _, item := range txInput.witness {
+				size += VarIntSize(uint64(len(item))) + len(item)
+			}
+		}
 	}
-
-	return t.serializeLegacy()
+	return size
 }
 
-func (t *Transaction) serializeSegwit() []byte {
-	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.version, LittleEndian4Bytes)...)
-	result = append(result, []byte{0x00, 0x01}...)
-	inputCount := big.NewInt(int64(len(t.txInputs)))
-	result = append(result, EncodeVariant(inputCount)...)
+// baseSize the length of the legacy serialization, the one hashed for the transaction id
+func (t *Transaction) baseSize() int {
+	size := 4 + VarIntSize(uint64(len(t.txInputs)))
 	for _, txInput := range t.txInputs {
-		result = append(result, txInput.Serialize()...)
+		size += txInput.SerializeSize()
 	}
-
-	outputCount := big.NewInt(int64(len(t.txOutputs)))
-	result = append(result, EncodeVariant(outputCount)...)
+	size += VarIntSize(uint64(len(t.txOutputs)))
 	for _, txOutput := range t.txOutputs {
-		result = append(result, txOutput.Serialize()...)
+		size += txOutput.SerializeSize()
 	}
-
-	for _, txInput := range t.txInputs {
-		itemCount := big.NewInt(int64(len(txInput.witness)))
-		result = append(result, EncodeVariant(itemCount)...)
-		for _, item := range txInput.witness {
-			itemLen := big.NewInt(int64(len(item)))
-			result = append(result, EncodeVariant(itemLen)...)
-			result = append(result, item...)
-		}
-	}
-
-	result = append(result, BigIntToLittleEndian(t.lockTime, LittleEndian4Bytes)...)
-	return result
+	//lock time
+	return size + 4
 }
 
-func (t *Transaction) serializeLegacy() []byte {
-	result := make([]byte, 0)
-	result = append(result, BigIntToLittleEndian(t.version, LittleEndian4Bytes)...)
+func (t *Transaction) encode(ww *wireWriter, withWitness bool) {
+	ww.uint32LE(t.version.Uint64())
+	if withWitness {
+		ww.write([]byte{0x00, 0x01})
+	}
 
-	inputCount := big.NewInt(int64(len(t.txInputs)))
-	result = append(result, EncodeVariant(inputCount)...)
-	/*
-		serialize inputs, need to replace the scriptSig of the given input
-		to scriptPubKey of previous transaction
-	*/
-	for i := 0; i < len(t.txInputs); i++ {
+	ww.varInt(uint64(len(t.txInputs)))
+	for _, txInput := range t.txInputs {
 		/*
 			ScriptSig in input is empty, that's the reason for
 			segwit
 		*/
-		result = append(result, t.txInputs[i].Serialize()...)
+		txInput.encode(ww)
 	}
 
-	outputCount := big.NewInt(int64(len(t.txOutputs)))
-	result = append(result, EncodeVariant(outputCount)...)
-	for i := 0; i < len(t.txOutputs); i++ {
-		result = append(result, t.txOutputs[i].Serialize()...)
+	ww.varInt(uint64(len(t.txOutputs)))
+	for _, txOutput := range t.txOutputs {
+		txOutput.encode(ww)
 	}
 
-	result = append(result, BigIntToLittleEndian(t.lockTime, LittleEndian4Bytes)...)
+	if withWitness {
+		for _, txInput := range t.txInputs {
+			ww.varInt(uint64(len(txInput.witness)))
+			for _, item := range txInput.witness {
+				ww.varBytes(item)
+			}
+		}
+	}
+
+	ww.uint32LE(t.lockTime.Uint64())
+}
 
-	return result
+// Encode writes what Serialize returns, see Encoder
+func (t *Transaction) Encode(w io.Writer) error {
+	return encodeWire(w, func(ww *wireWriter) {
+		t.encode(ww, t.segwit)
+	})
+}
+
+func (t *Transaction) Serialize() []byte {
+	return EncodeToBytes(t)
 }
 
+// Hash the transaction id, hash256 of the legacy serialization streamed into sha256
 func (t *Transaction) Hash() []byte {
-	hash := ecc.Hash256(string(t.serializeLegacy()))
-	return reverseByteSlice(hash)
+	h := sha256.New()
+	if err := encodeWire(h, func(ww *wireWriter) {
+		t.encode(ww, false)
+	}); err != nil {
+		//hash.Hash never fails a write
+		panic(fmt.Sprintf("hash transaction err: %v\n", err))
+	}
+	hash := sha256.Sum256(h.Sum(nil))
+	return reverseByteSlice(hash[:])
 }
@@ -1,9 +1,25 @@
 package transaction
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
 )
 
 func TestOne(t *testing.T) {
@@ -16,8 +32,15 @@ func TestOne(t *testing.T) {
 		panic(err)
 	}
 
-	transaction := ParseTransaction(binary)
-	script := transaction.GetScript(0, false)
+	transaction, err := ParseTransaction(binary)
+	if err != nil {
+		t.Fatalf("parse transaction: %v", err)
+	}
+	script, err := transaction.GetScript(0, ecc.MainNetParams)
+	if err != nil {
+		//the spent output comes from a public server
+		t.Skipf("get script: %v", err)
+	}
 	//this is not our transaction, and we don't have its message and private
 	script.Evaluate([]byte{})
 }
@@ -31,7 +54,13 @@ func TestTwo(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	transaction := ParseTransaction(binary)
+	transaction, err := ParseTransaction(binary)
+	if err != nil {
+		t.Fatalf("parse transaction: %v", err)
+	}
+	if err := transaction.fetchPreviousOutputs(); err != nil {
+		t.Skipf("fetch previous outputs: %v", err)
+	}
 	res := transaction.Verify()
 	fmt.Printf("The evaluation result is %v\n", res)
 }
@@ -41,8 +70,14 @@ func TestThree(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	p2shTransaction := ParseTransaction(p2shRawData)
+	p2shTransaction, err := ParseTransaction(p2shRawData)
+	if err != nil {
+		t.Fatalf("parse transaction: %v", err)
+	}
 	fmt.Printf("p2sh transaction details: %s\n", p2shTransaction)
+	if err := p2shTransaction.fetchPreviousOutputs(); err != nil {
+		t.Skipf("fetch previous outputs: %v", err)
+	}
 	res := p2shTransaction.Verify()
 	fmt.Printf("verify result of p2sh transaction is %v\n", res)
 }
@@ -53,7 +88,10 @@ func TestFour(t *testing.T) {
 		panic(err)
 	}
 
-	block := ParseBlock(blockRawData)
+	block, err := ParseBlock(blockRawData)
+	if err != nil {
+		t.Fatalf("parse block: %v", err)
+	}
 	fmt.Printf("block info: %s\n", block)
 	blockSerialized := block.Serialize()
 	fmt.Printf("serialized block data:%x\n", blockSerialized)
@@ -74,9 +112,1196 @@ func TestFour(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	newTarget := ComputeNewTarget(firstBlockRawData, lastBlockRawData)
+	newTarget, err := ComputeNewTarget(firstBlockRawData, lastBlockRawData)
+	if err != nil {
+		t.Fatalf("compute new target: %v", err)
+	}
 	fmt.Printf("new target is :%064x\n", newTarget.Bytes())
 
 	newBits := TargetToBits(newTarget)
 	fmt.Printf("new bits:%x\n", newBits)
 }
+
+func bip322Key() *ecc.PrivateKey {
+	//private key of the BIP 322 test vectors
+	privateKey, _, _, err := ecc.ParseWif("L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k")
+	if err != nil {
+		panic(err)
+	}
+	return privateKey
+}
+
+func TestBIP322VirtualTransactions(t *testing.T) {
+	if hash := hex.EncodeToString(BIP322MessageHash("")); hash != "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1" {
+		t.Fatalf("wrong message hash %s", hash)
+	}
+
+	//bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l
+	_, sec := bip322Key().GetPublicKey().Sec(true)
+	challenge := P2wpkhScript(ecc.Hash160(sec))
+	for _, test := range []struct {
+		message string
+		toSpend string
+		toSign  string
+	}{
+		{"", "c5680aa69bb8d860bf82d4e9cd3504b55dde018de765a91bb566283c545a99a7", "1e9654e951a5ba44c8604c4de6c67fd78a27e81dcadcfe1edf638ba3aaebaed6"},
+		{"Hello World", "b79d196740ad5217771c1098fc4a4b51e0535c32236c71f1ea4d61a2d603352b", "88737ae86f2077145f93cc4b153ae9a1cb8d56afa511988c149c5c8c9d93bddf"},
+	} {
+		toSpend := BIP322ToSpend(challenge, test.message)
+		if id := hex.EncodeToString(toSpend.Hash()); id != test.toSpend {
+			t.Fatalf("to_spend of %q is %s, want %s", test.message, id, test.toSpend)
+		}
+		if id := hex.EncodeToString(BIP322ToSign(toSpend).Hash()); id != test.toSign {
+			t.Fatalf("to_sign of %q is %s, want %s", test.message, id, test.toSign)
+		}
+	}
+}
+
+func TestBIP322Simple(t *testing.T) {
+	privateKey := bip322Key()
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	p2wpkh := P2wpkhScript(ecc.Hash160(sec))
+	p2tr := P2trScript(ecc.TaprootOutputKey(privateKey.GetPublicKey(), nil).XOnly())
+
+	//signatures from the BIP 322 test vectors
+	vectors := []struct {
+		challenge *ScriptSig
+		message   string
+		signature string
+	}{
+		{p2wpkh, "", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{p2wpkh, "Hello World", "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{p2tr, "Hello World", "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ=="},
+	}
+	for _, vector := range vectors {
+		if ok, err := VerifyBIP322Simple(vector.challenge, vector.message, vector.signature); err != nil || !ok {
+			t.Fatalf("bip322 signature of %q rejected, err %v", vector.message, err)
+		}
+		if ok, err := VerifyBIP322Simple(vector.challenge, vector.message+"!", vector.signature); err != nil || ok {
+			t.Fatalf("bip322 signature of %q accepted for another message, err %v", vector.message, err)
+		}
+	}
+
+	for _, challenge := range []*ScriptSig{p2wpkh, p2tr} {
+		signature, err := SignBIP322Simple(privateKey, challenge, "Hello World")
+		if err != nil {
+			t.Fatalf("sign bip322: %v", err)
+		}
+		if ok, err := VerifyBIP322Simple(challenge, "Hello World", signature); err != nil || !ok {
+			t.Fatalf("our bip322 signature rejected, err %v", err)
+		}
+	}
+	if ok, err := VerifyBIP322Simple(p2wpkh, "Hello World", vectors[2].signature); err != nil || ok {
+		t.Fatalf("p2tr signature accepted for p2wpkh challenge, err %v", err)
+	}
+	//an empty witness is a proof that does not hold, the others can not be decoded
+	if ok, err := VerifyBIP322Simple(p2wpkh, "Hello World", "AA=="); err != nil || ok {
+		t.Fatalf("empty witness accepted, err %v", err)
+	}
+	for _, signature := range []string{"not base64", "AQ==", "AgE="} {
+		if ok, err := VerifyBIP322Simple(p2wpkh, "Hello World", signature); err == nil || ok {
+			t.Fatalf("malformed signature %q gives no error", signature)
+		}
+	}
+}
+
+func TestBIP322Full(t *testing.T) {
+	privateKey := bip322Key()
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	_, uncompressedSec := privateKey.GetPublicKey().Sec(false)
+	p2pkh := P2pkhScrip(ecc.Hash160(sec))
+	for _, challenge := range []*ScriptSig{p2pkh, P2pkhScrip(ecc.Hash160(uncompressedSec)), P2wpkhScript(ecc.Hash160(sec))} {
+		signature, err := SignBIP322Full(privateKey, challenge, "Hello World")
+		if err != nil {
+			t.Fatalf("sign bip322: %v", err)
+		}
+		if ok, err := VerifyBIP322Full(challenge, "Hello World", signature); err != nil || !ok {
+			t.Fatalf("full bip322 signature rejected, err %v", err)
+		}
+		//the to_sign transaction spends the to_spend of another message
+		if ok, err := VerifyBIP322Full(challenge, "Hello", signature); err != ErrBIP322ToSign || ok {
+			t.Fatalf("full bip322 signature for another message gives err %v", err)
+		}
+	}
+
+	signature, _ := SignBIP322Full(privateKey, p2pkh, "Hello World")
+	toSign, _ := base64.StdEncoding.DecodeString(signature)
+	if ok, err := VerifyBIP322Full(p2pkh, "Hello World", base64.StdEncoding.EncodeToString(toSign[:len(toSign)-1])); err == nil || ok {
+		t.Fatalf("truncated to_sign transaction gives no error")
+	}
+	//a to_sign of the message proven by the signature of another key
+	otherKey := ecc.NewPrivateKey(big.NewInt(12345))
+	_, otherSec := otherKey.GetPublicKey().Sec(true)
+	otherSignature, _ := SignBIP322Full(otherKey, P2pkhScrip(ecc.Hash160(otherSec)), "Hello World")
+	otherToSign, _ := base64.StdEncoding.DecodeString(otherSignature)
+	otherTx, _ := ParseTransaction(otherToSign)
+	forgedTx, _ := ParseTransaction(toSign)
+	forgedTx.txInputs[0].SetScriptSig(otherTx.txInputs[0].scriptSig)
+	if ok, err := VerifyBIP322Full(p2pkh, "Hello World", base64.StdEncoding.EncodeToString(forgedTx.Serialize())); err != nil || ok {
+		t.Fatalf("signature of another key accepted, err %v", err)
+	}
+
+	if _, err := SignBIP322Simple(privateKey, p2pkh, "Hello World"); err != ErrBIP322SimpleLegacy {
+		t.Fatalf("simple signature for legacy script gives err %v", err)
+	}
+	other := P2pkhScrip(ecc.Hash160([]byte("someone else")))
+	if _, err := SignBIP322Full(privateKey, other, "Hello World"); err != ErrBIP322UnsupportedScript {
+		t.Fatalf("signing for another key gives err %v", err)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address      string
+		addressType  AddressType
+		params       *ecc.ChainParams
+		scriptPubKey string
+	}{
+		{"1MirQ9bwyQcGVJPwKUgapu5ouK2E2Ey4gX", AddressP2PKH, ecc.MainNetParams, "76a914e34cce70c86373273efcc54ce7d2a491bb4a0e8488ac"},
+		{"mrX9vMRYLfVy1BnZbc5gZjuyaqH3ZW2ZHz", AddressP2PKH, ecc.TestNet3Params, "76a91478b316a08647d5b77283e512d3603f1f1c8de68f88ac"},
+		{"3NukJ6fYZJ5Kk8bPjycAnruZkE5Q7UW7i8", AddressP2SH, ecc.MainNetParams, "a914e8c300c87986efa84c37c0519929019ef86eb5b487"},
+		{"2NBFNJTktNa7GZusGbDbGKRZTxdK9VVez3n", AddressP2SH, ecc.TestNet3Params, "a914c579342c2c4c9220205e2cdc285617040c924a0a87"},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", AddressP2WPKH, ecc.MainNetParams, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", AddressP2WSH, ecc.TestNet3Params, "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", AddressP2TR, ecc.MainNetParams, "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", AddressP2WPKH, ecc.RegTestParams, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", AddressWitnessUnknown, ecc.MainNetParams, "5210751e76e8199196d454941c45d1b3a323"},
+	}
+	for _, test := range tests {
+		address, err := ParseAddress(test.address)
+		if err != nil {
+			t.Fatalf("parse %s: %v", test.address, err)
+		}
+		if address.Type() != test.addressType || address.ChainParams() != test.params {
+			t.Fatalf("%s parsed as %s on %s", test.address, address.Type(), address.ChainParams())
+		}
+		script := address.ScriptPubKey()
+		if hex.EncodeToString(script.rawSerialize()) != test.scriptPubKey {
+			t.Fatalf("%s gives script %x, want %s", test.address, script.rawSerialize(), test.scriptPubKey)
+		}
+		if address.String() != test.address {
+			t.Fatalf("%s formats as %s", test.address, address)
+		}
+
+		//back from the parsed script
+		scriptBin, _ := hex.DecodeString(test.scriptPubKey)
+		parsed, err := NewScriptSig(bufio.NewReader(bytes.NewReader(append(EncodeVariant(big.NewInt(int64(len(scriptBin)))), scriptBin...))))
+		if err != nil {
+			t.Fatalf("parse script %s: %v", test.scriptPubKey, err)
+		}
+		fromScript, err := AddressFromScript(parsed, test.params)
+		if err != nil {
+			t.Fatalf("address of script %s: %v", test.scriptPubKey, err)
+		}
+		if !fromScript.Equal(address) {
+			t.Fatalf("script %s gives address %s, want %s", test.scriptPubKey, fromScript, address)
+		}
+	}
+
+	for _, invalid := range []struct {
+		address string
+		err     error
+	}{
+		{"1MirQ9bwyQcGVJPwKUgapu5ouK2E2Ey4gY", ErrUnknownAddress},
+		{"LM2WMpR1Rp6j3Sa59cMXMs1SPzj9eXpGc1", ErrAddressVersion},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", ecc.ErrBech32Checksum},
+		{"tc1qw508d6qejxtdg4y5r3zarvary0c5xw7kg3g4ty", ErrUnknownAddress},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", ecc.ErrSegwitVariant},
+	} {
+		if _, err := ParseAddress(invalid.address); !errors.Is(err, invalid.err) {
+			t.Fatalf("parse %s gives err %v, want %v", invalid.address, err, invalid.err)
+		}
+	}
+
+	opReturn := InitScriptSig([][]byte{[]byte{OP_RETURN}, []byte("hello")})
+	if _, err := AddressFromScript(opReturn, ecc.MainNetParams); err != ErrNonStandardScript {
+		t.Fatalf("op_return script gives err %v", err)
+	}
+	//the templates with OP_PUSHDATA1 for the hash or program are not the same scripts
+	for _, nonMinimal := range []string{
+		"004c14751e76e8199196d454941c45d1b3a323f1433bd6",
+		"004c201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262",
+		"514c2079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		"76a94c14e34cce70c86373273efcc54ce7d2a491bb4a0e8488ac",
+		"a94c14e8c300c87986efa84c37c0519929019ef86eb5b487",
+	} {
+		raw, _ := hex.DecodeString(nonMinimal)
+		if _, err := AddressFromScript(rawScript(raw), ecc.MainNetParams); err != ErrNonStandardScript {
+			t.Fatalf("script %s gives err %v", nonMinimal, err)
+		}
+	}
+}
+
+func TestScriptFlags(t *testing.T) {
+	privateKey := ecc.NewPrivateKey(big.NewInt(8675309))
+	_, sec := privateKey.GetPublicKey().Sec(true)
+	zBin := ecc.Hash256("script flags")
+	z := new(big.Int).SetBytes(zBin)
+	der := privateKey.Sign(z).Der()
+
+	//n - s verifies as well but is not low s
+	parsed, err := ecc.ParseDERSignature(der)
+	if err != nil {
+		t.Fatalf("parse signature: %v", err)
+	}
+	rLen := int(der[3])
+	sBin := new(big.Int).SetBytes(der[6+rLen:])
+	highS := new(big.Int).Sub(ecc.GetBitcoinValueN(), sBin).Bytes()
+	if highS[0] >= 0x80 {
+		highS = append([]byte{0x00}, highS...)
+	}
+	buildDER := func(r []byte, s []byte) []byte {
+		body := append([]byte{0x02, byte(len(r))}, r...)
+		body = append(body, 0x02, byte(len(s)))
+		body = append(body, s...)
+		return append([]byte{0x30, byte(len(body))}, body...)
+	}
+	highSDER := buildDER(der[4:4+rLen], highS)
+	//one byte of zero padding before s breaks BIP 66 but not the old rules
+	paddedDER := buildDER(der[4:4+rLen], append([]byte{0x00}, der[6+rLen:]...))
+	if !parsed.IsLowS() {
+		t.Fatalf("Sign returned a high s")
+	}
+
+	tests := []struct {
+		der   []byte
+		flags ScriptFlags
+		valid bool
+	}{
+		{der, ScriptVerifyNone, true},
+		{der, ScriptVerifyDERSig | ScriptVerifyLowS, true},
+		{highSDER, ScriptVerifyNone, true},
+		{highSDER, ScriptVerifyDERSig, true},
+		{highSDER, ScriptVerifyLowS, false},
+		{paddedDER, ScriptVerifyNone, true},
+		{paddedDER, ScriptVerifyDERSig, false},
+		{paddedDER, ScriptVerifyLowS, false},
+	}
+	for i, test := range tests {
+		sig := append(append([]byte{}, test.der...), SIGHASH_ALL)
+		script := InitScriptSig([][]byte{sig, sec, []byte{OP_CHECKSIG}})
+		script.SetFlags(test.flags)
+		if script.Evaluate(zBin) != test.valid {
+			t.Fatalf("test %d: evaluate should give %v", i, test.valid)
+		}
+	}
+
+	//an empty signature makes OP_CHECKSIG push 0 under every flag
+	script := InitScriptSig([][]byte{[]byte{}, sec, []byte{OP_CHECKSIG}, []byte{OP_0}, []byte{OP_EQUAL}})
+	script.SetFlags(ScriptVerifyDERSig | ScriptVerifyLowS)
+	if !script.Evaluate(zBin) {
+		t.Fatalf("empty signature fails the script")
+	}
+
+	heights := []struct {
+		height int64
+		flags  ScriptFlags
+	}{
+		{0, ScriptVerifyP2SH | ScriptVerifyWitness},
+		{363725, ScriptVerifyP2SH | ScriptVerifyWitness | ScriptVerifyDERSig},
+		{481824, ScriptVerifyConsensus &^ ScriptVerifyTaproot},
+		{709632, ScriptVerifyConsensus},
+	}
+	for _, test := range heights {
+		if flags := ConsensusFlags(ecc.MainNetParams, test.height); flags != test.flags {
+			t.Fatalf("flags at height %d are %b, want %b", test.height, flags, test.flags)
+		}
+	}
+	if !ScriptVerifyPolicy.Has(ScriptVerifyConsensus) {
+		t.Fatalf("policy must enforce the consensus rules")
+	}
+	if flags := ConsensusFlags(ecc.RegTestParams, 0); !flags.Has(ScriptVerifyTaproot) {
+		t.Fatalf("taproot is active from the genesis block of regtest, flags %b", flags)
+	}
+}
+
+func TestTaprootUnsupported(t *testing.T) {
+	privateKey := bip322Key()
+	p2tr := P2trScript(ecc.TaprootOutputKey(privateKey.GetPublicKey(), nil).XOnly())
+	signature, err := SignBIP322Full(privateKey, p2tr, "taproot")
+	if err != nil {
+		t.Fatalf("sign key path: %v", err)
+	}
+	//the to_sign transaction spending p2tr by key path
+	keyPath, _ := base64.StdEncoding.DecodeString(signature)
+	spend := func(witness [][]byte) *Transaction {
+		tx, err := ParseTransaction(keyPath)
+		if err != nil {
+			t.Fatalf("parse to_sign: %v", err)
+		}
+		tx.txInputs[0].SetPreviousOutput(BIP322ToSpend(p2tr, "taproot").txOutputs[0])
+		if witness != nil {
+			tx.txInputs[0].SetWitness(witness)
+		}
+		return tx
+	}
+
+	if ok, err := spend(nil).VerifyInputWithFlags(0, ScriptVerifyPolicy); err != nil || !ok {
+		t.Fatalf("key path spend rejected, err %v", err)
+	}
+	if ok, err := spend([][]byte{}).VerifyInputWithFlags(0, ScriptVerifyPolicy); err != nil || ok {
+		t.Fatalf("empty witness accepted, err %v", err)
+	}
+
+	//a leaf script and a control block, or a signature and an annex
+	leafScript := []byte{OP_1}
+	controlBlock := append([]byte{0xc0}, p2tr.raw[2:]...)
+	unsupported := []struct {
+		witness [][]byte
+		err     error
+	}{
+		{[][]byte{leafScript, controlBlock}, ErrTaprootScriptPath},
+		{[][]byte{make([]byte, 64), []byte{0x50}}, ErrTaprootAnnex},
+	}
+	for _, test := range unsupported {
+		for _, flags := range []ScriptFlags{ScriptVerifyConsensus, ScriptVerifyPolicy} {
+			if ok, err := spend(test.witness).VerifyInputWithFlags(0, flags); err != test.err || ok {
+				t.Fatalf("witness %x with flags %b gives %v, err %v", test.witness, flags, ok, err)
+			}
+		}
+		if spend(test.witness).VerifyInput(0) {
+			t.Fatalf("witness %x verifies", test.witness)
+		}
+		//before taproot activated the output can be spent by anyone
+		flags := ConsensusFlags(ecc.MainNetParams, 709631)
+		if ok, err := spend(test.witness).VerifyInputWithFlags(0, flags); err != nil || !ok {
+			t.Fatalf("witness %x before taproot gives %v, err %v", test.witness, ok, err)
+		}
+	}
+}
+
+func TestScriptOpcodes(t *testing.T) {
+	tests := []struct {
+		script string
+		valid  bool
+	}{
+		//1 IF 2 ELSE 3 ENDIF 2 EQUAL
+		{"5163526753685287", true},
+		{"0063526753685387", true},
+		{"00645168", true},
+		//an IF without ENDIF or an ENDIF without IF
+		{"516351", false},
+		{"5168", false},
+		//OP_RETURN in a branch which does not run
+		{"006351636a686851", true},
+		//disabled opcodes and OP_VERIF fail even when not run, reserved and unknown ones only when run
+		{"00637e6851", false},
+		{"0063656851", false},
+		{"0063506851", true},
+		{"5150", false},
+		{"0063ba6851", true},
+		{"51ba", false},
+		{"525295", false},
+		//2 3 ADD 5 NUMEQUAL, -1 1ADD NOT
+		{"525393559c", true},
+		{"4f8b91", true},
+		//inputs are at most 4 bytes, results can be 5
+		{"0500000000018b", false},
+		{"04ffffff7f7693825587", true},
+		{"000051a5", true},
+		{"510051a5", false},
+		//1 2 3 2 PICK 1 EQUALVERIFY DEPTH 3 EQUAL
+		{"51525352795188745387", true},
+		//1 2 3 ROT 1 EQUALVERIFY 3 EQUALVERIFY 2 EQUAL
+		{"5152537b518853885287", true},
+		{"516b6c", true},
+		{"6c", false},
+		{"00a820e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b85587", true},
+		{"00a6149c1185a5c5e9fc54612808977ee8f548b2258d3187", true},
+		{"00a714da39a3ee5e6b4b0d3255bfef95601890afd8070987", true},
+		//negative zero is false, a pushed 0xac is data and not OP_CHECKSIG
+		{"0180", false},
+		{"01ac", true},
+		//0 of 0 multisig needs the extra element
+		{"000000ae", true},
+		{"0000ae", false},
+		{"51" + strings.Repeat("61", MaxOpsPerScript), true},
+		{"51" + strings.Repeat("61", MaxOpsPerScript+1), false},
+		{"4d0802" + strings.Repeat("01", MaxScriptElementSize), true},
+		{"4d0902" + strings.Repeat("01", MaxScriptElementSize+1), false},
+	}
+	for i, test := range tests {
+		raw, err := hex.DecodeString(test.script)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		script, err := ParseScript(raw)
+		if err != nil {
+			t.Fatalf("test %d: parse script: %v", i, err)
+		}
+		if script.Evaluate([]byte{}) != test.valid {
+			t.Fatalf("test %d: %s should give %v", i, test.script, test.valid)
+		}
+	}
+
+	numbers := []struct {
+		num     int64
+		encoded string
+	}{
+		{0, ""}, {1, "01"}, {-1, "81"}, {127, "7f"}, {128, "8000"}, {-128, "8080"},
+		{255, "ff00"}, {256, "0001"}, {-255, "ff80"}, {0x7fffffff, "ffffff7f"},
+	}
+	opCode := NewBitCoinOpCode()
+	for _, test := range numbers {
+		encoded := opCode.EncodeNum(test.num)
+		if hex.EncodeToString(encoded) != test.encoded || opCode.DecodeNum(encoded) != test.num {
+			t.Fatalf("%d encodes to %x, want %s", test.num, encoded, test.encoded)
+		}
+	}
+
+	//1 of 2 multisig only passes with a signature of one of its keys
+	zBin := ecc.Hash256("multisig")
+	z := new(big.Int).SetBytes(zBin)
+	_, sec1 := ecc.NewPrivateKey(big.NewInt(1001)).GetPublicKey().Sec(true)
+	key2 := ecc.NewPrivateKey(big.NewInt(1002))
+	_, sec2 := key2.GetPublicKey().Sec(true)
+	validSig := append(key2.Sign(z).Der(), SIGHASH_ALL)
+	otherSig := append(ecc.NewPrivateKey(big.NewInt(1003)).Sign(z).Der(), SIGHASH_ALL)
+	for _, test := range []struct {
+		sig   []byte
+		valid bool
+	}{{validSig, true}, {otherSig, false}} {
+		script := InitScriptSig([][]byte{{OP_0}, test.sig, {OP_1}, sec1, sec2, {OP_2}, {OP_CHECKMULTISIG}})
+		if script.Evaluate(zBin) != test.valid {
+			t.Fatalf("multisig should give %v", test.valid)
+		}
+	}
+}
+
+func TestP2WSH(t *testing.T) {
+	//2 of 2 multisig witness script
+	keys := []*ecc.PrivateKey{ecc.NewPrivateKey(big.NewInt(1001)), ecc.NewPrivateKey(big.NewInt(1002))}
+	commands := [][]byte{{OP_2}}
+	for _, key := range keys {
+		_, sec := key.GetPublicKey().Sec(true)
+		commands = append(commands, sec)
+	}
+	commands = append(commands, []byte{OP_2}, []byte{OP_CHECKMULTISIG})
+	witnessScript := InitScriptSig(commands).raw
+	scriptHash := sha256.Sum256(witnessScript)
+	p2wsh := P2wshScript(scriptHash[:])
+
+	spend := func(scriptPubKey *ScriptSig, scriptSig *ScriptSig, signers []*ecc.PrivateKey) *Transaction {
+		amount := big.NewInt(50000)
+		txIn := InitTransactionInput(bytes.Repeat([]byte{0x11}, 32), big.NewInt(1))
+		txIn.SetScriptSig(scriptSig)
+		txIn.SetPreviousOutput(InitTransactionOutput(amount, scriptPubKey))
+		txOut := InitTransactionOutput(big.NewInt(40000), P2wpkhScript(make([]byte, 20)))
+		tx := InitTransaction(big.NewInt(2), []*TransactionInput{txIn}, []*TransactionOutput{txOut}, big.NewInt(0), nil)
+		tx.SetSegwit()
+
+		z := new(big.Int).SetBytes(tx.WitnessV0SigHash(0, witnessScript, amount, SIGHASH_ALL))
+		witness := [][]byte{{}}
+		for _, signer := range signers {
+			witness = append(witness, append(signer.Sign(z).Der(), SIGHASH_ALL))
+		}
+		txIn.SetWitness(append(witness, witnessScript))
+		return tx
+	}
+
+	tx := spend(p2wsh, InitScriptSig([][]byte{}), keys)
+	if !tx.IsP2WSH(p2wsh) || !tx.VerifyInput(0) {
+		t.Fatalf("2 of 2 P2WSH spend does not verify")
+	}
+	if hash, err := tx.BIP143SigHash(0); err != nil || !bytes.Equal(hash, tx.WitnessV0SigHash(0, witnessScript, big.NewInt(50000), SIGHASH_ALL)) {
+		t.Fatalf("BIP143SigHash does not sign the witness script")
+	}
+	if spend(p2wsh, InitScriptSig([][]byte{}), keys[:1]).VerifyInput(0) {
+		t.Fatalf("one signature of 2 of 2 verifies")
+	}
+	//the witness script must hash to the program
+	tx.txInputs[0].witness[3] = append(append([]byte{}, witnessScript...), OP_NOP)
+	if tx.VerifyInput(0) {
+		t.Fatalf("witness script with another hash verifies")
+	}
+
+	//P2SH-P2WSH, the scriptSig only pushes the P2WSH program
+	p2sh := P2shScript(ecc.Hash160(p2wsh.raw))
+	nested := spend(p2sh, InitScriptSig([][]byte{p2wsh.raw}), keys)
+	if !nested.VerifyInput(0) {
+		t.Fatalf("P2SH-P2WSH spend does not verify")
+	}
+	nested.txInputs[0].SetScriptSig(InitScriptSig([][]byte{{OP_1}, p2wsh.raw}))
+	if nested.VerifyInput(0) {
+		t.Fatalf("P2SH-P2WSH with more than the program in scriptSig verifies")
+	}
+}
+
+func TestNestedP2WPKH(t *testing.T) {
+	//the P2SH-P2WPKH example of BIP 143, spending 10 btc
+	binary, _ := hex.DecodeString("01000000000101db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a5477010000001716001479091972186c449eb1ded22b78e40d009bdf0089feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac02473044022047ac8e878352d3ebbde1c94ce3a10d057c24175747116f8288e5d794d12d482f0220217f36a485cae903c713331d877c1f64677e3622ad4010726870540656fe9dcb012103ad1d8e89212f0b92c74d23bb710c00662ad1470198ac48c43f7d6f93a2a2687392040000")
+	tx, err := ParseTransaction(binary)
+	if err != nil {
+		t.Fatalf("parse transaction: %v", err)
+	}
+	scriptPubKey, _ := hex.DecodeString("a9144733f37cf4db86fbc2efed2500b4f4e49f31202387")
+	txIn := tx.txInputs[0]
+	txIn.SetPreviousOutput(InitTransactionOutput(big.NewInt(1000000000), rawScript(scriptPubKey)))
+
+	if hash, err := tx.BIP143SigHash(0); err != nil || hex.EncodeToString(hash) != "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc81d89d735c92e59fb6" {
+		t.Fatalf("sighash of the redeem script program is %x, err %v", hash, err)
+	}
+	if !tx.VerifyInput(0) {
+		t.Fatalf("P2SH-P2WPKH spend does not verify")
+	}
+	//without the witness rules the redeem script only has to be true
+	txIn.SetWitness(nil)
+	if ok, err := tx.VerifyInputWithFlags(0, ScriptVerifyP2SH); tx.VerifyInput(0) || err != nil || !ok {
+		t.Fatalf("P2SH-P2WPKH without witness")
+	}
+
+	//the signature commits to the amount spent
+	tx, _ = ParseTransaction(binary)
+	tx.txInputs[0].SetPreviousOutput(InitTransactionOutput(big.NewInt(1000000001), rawScript(scriptPubKey)))
+	if tx.VerifyInput(0) {
+		t.Fatalf("P2SH-P2WPKH verifies with another amount")
+	}
+}
+
+/*
+TestNestedSegwitMainnet
+both inputs of segwitTxHex spend P2SH-P2WPKH outputs of main-net, the outputs
+spent are kept here so the test does not need the network
+*/
+func TestNestedSegwitMainnet(t *testing.T) {
+	binary, _ := hex.DecodeString(segwitTxHex)
+	tx, err := ParseTransaction(binary)
+	if err != nil {
+		t.Fatalf("parse transaction: %v", err)
+	}
+	spent := []struct {
+		amount       int64
+		scriptPubKey string
+	}{
+		{58272218, "a914316d93c00013701d8e576c1da7a2dff9f949051387"},
+		{564032276, "a914d9c7ab26c423e15bd24eff6a2dc29087a340315a87"},
+	}
+	for i, output := range spent {
+		scriptPubKey, _ := hex.DecodeString(output.scriptPubKey)
+		tx.txInputs[i].SetPreviousOutput(InitTransactionOutput(big.NewInt(output.amount), rawScript(scriptPubKey)))
+	}
+	if fee, err := tx.Fee(); err != nil || fee.Int64() != 40092 {
+		t.Fatalf("fee is %d, err %v", fee, err)
+	}
+
+	for i, txIn := range tx.txInputs {
+		if version, program, ok, err := txIn.witnessProgram(tx.params); err != nil || !ok || version != 0 || len(program) != 20 {
+			t.Fatalf("input %d does not spend P2SH-P2WPKH", i)
+		}
+		//the signature in the witness signs BIP143SigHash of the redeem script program
+		sig, err := ecc.ParseDERSignature(txIn.witness[0][:len(txIn.witness[0])-1])
+		if err != nil {
+			t.Fatalf("input %d signature: %v", i, err)
+		}
+		sigHash, err := tx.BIP143SigHash(i)
+		if err != nil {
+			t.Fatalf("input %d sighash: %v", i, err)
+		}
+		z := ecc.NewFieldElement(ecc.GetBitcoinValueN(), new(big.Int).SetBytes(sigHash))
+		if !parsePubKey(txIn.witness[1]).Verify(z, sig) {
+			t.Fatalf("input %d does not sign BIP143SigHash", i)
+		}
+		if ok, err := tx.VerifyInputWithFlags(i, ScriptVerifyPolicy); err != nil || !ok {
+			t.Fatalf("input %d does not verify, err %v", i, err)
+		}
+	}
+	if !tx.Verify() {
+		t.Fatalf("transaction does not verify")
+	}
+}
+
+// TestPreviousOutputErrors no public server knows regtest, every method needing the output spent fails
+func TestPreviousOutputErrors(t *testing.T) {
+	binary, _ := hex.DecodeString(segwitTxHex)
+	tx, err := ParseTransaction(binary)
+	if err != nil {
+		t.Fatalf("parse transaction: %v", err)
+	}
+	tx.SetChainParams(ecc.RegTestParams)
+	txIn := tx.txInputs[0]
+
+	if _, err := txIn.Value(tx.params); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("Value err %v", err)
+	}
+	if _, err := tx.GetScript(0, tx.params); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("GetScript err %v", err)
+	}
+	if _, err := tx.GetScript(2, tx.params); err != ErrInputIndex {
+		t.Fatalf("GetScript of input 2 err %v", err)
+	}
+	if err := txIn.ReplaceWithScriptPubKey(tx.params); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("ReplaceWithScriptPubKey err %v", err)
+	}
+	if _, err := tx.Fee(); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("Fee err %v", err)
+	}
+	if _, err := tx.SignHash(0); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("SignHash err %v", err)
+	}
+	if _, err := tx.BIP143SigHash(0); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("BIP143SigHash err %v", err)
+	}
+	if _, err := tx.TaprootSigHash(0, SIGHASH_DEFAULT); !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("TaprootSigHash err %v", err)
+	}
+	if _, err := tx.TaprootSigHash(0, 0x04); err != ErrTaprootHashType {
+		t.Fatalf("TaprootSigHash of hash type 4 err %v", err)
+	}
+	if ok, err := tx.VerifyWithFlags(ScriptVerifyConsensus); ok || !errors.Is(err, ErrFetchNetwork) {
+		t.Fatalf("VerifyWithFlags gives %v, %v", ok, err)
+	}
+}
+
+func TestLockTime(t *testing.T) {
+	txIn := InitTransactionInput(make([]byte, 32), big.NewInt(0))
+	txIn.SetScriptSig(InitScriptSig([][]byte{}))
+	tx := InitTransaction(big.NewInt(2), []*TransactionInput{txIn}, []*TransactionOutput{}, big.NewInt(0), nil)
+
+	sequences := []struct {
+		sequence  int64
+		lockTime  int64
+		inSeconds bool
+		enabled   bool
+	}{
+		{10, 10, false, true},
+		{SequenceLockTimeTypeFlag | 3, 3 * 512, true, true},
+		//bits outside the flags and the value mean nothing
+		{1<<20 | 144, 144, false, true},
+		{SequenceLockTimeDisableFlag | 10, 0, false, false},
+		{SequenceFinal, 0, false, false},
+	}
+	for _, test := range sequences {
+		txIn.SetSequence(big.NewInt(test.sequence))
+		lockTime, inSeconds, enabled := tx.RelativeLockTime(0)
+		if lockTime != test.lockTime || inSeconds != test.inSeconds || enabled != test.enabled {
+			t.Fatalf("sequence %x gives %d %v %v", test.sequence, lockTime, inSeconds, enabled)
+		}
+	}
+	tx.version = big.NewInt(1)
+	if _, _, enabled := tx.RelativeLockTime(0); enabled {
+		t.Fatalf("relative lock time of a version 1 transaction")
+	}
+
+	tx.lockTime = big.NewInt(800000)
+	txIn.SetSequence(big.NewInt(SequenceFinal - 1))
+	if tx.IsFinal(800000, 0) || !tx.IsFinal(800001, 0) {
+		t.Fatalf("lock time at height 800000")
+	}
+	txIn.SetSequence(big.NewInt(SequenceFinal))
+	if !tx.IsFinal(1, 0) {
+		t.Fatalf("final inputs ignore the lock time")
+	}
+	tx.lockTime = big.NewInt(1700000000)
+	txIn.SetSequence(big.NewInt(0))
+	if tx.IsFinal(900000, 1700000000) || !tx.IsFinal(1, 1700000001) {
+		t.Fatalf("lock time at unix time 1700000000")
+	}
+}
+
+/*
+The vectors of Bitcoin Core in testdata, as btcd v0.24.2 keeps them, see
+testdata/LICENSE for what btcd removed. Every vector
+runs as its own subtest, the ones which need a rule the interpreter does not
+enforce yet are listed in coreSkipped and skipped with the reason. A listed
+vector which passes fails the test, so the list stays exact.
+*/
+
+// coreScriptFlags the flags of the vectors the interpreter enforces
+var coreScriptFlags = map[string]ScriptFlags{
+	"DERSIG":      ScriptVerifyDERSig,
+	"LOW_S":       ScriptVerifyLowS,
+	"P2SH":        ScriptVerifyP2SH,
+	"WITNESS":     ScriptVerifyWitness,
+	"TAPROOT":     ScriptVerifyTaproot,
+	"NULLDUMMY":   ScriptVerifyNullDummy,
+	"MINIMALDATA": ScriptVerifyMinimalData,
+	"CLEANSTACK":  ScriptVerifyCleanStack,
+
+	"CHECKLOCKTIMEVERIFY": ScriptVerifyCheckLockTimeVerify,
+	"CHECKSEQUENCEVERIFY": ScriptVerifyCheckSequenceVerify,
+}
+
+// coreSkipped the vectors which need a rule the interpreter does not enforce yet, by file and index
+var coreSkipped = map[string]map[int]string{
+	"script_tests.json": {
+		723:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		724:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		725:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		726:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		727:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		728:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		729:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		730:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		731:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		732:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		733:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		734:  "DISCOURAGE_UPGRADABLE_NOPS is not enforced",
+		989:  "STRICTENC public key types are not enforced",
+		990:  "STRICTENC signature encoding is not enforced",
+		1042: "NULLFAIL is not enforced",
+		1064: "STRICTENC public key types are not enforced",
+		1068: "STRICTENC public key types are not enforced",
+		1071: "STRICTENC public key types are not enforced",
+		1073: "STRICTENC hash types are not enforced",
+		1075: "STRICTENC hash types are not enforced",
+		1081: "SIGPUSHONLY is not enforced",
+		1085: "SIGPUSHONLY is not enforced",
+		1109: "DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM is not enforced",
+		1123: "WITNESS_PUBKEYTYPE is not enforced",
+		1124: "WITNESS_PUBKEYTYPE is not enforced",
+		1125: "WITNESS_PUBKEYTYPE is not enforced",
+		1126: "WITNESS_PUBKEYTYPE is not enforced",
+		1135: "WITNESS_PUBKEYTYPE is not enforced",
+		1136: "WITNESS_PUBKEYTYPE is not enforced",
+		1139: "WITNESS_PUBKEYTYPE is not enforced",
+		1140: "WITNESS_PUBKEYTYPE is not enforced",
+		1147: "WITNESS_PUBKEYTYPE is not enforced",
+		1148: "WITNESS_PUBKEYTYPE is not enforced",
+		1188: "MINIMALIF is not enforced",
+		1189: "MINIMALIF is not enforced",
+		1204: "MINIMALIF is not enforced",
+		1214: "MINIMALIF is not enforced",
+		1215: "MINIMALIF is not enforced",
+		1230: "MINIMALIF is not enforced",
+		1239: "NULLFAIL is not enforced",
+		1241: "NULLFAIL is not enforced",
+	},
+	"tx_invalid.json": {
+		131: "DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM is not enforced",
+		155: "DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM is not enforced",
+		177: "CONST_SCRIPTCODE is not enforced",
+		178: "CONST_SCRIPTCODE is not enforced",
+		179: "CONST_SCRIPTCODE is not enforced",
+		180: "CONST_SCRIPTCODE is not enforced",
+		181: "CONST_SCRIPTCODE is not enforced",
+		182: "CONST_SCRIPTCODE is not enforced",
+		183: "CONST_SCRIPTCODE is not enforced",
+		184: "CONST_SCRIPTCODE is not enforced",
+		185: "CONST_SCRIPTCODE is not enforced",
+		186: "CONST_SCRIPTCODE is not enforced",
+		187: "CONST_SCRIPTCODE is not enforced",
+		188: "CONST_SCRIPTCODE is not enforced",
+	},
+}
+
+var coreOpCodes = func() map[string]byte {
+	names := map[string]byte{
+		"NOP2": OP_CHECKLOCKTIMEVERIFY, "OP_NOP2": OP_CHECKLOCKTIMEVERIFY,
+		"NOP3": OP_CHECKSEQUENCEVERIFY, "OP_NOP3": OP_CHECKSEQUENCEVERIFY,
+		"TRUE": OP_1, "OP_TRUE": OP_1, "FALSE": OP_0, "OP_FALSE": OP_0,
+	}
+	for code, name := range NewBitCoinOpCode().opCodeNames {
+		names[name] = byte(code)
+		//a bare number is data, not OP_1 to OP_16
+		if short := strings.TrimPrefix(name, "OP_"); !coreNumber.MatchString(short) {
+			names[short] = byte(code)
+		}
+	}
+	return names
+}()
+
+var coreNumber = regexp.MustCompile(`^-?[0-9]+$`)
+
+/*
+parseCoreScript
+the short form of the vectors: numbers, 0x followed by raw script bytes,
+'text' pushed as data and opcode names with or without OP_
+*/
+func parseCoreScript(short string) ([]byte, error) {
+	script := make([]byte, 0)
+	for _, word := range strings.Fields(short) {
+		switch {
+		case coreNumber.MatchString(word):
+			n, err := strconv.ParseInt(word, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if n == -1 || (n >= 1 && n <= 16) {
+				script = append(script, byte(int64(OP_1)+n-1))
+			} else if n == 0 {
+				script = append(script, OP_0)
+			} else {
+				script = append(script, pushData(NewBitCoinOpCode().EncodeNum(n))...)
+			}
+		case strings.HasPrefix(word, "0x"):
+			raw, err := hex.DecodeString(word[2:])
+			if err != nil {
+				return nil, err
+			}
+			script = append(script, raw...)
+		case len(word) >= 2 && word[0] == '\'' && word[len(word)-1] == '\'':
+			script = append(script, pushData([]byte(word[1:len(word)-1]))...)
+		default:
+			code, ok := coreOpCodes[word]
+			if !ok {
+				return nil, fmt.Errorf("unknown opcode %s", word)
+			}
+			script = append(script, code)
+		}
+	}
+	return script, nil
+}
+
+// rawScript a script of any bytes, the vectors have scripts which do not parse
+func rawScript(raw []byte) *ScriptSig {
+	if script, err := ParseScript(raw); err == nil {
+		return script
+	}
+	return &ScriptSig{bitcoinOpCode: NewBitCoinOpCode(), raw: raw}
+}
+
+func loadCoreVectors(t *testing.T, name string) [][]any {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	var vectors [][]any
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	return vectors
+}
+
+// parseCoreFlags the flags of names the interpreter enforces, the vectors needing the others are in coreSkipped
+func parseCoreFlags(names string) ScriptFlags {
+	flags := ScriptVerifyNone
+	for _, name := range strings.Split(names, ",") {
+		flags |= coreScriptFlags[name]
+	}
+	return flags
+}
+
+/*
+checkCoreVector
+fails the subtest when the verdict of the interpreter is not the one of the
+vector, an error means the interpreter can not tell and fails as well
+*/
+func checkCoreVector(t *testing.T, name string, index int, vector []any, verified bool, err error, valid bool) {
+	t.Helper()
+	reason, skipped := coreSkipped[name][index]
+	if skipped {
+		if err == nil && verified == valid {
+			t.Fatalf("%v passes, remove it from coreSkipped", vector)
+		}
+		t.Skip(reason)
+	}
+	if err != nil {
+		t.Fatalf("%v can not be verified: %v", vector, err)
+	}
+	if verified != valid {
+		t.Fatalf("%v verified %v, want %v", vector, verified, valid)
+	}
+}
+
+/*
+coreSpend
+the transaction of a script vector, it spends the only output of a crediting
+transaction with scriptPubKey and amount
+*/
+func coreSpend(scriptSig []byte, scriptPubKey []byte, witness [][]byte, amount int64) *Transaction {
+	creditInput := InitTransactionInput(make([]byte, 32), big.NewInt(0xffffffff))
+	creditInput.SetScriptSig(InitScriptSig([][]byte{{OP_0}, {OP_0}}))
+	credit := InitTransaction(big.NewInt(1), []*TransactionInput{creditInput},
+		[]*TransactionOutput{InitTransactionOutput(big.NewInt(amount), rawScript(scriptPubKey))}, big.NewInt(0), nil)
+
+	spendInput := InitTransactionInput(credit.Hash(), big.NewInt(0))
+	spendInput.SetScriptSig(rawScript(scriptSig))
+	spendInput.SetWitness(witness)
+	spendInput.SetPreviousOutput(credit.txOutputs[0])
+	spend := InitTransaction(big.NewInt(1), []*TransactionInput{spendInput},
+		[]*TransactionOutput{InitTransactionOutput(big.NewInt(amount), rawScript(nil))}, big.NewInt(0), nil)
+	if len(witness) > 0 {
+		spend.SetSegwit()
+	}
+	return spend
+}
+
+func TestScriptTests(t *testing.T) {
+	for i, vector := range loadCoreVectors(t, "script_tests.json") {
+		var witness [][]byte
+		amount := int64(0)
+		if items, ok := vector[0].([]any); ok {
+			for _, item := range items[:len(items)-1] {
+				element, _ := hex.DecodeString(item.(string))
+				witness = append(witness, element)
+			}
+			amount = int64(math.Round(items[len(items)-1].(float64) * 1e8))
+			vector = vector[1:]
+		}
+		//comments
+		if len(vector) < 4 {
+			continue
+		}
+		scriptSigShort, scriptPubKeyShort := vector[0].(string), vector[1].(string)
+		flagNames, expected := vector[2].(string), vector[3].(string)
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			scriptSig, err := parseCoreScript(scriptSigShort)
+			if err != nil {
+				t.Fatalf("scriptSig %q: %v", scriptSigShort, err)
+			}
+			scriptPubKey, err := parseCoreScript(scriptPubKeyShort)
+			if err != nil {
+				t.Fatalf("scriptPubKey %q: %v", scriptPubKeyShort, err)
+			}
+			flags := parseCoreFlags(flagNames)
+			spend := coreSpend(scriptSig, scriptPubKey, witness, amount)
+			verified, err := spend.VerifyInputWithFlags(0, flags)
+			checkCoreVector(t, "script_tests.json", i, vector, verified, err, expected == "OK")
+		})
+	}
+}
+
+func TestTxValid(t *testing.T) {
+	testCoreTransactions(t, "tx_valid.json", true)
+}
+
+func TestTxInvalid(t *testing.T) {
+	testCoreTransactions(t, "tx_invalid.json", false)
+}
+
+/*
+testCoreTransactions
+every input of a valid transaction verifies with the flags of the vector, an
+invalid one has an input which does not
+*/
+func testCoreTransactions(t *testing.T, name string, valid bool) {
+	for i, vector := range loadCoreVectors(t, name) {
+		if len(vector) != 3 {
+			continue
+		}
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			binary, _ := hex.DecodeString(vector[1].(string))
+			tx, err := ParseTransaction(binary)
+			if err != nil {
+				if valid {
+					t.Fatalf("parse transaction: %v", err)
+				}
+				return
+			}
+			flags := parseCoreFlags(vector[2].(string))
+
+			prevOutputs := make(map[string]*TransactionOutput)
+			for _, item := range vector[0].([]any) {
+				prev := item.([]any)
+				script, err := parseCoreScript(prev[2].(string))
+				if err != nil {
+					t.Fatalf("scriptPubKey %q: %v", prev[2], err)
+				}
+				amount := int64(0)
+				if len(prev) > 3 {
+					amount = int64(prev[3].(float64))
+				}
+				key := fmt.Sprintf("%s:%d", prev[0], uint32(int64(prev[1].(float64))))
+				prevOutputs[key] = InitTransactionOutput(big.NewInt(amount), rawScript(script))
+			}
+			for j, txIn := range tx.txInputs {
+				output, ok := prevOutputs[fmt.Sprintf("%x:%d", txIn.previousTransactionID, txIn.previousTransactionIndex.Uint64())]
+				if !ok {
+					t.Fatalf("input %d spends an output not in the vector", j)
+				}
+				txIn.SetPreviousOutput(output)
+			}
+
+			verified, err := true, error(nil)
+			for j := 0; j < len(tx.txInputs) && verified && err == nil; j++ {
+				verified, err = tx.VerifyInputWithFlags(j, flags)
+				if err != nil {
+					err = fmt.Errorf("input %d: %w", j, err)
+				}
+			}
+			checkCoreVector(t, name, i, vector, verified, err, valid)
+		})
+	}
+}
+
+func TestSigHash(t *testing.T) {
+	for i, vector := range loadCoreVectors(t, "sighash.json") {
+		if len(vector) != 5 {
+			continue
+		}
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			binary, _ := hex.DecodeString(vector[0].(string))
+			tx, err := ParseTransaction(binary)
+			if err != nil {
+				t.Fatalf("parse transaction: %v", err)
+			}
+			scriptCode, _ := hex.DecodeString(vector[1].(string))
+			inputIdx := int(vector[2].(float64))
+			hashType := uint32(int32(vector[3].(float64)))
+			//the hash is shown in reverse byte order
+			want, _ := hex.DecodeString(vector[4].(string))
+			want = reverseByteSlice(want)
+			if got := tx.LegacySigHash(inputIdx, scriptCode, hashType); !bytes.Equal(got, want) {
+				t.Fatalf("sighash is %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+const (
+	legacyTxHex = "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
+	segwitTxHex = "01000000000102197393122da5beff963907ff11e4041af10780c868188aad754cc73e3cc35cd9010000001716001462c61a14835b032d5acbe190291d80d0cc5ca28e00000000feae2204104ffe542f30a20012a5b8e2b54a6f61f592520b511801b2237b5ed80100000017160014b30be91e50402cda780c56a3e1c350b1086c80af000000000200a3e111000000001976a914e60c9ac5f72d1d620287a0fc35656bceae5e2ab988ac525d35130000000017a9144795995aff558cc538669ebfecffbe5c9837d5ca870247304402207dd1e7c6c596041276b5285dd3747f586ad819a24acdf0ad60b1faa82af00d3b022046a22dd57df4b72ac165e05b4a6cf8dbecfcfad8f16ae7353df56638ebbf5d1f012103a1a226c5047672af98b2e673751dc69f0140b957753d9c1a789c243100292c6f024730440220670625143c3dfc7a862659a79cbf4ad0f84ff1509bd052cfbfbcdba7adf501f9022015f14a6ee1ae7a8f9fec1070d8a97195422b76a317286c816392cb150d7eb76d012102c910a40bf5726168acc5a8318b0505375e877d4d74448f32ef48156794e657f900000000"
+)
+
+func TestParseTransactionErrors(t *testing.T) {
+	for _, txHex := range []string{legacyTxHex, segwitTxHex} {
+		binary, _ := hex.DecodeString(txHex)
+		tx, err := ParseTransaction(binary)
+		if err != nil {
+			t.Fatalf("parse transaction: %v", err)
+		}
+		if !bytes.Equal(tx.Serialize(), binary) {
+			t.Fatalf("transaction serializes to %x", tx.Serialize())
+		}
+
+		//every prefix is truncated
+		for i := 0; i < len(binary); i++ {
+			_, err := ParseTransaction(binary[:i])
+			var parseErr *ParseError
+			if !errors.Is(err, ErrTruncated) || !errors.As(err, &parseErr) {
+				t.Fatalf("%d bytes of %d give err %v", i, len(binary), err)
+			}
+		}
+		if _, err := ParseTransaction(append(binary, 0x00)); err != ErrTrailingData {
+			t.Fatalf("trailing byte gives err %v", err)
+		}
+	}
+
+	binary, _ := hex.DecodeString(segwitTxHex)
+	badFlag := append([]byte{}, binary...)
+	badFlag[5] = 0x02
+	if _, err := ParseTransaction(badFlag); !errors.Is(err, ErrSegwitFlag) {
+		t.Fatalf("segwit flag 2 gives err %v", err)
+	}
+
+	//the input count of the legacy transaction as 3 bytes varint, and as a huge one
+	binary, _ = hex.DecodeString(legacyTxHex)
+	nonCanonical := append(append(append([]byte{}, binary[:4]...), 0xfd, 0x01, 0x00), binary[5:]...)
+	_, err := ParseTransaction(nonCanonical)
+	if !errors.Is(err, ErrVarIntNonCanonical) {
+		t.Fatalf("non canonical input count gives err %v", err)
+	}
+	if err.Error() != "parse input count: "+ErrVarIntNonCanonical.Error() {
+		t.Fatalf("error message %q", err)
+	}
+	tooLarge := append(append(append([]byte{}, binary[:4]...), 0xfe, 0xff, 0xff, 0xff, 0xff), binary[5:]...)
+	if _, err := ParseTransaction(tooLarge); !errors.Is(err, ErrVarIntTooLarge) {
+		t.Fatalf("huge input count gives err %v", err)
+	}
+
+	//a push running past the end of the script
+	script := []byte{0x03, 0x4c, 0x05, 0x00}
+	if _, err := NewScriptSig(bufio.NewReader(bytes.NewReader(script))); !errors.Is(err, ErrScriptLength) {
+		t.Fatalf("push past the end of script gives err %v", err)
+	}
+}
+
+const blockHeaderHex = "020000208ec39428b17323fa0ddec8e887b4a7c53b8c0a0a220cfd0000000000000000005b0750fce0a889502d40508d39576821155e9c9e3f5c3157f961db38fd8b25be1e77a759e93c0118a4ffd71d"
+
+// socketWriter has no WriteByte, so Encode goes through the pooled buffer
+type socketWriter struct {
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *socketWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	legacy, _ := hex.DecodeString(legacyTxHex)
+	segwit, _ := hex.DecodeString(segwitTxHex)
+	header, _ := hex.DecodeString(blockHeaderHex)
+
+	//a block of two transactions read one byte at a time, Decode must not read ahead
+	raw := append(append(append(append([]byte{}, header...), 0x02), legacy...), segwit...)
+	reader := iotest.OneByteReader(bytes.NewReader(raw))
+	block := &Block{}
+	if err := block.Decode(reader); err != nil {
+		t.Fatalf("decode block header: %v", err)
+	}
+	if !bytes.Equal(block.Serialize(), header) || block.SerializeSize() != BlockHeaderLen {
+		t.Fatalf("block header serializes to %x", block.Serialize())
+	}
+
+	expected := [][]byte{legacy, segwit}
+	var transactions []*Transaction
+	err := DecodeBlockTransactions(reader, func(idx int, transaction *Transaction) error {
+		if !bytes.Equal(transaction.Serialize(), expected[idx]) {
+			t.Fatalf("transaction %d serializes to %x", idx, transaction.Serialize())
+		}
+		transactions = append(transactions, transaction)
+		return nil
+	})
+	if err != nil || len(transactions) != 2 {
+		t.Fatalf("decode block transactions: %d, %v", len(transactions), err)
+	}
+
+	for i, transaction := range transactions {
+		if transaction.SerializeSize() != len(expected[i]) {
+			t.Fatalf("transaction %d size hint %d, serialized %d", i, transaction.SerializeSize(), len(expected[i]))
+		}
+		var w socketWriter
+		if err := transaction.Encode(&w); err != nil {
+			t.Fatalf("encode transaction %d: %v", i, err)
+		}
+		if !bytes.Equal(w.buf.Bytes(), expected[i]) || w.writes != 1 {
+			t.Fatalf("transaction %d encodes in %d writes to %x", i, w.writes, w.buf.Bytes())
+		}
+		for j, output := range transaction.txOutputs {
+			decoded := &TransactionOutput{}
+			if err := decoded.Decode(bytes.NewReader(output.Serialize())); err != nil {
+				t.Fatalf("decode output %d: %v", j, err)
+			}
+			if decoded.SerializeSize() != len(output.Serialize()) || decoded.amount.Cmp(output.amount) != 0 {
+				t.Fatalf("output %d decodes to %s", j, decoded)
+			}
+		}
+	}
+	//the transaction id hashes the legacy serialization even for segwit
+	if fmt.Sprintf("%x", transactions[1].Hash()) != "464dd72e17069e6b55487ae75971df57280091988fbae9ca8fc1abedafddfcbc" {
+		t.Fatalf("segwit transaction id %x", transactions[1].Hash())
+	}
+
+	//truncated block
+	err = DecodeBlockTransactions(bytes.NewReader(append([]byte{0x02}, legacy...)), func(int, *Transaction) error {
+		return nil
+	})
+	if !errors.Is(err, ErrTruncated) || !strings.HasPrefix(err.Error(), "parse transaction 1: ") {
+		t.Fatalf("block with one of two transactions gives err %v", err)
+	}
+}
+
+func FuzzParseTransaction(f *testing.F) {
+	for _, txHex := range []string{legacyTxHex, segwitTxHex} {
+		binary, _ := hex.DecodeString(txHex)
+		f.Add(binary)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tx, err := ParseTransaction(data)
+		if err != nil {
+			return
+		}
+		//whatever parses must serialize again
+		tx.Serialize()
+		tx.Hash()
+	})
+}
+
+func FuzzParseBlock(f *testing.F) {
+	header, _ := hex.DecodeString(blockHeaderHex)
+	f.Add(header)
+	f.Add(header[:79])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block, err := ParseBlock(data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(block.Serialize(), data[:BlockHeaderLen]) {
+			t.Fatalf("header %x serializes to %x", data[:BlockHeaderLen], block.Serialize())
+		}
+	})
+}
@@ -2,43 +2,70 @@ package transaction
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	ecc "github.com/Gharib110/Bitcoin/elliptic_curve"
+)
+
+var (
+	ErrFetchStatus  = errors.New("transaction server did not answer 200 OK")
+	ErrFetchNetwork = errors.New("no transaction server for this network")
 )
 
+/*
+maxFetchBody bounds what we read from the server, the hex of a transaction
+is twice its size and a transaction fits in a block of 4 MB weight
+*/
+const maxFetchBody = 2*4000000 + 1
+
 type TransactionFetcher struct{}
 
 func NewTransactionFetch() *TransactionFetcher {
 	return &TransactionFetcher{}
 }
 
-func (t *TransactionFetcher) getURL(testnet bool) string {
-	if testnet {
-		return "https://blockstream.info/testnet/api/tx"
+// regtest is local to the node running it, no public server knows its transactions
+func (t *TransactionFetcher) getURL(params *ecc.ChainParams) (string, error) {
+	switch params.Name() {
+	case ecc.MainNetParams.Name():
+		return "https://blockstream.info/api/tx", nil
+	case ecc.TestNet3Params.Name():
+		return "https://blockstream.info/testnet/api/tx", nil
+	case ecc.TestNet4Params.Name():
+		return "https://mempool.space/testnet4/api/tx", nil
+	case ecc.SigNetParams.Name():
+		return "https://mempool.space/signet/api/tx", nil
 	}
-
-	return "https://blockstream.info/api/tx"
+	return "", ErrFetchNetwork
 }
 
-func (t *TransactionFetcher) Fetch(txID string, testnet bool) []byte {
-	url := fmt.Sprintf("%s/%s/hex", t.getURL(testnet), txID)
+func (t *TransactionFetcher) Fetch(txID string, params *ecc.ChainParams) ([]byte, error) {
+	baseURL, err := t.getURL(params)
+	if err != nil {
+		return nil, fmt.Errorf("fetch transaction %s on %s: %w", txID, params, err)
+	}
+	url := fmt.Sprintf("%s/%s/hex", baseURL, txID)
 	fmt.Printf("fetching url: %s\n", url)
 	resp, err := http.Get(url)
 	if err != nil {
-		panic(fmt.Sprintf("fetch transaction err:%v\n", err))
+		return nil, fmt.Errorf("fetch transaction %s: %w", txID, err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBody))
 	if err != nil {
-		panic(fmt.Sprintf("read body err:%v\n", err))
+		return nil, fmt.Errorf("read body of transaction %s: %w", txID, err)
 	}
-	fmt.Printf("response: %s\n", string(body))
-	buf, err := hex.DecodeString(string(body))
-
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s for transaction %s", ErrFetchStatus, resp.Status, txID)
+	}
+	buf, err := hex.DecodeString(strings.TrimSpace(string(body)))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("decode transaction %s: %w", txID, err)
 	}
-	return buf
+	return buf, nil
 }
@@ -1,9 +1,11 @@
 package transaction
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math/big"
 
 	"github.com/tsuna/endian"
@@ -21,6 +23,63 @@ const (
 	LittleEndian8Bytes
 )
 
+// MaxVarIntValue the largest count or length ReadVariant accepts, the MAX_SIZE of Bitcoin Core
+const MaxVarIntValue = 0x02000000
+
+var (
+	ErrTruncated          = errors.New("data ends in the middle of a field")
+	ErrTrailingData       = errors.New("unexpected data after the end of the message")
+	ErrVarIntNonCanonical = errors.New("varint is not encoded with the fewest bytes")
+	ErrVarIntTooLarge     = errors.New("varint is larger than the maximum message size")
+)
+
+/*
+ParseError
+the field of a message that could not be parsed, nested messages prefix the
+field with their own, for example "input 1: script"
+*/
+type ParseError struct {
+	Field string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse %s: %v", e.Field, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// NewParseError wraps err for field, running out of data is reported as ErrTruncated
+func NewParseError(field string, err error) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return &ParseError{Field: field + ": " + parseErr.Field, Err: parseErr.Err}
+	}
+	return &ParseError{Field: field, Err: eofToTruncated(err)}
+}
+
+func eofToTruncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+/*
+ReadField
+exactly length bytes of field, the buffer grows while reading so a bogus
+length from a peer does not allocate more than the data really has
+*/
+func ReadField(reader io.Reader, length uint64, field string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, reader, int64(length)); err != nil {
+		return nil, NewParseError(field, err)
+	}
+	return buf.Bytes(), nil
+}
+
 func P2pkScript(h160 []byte) *ScriptSig {
 	scriptContent := [][]byte{[]byte{OP_DUP}, []byte{OP_HASH160},
 		h160, []byte{OP_EQUALVERIFY}, []byte{OP_CHECKSIG}}
@@ -80,7 +139,7 @@ func LittleEndianToBigInt(bytes []byte, length LittleEndianLength) *big.Int {
 	return nil
 }
 
-func ReadVariant(reader *bufio.Reader) *big.Int {
+func ReadVariant(reader io.Reader) (*big.Int, error) {
 	/*
 		1. check the byte after the version, < 0xfd,
 		then the value of the byte is the count of input
@@ -92,30 +151,42 @@ func ReadVariant(reader *bufio.Reader) *big.Int {
 
 		4. if the byte following version is == 0xff, we read the following 8 bytes as a count
 		of input
+
+		a value must use the shortest form, and counts or lengths above
+		MaxVarIntValue can not be in a valid message
 	*/
 	i := make([]byte, 1)
-	reader.Read(i)
-	v := new(big.Int)
-	v.SetBytes(i)
-	if v.Cmp(big.NewInt(int64(0xfd))) < 0 {
-		return v
+	if _, err := io.ReadFull(reader, i); err != nil {
+		return nil, eofToTruncated(err)
 	}
+	return readVariantAfter(i[0], reader)
+}
 
-	if v.Cmp(big.NewInt(int64(0xfd))) == 0 {
-		i1 := make([]byte, 2)
-		reader.Read(i1)
-		return LittleEndianToBigInt(i1, LittleEndian2Bytes)
+// readVariantAfter the rest of a varint whose first byte is already read
+func readVariantAfter(first byte, reader io.Reader) (*big.Int, error) {
+	if first < 0xfd {
+		return big.NewInt(int64(first)), nil
 	}
 
-	if v.Cmp(big.NewInt(int64(0xfe))) == 0 {
-		i1 := make([]byte, 4)
-		reader.Read(i1)
-		return LittleEndianToBigInt(i1, LittleEndian4Bytes)
+	lenBytes, minValue := 8, uint64(0x100000000)
+	switch first {
+	case 0xfd:
+		lenBytes, minValue = 2, 0xfd
+	case 0xfe:
+		lenBytes, minValue = 4, 0x10000
 	}
-
 	i1 := make([]byte, 8)
-	reader.Read(i1)
-	return LittleEndianToBigInt(i1, LittleEndian8Bytes)
+	if _, err := io.ReadFull(reader, i1[:lenBytes]); err != nil {
+		return nil, eofToTruncated(err)
+	}
+	v := binary.LittleEndian.Uint64(i1)
+	if v < minValue {
+		return nil, ErrVarIntNonCanonical
+	}
+	if v > MaxVarIntValue {
+		return nil, ErrVarIntTooLarge
+	}
+	return big.NewInt(int64(v)), nil
 }
 
 func EncodeVariant(v *big.Int) []byte {
@@ -153,3 +224,45 @@ func EncodeVariant(v *big.Int) []byte {
 
 	panic(fmt.Sprintf("integer too large: %x\n", v))
 }
+
+// P2wpkhScript OP_0 <20 bytes hash160 of compressed sec>
+func P2wpkhScript(h160 []byte) *ScriptSig {
+	return InitScriptSig([][]byte{[]byte{OP_0}, h160})
+}
+
+// P2trScript OP_1 <32 bytes x-only output key>
+func P2trScript(outputKey []byte) *ScriptSig {
+	return InitScriptSig([][]byte{[]byte{OP_1}, outputKey})
+}
+
+// P2shScript OP_HASH160 <20 bytes hash160 of redeem script> OP_EQUAL
+func P2shScript(h160 []byte) *ScriptSig {
+	return InitScriptSig([][]byte{[]byte{OP_HASH160}, h160, []byte{OP_EQUAL}})
+}
+
+// P2wshScript OP_0 <32 bytes sha256 of witness script>
+func P2wshScript(h256 []byte) *ScriptSig {
+	return InitScriptSig([][]byte{[]byte{OP_0}, h256})
+}
+
+/*
+witnessProgram
+the version and program of a witness scriptPubKey, OP_0 or OP_1 to OP_16
+followed by one direct push of 2 to 40 bytes and nothing else
+*/
+func witnessProgram(raw []byte) (int, []byte, bool) {
+	if len(raw) < 4 || len(raw) > 42 {
+		return 0, nil, false
+	}
+	if raw[0] != OP_0 && (raw[0] < OP_1 || raw[0] > OP_16) {
+		return 0, nil, false
+	}
+	if int(raw[1])+2 != len(raw) {
+		return 0, nil, false
+	}
+	version := 0
+	if raw[0] != OP_0 {
+		version = int(raw[0]) - OP_1 + 1
+	}
+	return version, raw[2:], true
+}